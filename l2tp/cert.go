@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"l2tp/internal/credential"
+	"l2tp/internal/pki"
+)
+
+// ipsecDir strongSwan 证书目录，内置 CA 与签发的证书均保存在这里
+const ipsecDir = "/etc/ipsec.d"
+
+// caCommonName 内置 CA 的名称
+const caCommonName = "L2TP VPN CA"
+
+func certUsage() {
+	fmt.Println(`用法: l2tp cert <命令> [选项] [名称]
+
+命令:
+  init                      生成 CA 并签发服务端证书
+  issue   <名称>            签发客户端证书
+  issue   -server           重新签发服务端证书
+  revoke  <名称>            吊销客户端证书并更新 CRL
+  export  <名称>            导出客户端证书为 PKCS#12 (.p12)
+  list                      列出已签发的证书
+
+选项:
+  -key-type rsa|ecdsa       私钥类型 (默认沿用安装配置，否则为 rsa)
+  -id <IP或域名>            服务端证书标识 (默认沿用安装配置，否则为公网 IP)
+  -force                    init 时覆盖已有的 CA
+  -out <路径>               export 输出路径 (默认 ./<名称>.p12)
+  -password <密码>          export 的 .p12 密码，省略时随机生成`)
+}
+
+// runCertCommand 处理 l2tp cert 子命令
+func runCertCommand(args []string) error {
+	if len(args) == 0 {
+		certUsage()
+		return fmt.Errorf("缺少子命令")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("cert "+action, flag.ContinueOnError)
+	keyType := fs.String("key-type", "", "私钥类型: rsa 或 ecdsa")
+	serverID := fs.String("id", "", "服务端证书标识")
+	server := fs.Bool("server", false, "签发服务端证书")
+	force := fs.Bool("force", false, "覆盖已有的 CA")
+	out := fs.String("out", "", "PKCS#12 输出路径")
+	password := fs.String("password", "", "PKCS#12 密码")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadInstalledConfig()
+	if err != nil {
+		cfg = &Config{}
+	}
+	if *keyType == "" {
+		*keyType = cfg.IKEv2.KeyType
+	}
+	if *keyType != "" && *keyType != "rsa" && *keyType != "ecdsa" {
+		return fmt.Errorf("私钥类型应为 rsa 或 ecdsa")
+	}
+	ca := certAuthority(*keyType)
+
+	switch action {
+	case "init":
+		if ca.Exists() && !*force {
+			return fmt.Errorf("CA 已存在 (%s)，重新生成会使已签发的证书全部失效，如确需覆盖请加 -force", ca.CACertPath())
+		}
+		id, err := serverCertID(cfg, *serverID)
+		if err != nil {
+			return err
+		}
+		if err := withTransaction(func() error {
+			if err := ca.Init(caCommonName); err != nil {
+				return err
+			}
+			_, err := ca.IssueServer(id)
+			return err
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("%s 已生成 CA: %s\n", Info, ca.CACertPath())
+		fmt.Printf("%s 已签发服务端证书 (%s): %s\n", Info, id, ca.CertPath(pki.ServerName))
+		reloadIPsecCerts(cfg)
+	case "issue":
+		if *server {
+			id, err := serverCertID(cfg, *serverID)
+			if err != nil {
+				return err
+			}
+			if err := withTransaction(func() error {
+				_, err := ca.IssueServer(id)
+				return err
+			}); err != nil {
+				return err
+			}
+			fmt.Printf("%s 已签发服务端证书 (%s): %s\n", Info, id, ca.CertPath(pki.ServerName))
+			reloadIPsecCerts(cfg)
+			return nil
+		}
+		name, err := certName(positional)
+		if err != nil {
+			return err
+		}
+		var record *pki.Record
+		if err := withTransaction(func() error {
+			record, err = ca.IssueClient(name)
+			return err
+		}); err != nil {
+			return err
+		}
+		fmt.Printf("%s 已签发 %s 的客户端证书 (序列号 %s，有效期至 %s)\n", Info, name, record.Serial, record.NotAfter.Format("2006-01-02"))
+		fmt.Printf("%s 可使用 l2tp cert export %s 导出 .p12 文件\n", Tip, name)
+	case "revoke":
+		name, err := certName(positional)
+		if err != nil {
+			return err
+		}
+		if err := withTransaction(func() error {
+			return ca.Revoke(name)
+		}); err != nil {
+			return err
+		}
+		// 让 strongSwan 重新加载 CRL，旧版本不支持时忽略
+		runCommandQuiet("ipsec", "rereadcrls")
+		fmt.Printf("%s 已吊销 %s 的客户端证书，CRL: %s\n", Info, name, ca.CRLPath())
+	case "export":
+		name, err := certName(positional)
+		if err != nil {
+			return err
+		}
+		secret := *password
+		if secret == "" {
+			if secret, err = credential.PasswordPolicy.Generate(); err != nil {
+				return err
+			}
+		}
+		data, err := ca.ExportPKCS12(name, secret)
+		if err != nil {
+			return err
+		}
+		path := *out
+		if path == "" {
+			path = name + ".p12"
+		}
+		if err := writeFile(path, data, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("%s 已导出 %s 的证书: %s\n", Info, name, path)
+		fmt.Printf("%s 导入密码: %s\n", Info, secret)
+	case "list":
+		records, err := ca.List()
+		if err != nil {
+			return err
+		}
+		listCerts(records)
+	default:
+		certUsage()
+		return fmt.Errorf("未知的子命令: %s", action)
+	}
+	return nil
+}
+
+func certAuthority(keyType string) *pki.Authority {
+	return &pki.Authority{Dir: ipsecDir, KeyType: keyType, WriteFile: writeFile}
+}
+
+func certName(positional []string) (string, error) {
+	if len(positional) != 1 {
+		certUsage()
+		return "", fmt.Errorf("需要指定一个证书名称")
+	}
+	return positional[0], nil
+}
+
+// serverCertID 服务端证书标识，依次使用命令行参数、安装配置中的 server_id 与公网 IP
+func serverCertID(cfg *Config, id string) (string, error) {
+	if id != "" {
+		return id, nil
+	}
+	if cfg.IKEv2.ServerID != "" {
+		return cfg.IKEv2.ServerID, nil
+	}
+	return getPublicIP(cfg)
+}
+
+// reloadIPsecCerts 安装时使用的是内置 CA 的证书则重启 strongSwan 使新证书生效
+func reloadIPsecCerts(cfg *Config) {
+	if !cfg.IKEv2.Enabled || cfg.IKEv2.CACert == "" {
+		return
+	}
+	svc := ipsecServiceName()
+	if err := serviceManager().Restart(svc); err != nil {
+		fmt.Printf("%s 警告: 重启 %s 失败: %v\n", Tip, svc, err)
+	}
+}
+
+// ensureServerCert 未指定证书时使用内置 CA 签发服务端证书，CA 不存在时自动生成。
+// publicIPv6 非空时一并写入 SAN，客户端通过 IPv6 连接时同样可以校验
+func ensureServerCert(k *IKEv2Config, publicIPv6 string) error {
+	ca := certAuthority(k.KeyType)
+	if !ca.Exists() {
+		fmt.Printf("%s 正在生成内置 CA...\n", Tip)
+		if err := ca.Init(caCommonName); err != nil {
+			return err
+		}
+	}
+	ids := []string{k.ServerID}
+	if publicIPv6 != "" && publicIPv6 != k.ServerID {
+		ids = append(ids, publicIPv6)
+	}
+	if !ca.ServerCertMatches(ids...) {
+		fmt.Printf("%s 正在签发服务端证书 (%s)...\n", Tip, strings.Join(ids, ", "))
+		if _, err := ca.IssueServer(ids...); err != nil {
+			return err
+		}
+	}
+	k.ServerCert = ca.CertPath(pki.ServerName)
+	k.ServerKey = ca.KeyPath(pki.ServerName)
+	k.CACert = ca.CACertPath()
+	return nil
+}
+
+func listCerts(records []*pki.Record) {
+	fmt.Printf("%-20s %-8s %-34s %-12s %s\n", "名称", "类型", "序列号", "到期", "状态")
+	now := time.Now()
+	for _, r := range records {
+		kind := "客户端"
+		if r.Server {
+			kind = "服务端"
+		}
+		state := "有效"
+		switch {
+		case r.Revoked():
+			state = "已吊销 " + r.RevokedAt.Format("2006-01-02")
+		case now.After(r.NotAfter):
+			state = "已过期"
+		}
+		fmt.Printf("%-20s %-8s %-34s %-12s %s\n", r.Name, kind, r.Serial, r.NotAfter.Format("2006-01-02"), state)
+	}
+	fmt.Printf("\n共 %d 个证书\n", len(records))
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"l2tp/internal/credential"
+	"l2tp/internal/firewall"
+	"l2tp/internal/publicip"
+	"l2tp/internal/split"
+	"l2tp/internal/tproxy"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 安装参数，可通过 -config 指定 YAML/JSON 文件提供，未填写的项使用默认值
+type Config struct {
+	// Protocol 安装的协议: l2tp、pptp 或 both，留空等同于 both
+	Protocol string      `yaml:"protocol,omitempty"`
+	L2TP     L2TPConfig  `yaml:"l2tp"`
+	PPTP     PPTPConfig  `yaml:"pptp"`
+	IKEv2    IKEv2Config `yaml:"ikev2,omitempty"`
+	IPv6     IPv6Config  `yaml:"ipv6,omitempty"`
+	// PublicIP 服务器公网地址，填写后不再自动探测；双栈时用逗号分隔 IPv4 与 IPv6
+	PublicIP string `yaml:"public_ip,omitempty"`
+	// BulkUsers 每个协议额外生成的批量账号数，从 .11 起依次分配静态 IP，默认不生成；
+	// 批量账号占用的 IP 不再可用于 l2tp user add
+	BulkUsers int            `yaml:"bulk_users,omitempty"`
+	Firewall  FirewallConfig `yaml:"firewall,omitempty"`
+	ProxyPort string         `yaml:"proxy_port"`
+	// TProxy 由 -out 开启，将 L2TP 客户端流量转发到 ProxyPort 上的透明代理
+	TProxy bool        `yaml:"tproxy,omitempty"`
+	Proxy  ProxyConfig `yaml:"proxy,omitempty"`
+	// Routing 透明代理的分流列表，未配置时只有私有网段直连
+	Routing        RoutingConfig `yaml:"routing,omitempty"`
+	PasswordPolicy PolicyConfig  `yaml:"password_policy,omitempty"`
+	PSKPolicy      PolicyConfig  `yaml:"psk_policy,omitempty"`
+}
+
+// L2TPConfig L2TP/IPSec 参数
+type L2TPConfig struct {
+	IPRange  string `yaml:"ip_range"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	PSK      string `yaml:"psk"`
+}
+
+// 可选协议
+const (
+	protocolL2TP = "l2tp"
+	protocolPPTP = "pptp"
+	protocolBoth = "both"
+)
+
+// l2tpEnabled 是否安装 L2TP/IPSec
+func (c *Config) l2tpEnabled() bool {
+	return c.Protocol != protocolPPTP
+}
+
+// pptpEnabled 是否安装 PPTP
+func (c *Config) pptpEnabled() bool {
+	return c.Protocol != protocolL2TP
+}
+
+// PPTPConfig PPTP 参数
+type PPTPConfig struct {
+	IPRange  string `yaml:"ip_range"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+// IKEv2Config IKEv2 参数，启用后与 L2TP/IPSec PSK 同时提供服务
+type IKEv2Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Auth 客户端认证方式: eap (EAP-MSCHAPv2，使用 L2TP 主账号) 或 cert (客户端证书)
+	Auth    string `yaml:"auth,omitempty"`
+	IPRange string `yaml:"ip_range,omitempty"`
+	// ServerID 服务端标识，默认使用公网 IP，需与服务端证书的 SAN 一致
+	ServerID string `yaml:"server_id,omitempty"`
+	// ServerCert 与 ServerKey 留空时由内置 CA 签发，见 l2tp cert
+	ServerCert string `yaml:"server_cert,omitempty"`
+	ServerKey  string `yaml:"server_key,omitempty"`
+	// CACert 内置 CA 证书路径，由安装程序填写
+	CACert string `yaml:"ca_cert,omitempty"`
+	// KeyType 服务端私钥类型: rsa 或 ecdsa
+	KeyType string `yaml:"key_type,omitempty"`
+	DNS     string `yaml:"dns,omitempty"`
+}
+
+// applyDefaults 补全未填写的 IKEv2 参数
+func (k *IKEv2Config) applyDefaults() {
+	if k.Auth == "" {
+		k.Auth = "eap"
+	}
+	if k.IPRange == "" {
+		k.IPRange = "10.10.20"
+	}
+	if k.KeyType == "" {
+		k.KeyType = "rsa"
+	}
+	if k.DNS == "" {
+		k.DNS = "8.8.8.8,1.1.1.1"
+	}
+}
+
+// IPv6Config 双栈参数，PPP 客户端每个会话分配一个 /64，由 RA 下发，IKEv2 客户端从地址池分配
+type IPv6Config struct {
+	Enabled bool `yaml:"enabled"`
+	// Mode nat: 使用 ULA 前缀并在出口做 NAT66；routed: 前缀已路由到本机，客户端直接使用公网 IPv6
+	Mode string `yaml:"mode,omitempty"`
+	// Prefix 客户端地址前缀，长度为 /48 到 /54，依次划分出 L2TP、PPTP、IKEv2 各一个 /56
+	Prefix string `yaml:"prefix,omitempty"`
+	DNS    string `yaml:"dns,omitempty"`
+}
+
+// FirewallConfig 防火墙参数。nftables 后端的入站链默认丢弃，SSH 与 VPN 端口自动放行，
+// 主机上的其他服务 (例如网站) 需要在 allow 中列出
+type FirewallConfig struct {
+	// Backend nftables、iptables、firewalld 或 ufw，留空时自动检测
+	Backend string `yaml:"backend,omitempty"`
+	// Allow 额外放行的入站端口，形如 80/tcp、60000-61000/udp
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+// ProxyConfig -out 生成的透明代理，Upstream 为空时只配置分流规则，代理由用户自行部署
+type ProxyConfig struct {
+	// Engine 代理内核: sing-box (默认) 或 xray
+	Engine string `yaml:"engine,omitempty"`
+	// Upstream 上游代理: 分享链接 (ss、vmess、vless、trojan、hysteria2、anytls) 或 SOCKS 地址 host:port
+	Upstream string `yaml:"upstream,omitempty"`
+	// DirectDNS 直连解析代理服务器与 bypass 域名的 DNS，默认 223.5.5.5
+	DirectDNS string `yaml:"direct_dns,omitempty"`
+	// Version 内核未安装时下载的版本，默认使用 tproxy.Versions 中的固定版本
+	Version string `yaml:"version,omitempty"`
+	// SHA256 发布包的 SHA-256，为空时使用 GitHub 发布信息中的摘要
+	SHA256 string `yaml:"sha256,omitempty"`
+}
+
+// directDNS 直连解析使用的 DNS
+func (p ProxyConfig) directDNS() string {
+	if p.DirectDNS != "" {
+		return p.DirectDNS
+	}
+	return proxyDirectDNS
+}
+
+// RoutingConfig 透明代理分流。目的地址属于 proxy 时经过代理，否则属于 bypass 时直连，都不属于时经过代理
+type RoutingConfig struct {
+	// GeoIPDB MaxMind 国家数据库，例如 GeoLite2-Country.mmdb 或 Country.mmdb
+	GeoIPDB string      `yaml:"geoip_db,omitempty"`
+	Bypass  RouteConfig `yaml:"bypass,omitempty"`
+	Proxy   RouteConfig `yaml:"proxy,omitempty"`
+}
+
+// RouteConfig 一组分流目标，文件每行一个网段或域名，# 之后为注释
+type RouteConfig struct {
+	CIDRs     []string `yaml:"cidrs,omitempty"`
+	CIDRFiles []string `yaml:"cidr_files,omitempty"`
+	// GeoIP 国家代码，例如 CN
+	GeoIP []string `yaml:"geoip,omitempty"`
+	// Domains 与 DomainFiles 包含子域名，由 dnsmasq 解析时写入 nftables 集合
+	Domains     []string `yaml:"domains,omitempty"`
+	DomainFiles []string `yaml:"domain_files,omitempty"`
+}
+
+// list 转换为 split 的分流列表
+func (c RouteConfig) list() split.List {
+	return split.List{CIDRs: c.CIDRs, CIDRFiles: c.CIDRFiles, GeoIP: c.GeoIP, Domains: c.Domains, DomainFiles: c.DomainFiles}
+}
+
+// lists 全部分流列表，内置的私有网段总是直连
+func (r *RoutingConfig) lists() split.Lists {
+	lists := split.Lists{GeoIPDB: r.GeoIPDB, Bypass: r.Bypass.list(), Proxy: r.Proxy.list()}
+	lists.Bypass.CIDRs = append(slices.Clone(firewall.Bypass), lists.Bypass.CIDRs...)
+	return lists
+}
+
+// enabled 是否配置了分流列表
+func (r *RoutingConfig) enabled() bool {
+	return !r.Bypass.list().Empty() || !r.Proxy.list().Empty()
+}
+
+// domains 是否需要 dnsmasq 按域名分流
+func (r *RoutingConfig) domains() bool {
+	return len(r.Bypass.Domains)+len(r.Bypass.DomainFiles)+len(r.Proxy.Domains)+len(r.Proxy.DomainFiles) > 0
+}
+
+// IPv6 模式
+const (
+	ipv6ModeNAT    = "nat"
+	ipv6ModeRouted = "routed"
+)
+
+// applyDefaults 补全未填写的 IPv6 参数，nat 模式下随机生成 ULA 前缀 (RFC 4193)
+func (v *IPv6Config) applyDefaults() {
+	if v.Mode == "" {
+		v.Mode = ipv6ModeNAT
+	}
+	if v.Prefix == "" && v.Mode == ipv6ModeNAT {
+		var id [5]byte
+		rand.Read(id[:])
+		addr := netip.AddrFrom16([16]byte{0xfd, id[0], id[1], id[2], id[3], id[4]})
+		v.Prefix = netip.PrefixFrom(addr, 48).String()
+	}
+	if v.DNS == "" {
+		v.DNS = "2001:4860:4860::8888,2606:4700:4700::1111"
+	}
+}
+
+func (v *IPv6Config) validate() error {
+	if v.Mode != "" && v.Mode != ipv6ModeNAT && v.Mode != ipv6ModeRouted {
+		return fmt.Errorf("ipv6.mode 应为 nat 或 routed，当前为 %q", v.Mode)
+	}
+	if err := v.validateDNS(); err != nil {
+		return err
+	}
+	if v.Prefix == "" {
+		if v.Mode == ipv6ModeRouted {
+			return fmt.Errorf("ipv6.mode 为 routed 时必须指定已路由到本机的 ipv6.prefix")
+		}
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(v.Prefix)
+	if err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return fmt.Errorf("ipv6.prefix 应为 IPv6 前缀，例如 fd00:1:2::/48，当前为 %q", v.Prefix)
+	}
+	if prefix.Bits() > 54 {
+		return fmt.Errorf("ipv6.prefix 至少需要 /54，才能划分出 L2TP、PPTP、IKEv2 三个 /56")
+	}
+	if prefix.Masked() != prefix {
+		return fmt.Errorf("ipv6.prefix %s 的主机位不为 0，应为 %s", v.Prefix, prefix.Masked())
+	}
+	return nil
+}
+
+func (v *IPv6Config) validateDNS() error {
+	if v.DNS == "" {
+		return nil
+	}
+	for _, dns := range strings.Split(v.DNS, ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(dns))
+		if err != nil || !addr.Is6() {
+			return fmt.Errorf("ipv6.dns 应为逗号分隔的 IPv6 地址，当前为 %q", v.DNS)
+		}
+	}
+	return nil
+}
+
+// IPv6 子网在前缀中的编号
+const (
+	ipv6SubnetL2TP = iota
+	ipv6SubnetPPTP
+	ipv6SubnetIKEv2
+)
+
+// subnet 返回编号为 index 的 /56 子网，编号写入第 55、56 位
+func (v *IPv6Config) subnet(index int) netip.Prefix {
+	prefix := netip.MustParsePrefix(v.Prefix)
+	b := prefix.Addr().As16()
+	b[6] |= byte(index)
+	return netip.PrefixFrom(netip.AddrFrom16(b), 56)
+}
+
+// installedConfigPath 安装完成后保存最终参数，供 user 等子命令读取
+const installedConfigPath = "/etc/l2tp/config.yaml"
+
+// PolicyConfig 自定义随机生成规则，未填写的项沿用默认值
+type PolicyConfig struct {
+	Length     int      `yaml:"length,omitempty"`
+	Classes    []string `yaml:"classes,omitempty"`
+	MinEntropy float64  `yaml:"min_entropy,omitempty"`
+}
+
+// resolve 在默认规则上叠加自定义项
+func (pc PolicyConfig) resolve(base credential.Policy) (credential.Policy, error) {
+	p := base
+	if pc.Length > 0 {
+		p.Length = pc.Length
+	}
+	if len(pc.Classes) > 0 {
+		classes, err := credential.ParseClasses(pc.Classes)
+		if err != nil {
+			return p, err
+		}
+		p.Classes = classes
+	}
+	if pc.MinEntropy > 0 {
+		p.MinEntropy = pc.MinEntropy
+	}
+	return p, p.Validate()
+}
+
+// passwordPolicy 账号密码的生成与校验规则
+func (c *Config) passwordPolicy() (credential.Policy, error) {
+	p, err := c.PasswordPolicy.resolve(credential.PasswordPolicy)
+	if err != nil {
+		return p, fmt.Errorf("password_policy 无效: %v", err)
+	}
+	return p, nil
+}
+
+// pskPolicy PSK 的生成与校验规则
+func (c *Config) pskPolicy() (credential.Policy, error) {
+	p, err := c.PSKPolicy.resolve(credential.PSKPolicy)
+	if err != nil {
+		return p, fmt.Errorf("psk_policy 无效: %v", err)
+	}
+	return p, nil
+}
+
+var (
+	// nonInteractive 为 true 时不再读取终端输入，缺省项直接使用默认值
+	nonInteractive bool
+	// assumeYes 为 true 时配置相关的确认提示自动选择“是”，不包括切换内核与重启
+	assumeYes bool
+	// allowReboot 为 true 时切换内核与重启的确认自动选择“是”
+	allowReboot bool
+)
+
+// loadConfig 读取 -config 指定的配置文件，其中的密码与 PSK 必须满足强度要求
+func loadConfig(path string) (*Config, error) {
+	cfg, err := decodeConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkSecrets(); err != nil {
+		return nil, fmt.Errorf("配置文件 %s 无效: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// decodeConfig 解析并校验配置文件，YAML 是 JSON 的超集，两种格式统一按 YAML 解析
+func decodeConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %v", err)
+	}
+
+	cfg := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	// 拼错的字段名直接报错，避免无人值守时静默回落到默认值
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("配置文件 %s 无效: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// saveInstalledConfig 保存本次安装使用的参数，格式与 -config 相同，可直接复用
+func saveInstalledConfig(cfg *Config) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return writeFile(installedConfigPath, data, 0600)
+}
+
+// loadInstalledConfig 读取安装时保存的参数
+func loadInstalledConfig() (*Config, error) {
+	if !fileExists(installedConfigPath) {
+		return nil, fmt.Errorf("未找到 %s，请先使用本工具完成安装", installedConfigPath)
+	}
+	return decodeConfig(installedConfigPath)
+}
+
+// publicIP 返回 public_ip 中指定协议族的地址，未指定时为空
+func (c *Config) publicIP(family publicip.Family) string {
+	addrs, err := publicip.ParseOverride(c.PublicIP)
+	if err != nil {
+		return ""
+	}
+	if addr, ok := addrs[family]; ok {
+		return addr.String()
+	}
+	return ""
+}
+
+// validate 校验已填写的字段，空值留给默认值处理
+func (c *Config) validate() error {
+	switch c.Protocol {
+	case "", protocolL2TP, protocolPPTP, protocolBoth:
+	default:
+		return fmt.Errorf("protocol 应为 l2tp、pptp 或 both，当前为 %q", c.Protocol)
+	}
+	for name, prefix := range map[string]string{"l2tp.ip_range": c.L2TP.IPRange, "pptp.ip_range": c.PPTP.IPRange} {
+		if prefix != "" && !validIPPrefix(prefix) {
+			return fmt.Errorf("%s 应为 IPv4 前三段，例如 10.10.10，当前为 %q", name, prefix)
+		}
+	}
+	if _, err := publicip.ParseOverride(c.PublicIP); err != nil {
+		return fmt.Errorf("public_ip 无效: %v", err)
+	}
+	if c.IKEv2.Enabled {
+		if err := c.IKEv2.validate(c); err != nil {
+			return err
+		}
+	}
+	if c.IPv6.Enabled {
+		if err := c.IPv6.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Firewall.Backend != "" && !slices.Contains(firewall.Backends, c.Firewall.Backend) {
+		return fmt.Errorf("firewall.backend 应为 %s 之一，当前为 %q", strings.Join(firewall.Backends, "、"), c.Firewall.Backend)
+	}
+	for _, port := range c.Firewall.Allow {
+		if _, err := firewall.ParsePort(port); err != nil {
+			return fmt.Errorf("firewall.allow: %v", err)
+		}
+	}
+	if c.Proxy.Engine != "" && !slices.Contains(tproxy.Engines, c.Proxy.Engine) {
+		return fmt.Errorf("proxy.engine 应为 %s 之一，当前为 %q", strings.Join(tproxy.Engines, "、"), c.Proxy.Engine)
+	}
+	if c.Proxy.DirectDNS != "" {
+		if _, err := netip.ParseAddr(c.Proxy.DirectDNS); err != nil {
+			return fmt.Errorf("proxy.direct_dns 应为 IP 地址，当前为 %q", c.Proxy.DirectDNS)
+		}
+	}
+	if c.Proxy.Version != "" && !tproxy.ValidVersion(c.Proxy.Version) {
+		return fmt.Errorf("proxy.version 无效: %q，例如 %s", c.Proxy.Version, tproxy.Versions[tproxy.SingBox])
+	}
+	if c.Proxy.SHA256 != "" && !tproxy.ValidSHA256(c.Proxy.SHA256) {
+		return fmt.Errorf("proxy.sha256 应为 64 位十六进制字符")
+	}
+	if c.Proxy.Upstream != "" {
+		if _, err := tproxy.ParseLink(c.Proxy.Upstream); err != nil {
+			return fmt.Errorf("proxy.upstream: %v", err)
+		}
+	}
+	for _, code := range append(slices.Clone(c.Routing.Bypass.GeoIP), c.Routing.Proxy.GeoIP...) {
+		if len(code) != 2 || strings.Trim(strings.ToUpper(code), "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return fmt.Errorf("routing geoip 应为两位国家代码，例如 CN，当前为 %q", code)
+		}
+	}
+	if c.Routing.enabled() && c.Firewall.Backend != "" && c.Firewall.Backend != firewall.NFTables {
+		return fmt.Errorf("routing 分流列表编译为 nftables 集合，需要 firewall.backend: nftables")
+	}
+	for name, port := range map[string]string{"l2tp.port": c.L2TP.Port, "pptp.port": c.PPTP.Port, "proxy_port": c.ProxyPort} {
+		if port != "" && !validPort(port) {
+			return fmt.Errorf("%s 端口无效: %q", name, port)
+		}
+	}
+	if c.BulkUsers < 0 || c.BulkUsers > lastUserHost-firstUserHost {
+		return fmt.Errorf("bulk_users 应在 0-%d 之间，当前为 %d", lastUserHost-firstUserHost, c.BulkUsers)
+	}
+
+	if _, err := c.passwordPolicy(); err != nil {
+		return err
+	}
+	if _, err := c.pskPolicy(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkSecrets 检查手动填写的密码与 PSK 强度
+func (c *Config) checkSecrets() error {
+	passwordPolicy, err := c.passwordPolicy()
+	if err != nil {
+		return err
+	}
+	pskPolicy, err := c.pskPolicy()
+	if err != nil {
+		return err
+	}
+	for name, password := range map[string]string{"l2tp.password": c.L2TP.Password, "pptp.password": c.PPTP.Password} {
+		if password == "" {
+			continue
+		}
+		if err := passwordPolicy.Check(password); err != nil {
+			return fmt.Errorf("%s %v", name, err)
+		}
+	}
+	if c.L2TP.PSK != "" {
+		if err := pskPolicy.Check(c.L2TP.PSK); err != nil {
+			return fmt.Errorf("l2tp.psk %v", err)
+		}
+	}
+	return nil
+}
+
+func (k *IKEv2Config) validate(c *Config) error {
+	if !c.l2tpEnabled() {
+		return fmt.Errorf("IKEv2 依赖 strongSwan 与 L2TP 主账号，不能与 protocol: pptp 同时使用")
+	}
+	if k.Auth != "" && k.Auth != "eap" && k.Auth != "cert" {
+		return fmt.Errorf("ikev2.auth 应为 eap 或 cert，当前为 %q", k.Auth)
+	}
+	if k.KeyType != "" && k.KeyType != "rsa" && k.KeyType != "ecdsa" {
+		return fmt.Errorf("ikev2.key_type 应为 rsa 或 ecdsa，当前为 %q", k.KeyType)
+	}
+	if k.IPRange != "" {
+		if !validIPPrefix(k.IPRange) {
+			return fmt.Errorf("ikev2.ip_range 应为 IPv4 前三段，例如 10.10.20，当前为 %q", k.IPRange)
+		}
+		if k.IPRange == c.L2TP.IPRange || k.IPRange == c.PPTP.IPRange {
+			return fmt.Errorf("ikev2.ip_range 不能与 L2TP/PPTP 网段相同")
+		}
+	}
+	return nil
+}
+
+// validIPPrefix 检查形如 10.10.10 的 /24 网段前缀
+func validIPPrefix(prefix string) bool {
+	ip := net.ParseIP(prefix + ".0")
+	return ip != nil && ip.To4() != nil
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// ask 配置中已有值时直接使用，否则提示并交互读取；非交互模式下返回默认值
+func ask(value, question, prompt, defaultValue string) string {
+	if value != "" {
+		return value
+	}
+	if nonInteractive {
+		return defaultValue
+	}
+	fmt.Println(Tip, question)
+	return readInput(prompt, defaultValue)
+}
+
+// askSecret 与 ask 相同，但交互输入的值必须通过强度检查，不合格时重新输入
+func askSecret(value, question string, policy credential.Policy) (string, error) {
+	defaultValue, err := policy.Generate()
+	if err != nil {
+		return "", err
+	}
+	prompt := fmt.Sprintf("(默认随机生成: %s)", defaultValue)
+	for {
+		input := ask(value, question, prompt, defaultValue)
+		if input == defaultValue || value != "" {
+			return input, nil
+		}
+		if err := policy.Check(input); err != nil {
+			fmt.Printf("%s %v\n", Error, err)
+			continue
+		}
+		return input, nil
+	}
+}
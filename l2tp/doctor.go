@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	"l2tp/internal/doctor"
+	"l2tp/internal/publicip"
+	"l2tp/internal/render"
+	"l2tp/internal/service"
+	"l2tp/internal/split"
+	"l2tp/internal/tproxy"
+)
+
+// errChecksFailed 自检未通过，报告已经输出，只需以非零状态退出
+var errChecksFailed = errors.New("自检未通过")
+
+func doctorUsage() {
+	fmt.Println(`用法: l2tp doctor [选项]
+
+检查 VPN 服务、监听端口、/dev/ppp、内核模块、IP 转发、NAT 规则与公网 IP，
+输出检查结果与修复建议。存在失败项时退出码为 1。
+
+选项:
+  -json                     输出 JSON，供监控系统使用`)
+}
+
+// runDoctorCommand 处理 l2tp doctor 子命令
+func runDoctorCommand(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.Usage = doctorUsage
+	jsonOut := fs.Bool("json", false, "输出 JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		doctorUsage()
+		return fmt.Errorf("未知参数: %s", strings.Join(positional, " "))
+	}
+
+	report := diagnose()
+	if *jsonOut {
+		if err := report.WriteJSON(os.Stdout); err != nil {
+			return err
+		}
+	} else {
+		report.WriteText(os.Stdout, true)
+	}
+	if !report.OK() {
+		return errChecksFailed
+	}
+	return nil
+}
+
+// diagnose 按安装配置检查已启用的协议与功能，找不到安装配置时按全部协议检查
+func diagnose() *doctor.Report {
+	r := &doctor.Report{}
+	cfg, err := loadInstalledConfig()
+	if err != nil {
+		r.Add("安装", "安装配置", doctor.Fail, err.Error(), "尚未安装或配置已被删除，运行 l2tp 重新安装")
+		cfg = &Config{}
+	} else {
+		r.Pass("安装", "安装配置", installedConfigPath)
+	}
+	checkServices(r, cfg)
+	checkSockets(r, cfg)
+	checkKernel(r, cfg)
+	checkNetwork(r, cfg)
+	return r
+}
+
+// checkServices 检查已启用的服务是否正在运行
+func checkServices(r *doctor.Report, cfg *Config) {
+	m := serviceManager()
+	services, _ := vpnServices(cfg)
+	if cfg.TProxy {
+		services = append(services, render.TProxyServiceName)
+	}
+	if cfg.Proxy.Upstream != "" {
+		services = append(services, tproxy.ServiceName)
+	}
+	if cfg.Routing.domains() {
+		services = append(services, split.DNSServiceName)
+	}
+	for _, svc := range services {
+		if m.Active(svc) {
+			r.Pass("服务", svc, "运行中")
+			continue
+		}
+		r.Add("服务", svc, doctor.Fail, "未运行", fmt.Sprintf("%s，失败原因查看 %s", restartCommand(m, svc), m.Logs(svc)))
+	}
+}
+
+// restartCommand 返回手动重启服务的命令
+func restartCommand(m service.Manager, name string) string {
+	switch m.Name() {
+	case service.OpenRC:
+		return "rc-service " + name + " restart"
+	case service.SysV:
+		return m.Path(name) + " restart"
+	}
+	return "systemctl restart " + name
+}
+
+// checkSockets 检查 IKE、L2TP 与 PPTP 端口是否在监听
+func checkSockets(r *doctor.Report, cfg *Config) {
+	udp := listeningPorts("udp", false)
+	tcp := listeningPorts("tcp", true)
+	check := func(proto string, port, owner string, listening map[int]bool) {
+		name := port + "/" + proto
+		if n, err := strconv.Atoi(port); err == nil && listening[n] {
+			r.Pass("端口", name, owner+" 正在监听")
+			return
+		}
+		r.Add("端口", name, doctor.Fail, "未监听", fmt.Sprintf("确认 %s 已启动且端口未被其他程序占用，云服务器还需在安全组放行该端口", owner))
+	}
+	if cfg.l2tpEnabled() {
+		check("udp", "500", "strongSwan", udp)
+		check("udp", "4500", "strongSwan", udp)
+		check("udp", valueOr(cfg.L2TP.Port, "1701"), "xl2tpd", udp)
+	}
+	if cfg.pptpEnabled() {
+		check("tcp", valueOr(cfg.PPTP.Port, "1723"), "pptpd", tcp)
+	}
+}
+
+// listeningPorts 合并 IPv4 与 IPv6 的监听端口
+func listeningPorts(proto string, tcp bool) map[int]bool {
+	ports := make(map[int]bool)
+	for _, name := range []string{proto, proto + "6"} {
+		data, _ := os.ReadFile("/proc/net/" + name)
+		for port := range doctor.ListeningPorts(data, tcp) {
+			ports[port] = true
+		}
+	}
+	return ports
+}
+
+// checkKernel 检查 /dev/ppp 与已启用协议需要的内核模块，只读取状态，不加载模块
+func checkKernel(r *doctor.Report, cfg *Config) {
+	modules := []string{"ppp_generic"}
+	if cfg.l2tpEnabled() {
+		modules = append(modules, "af_key", "l2tp_ppp")
+	}
+	if cfg.pptpEnabled() {
+		modules = append(modules, "ppp_mppe", "nf_nat_pptp")
+	}
+	p := probePPP(modules, pppRequired(cfg), false)
+
+	if p.Device {
+		r.Pass("内核", "/dev/ppp", "存在")
+	} else {
+		_, detail := p.Recommend()
+		r.Add("内核", "/dev/ppp", doctor.Fail, "不存在", detail)
+	}
+
+	for _, mod := range modules {
+		switch p.Modules[mod] {
+		case doctor.ModuleActive:
+			r.Pass("内核", mod, "已加载")
+		case doctor.ModuleBuiltin:
+			r.Pass("内核", mod, "内置")
+		case doctor.ModuleAvailable:
+			r.Add("内核", mod, doctor.Warn, "未加载", "modprobe "+mod+"，开机加载可写入 /etc/modules-load.d/l2tp.conf")
+		default:
+			hint := "安装发行版的标准内核或 linux-modules-extra 软件包，Cloud 内核可运行 l2tp 切换到标准内核"
+			if p.Container {
+				hint = "容器共享宿主机内核，需要在宿主机上安装并加载该模块"
+			}
+			r.Add("内核", mod, doctor.Fail, "当前内核 "+p.Release+" 不提供该模块", hint)
+		}
+	}
+}
+
+// checkNetwork 检查 IP 转发、NAT 规则与公网 IP
+func checkNetwork(r *doctor.Report, cfg *Config) {
+	forwarding := []struct{ key, name string }{{"net.ipv4.ip_forward", "IPv4 转发"}}
+	if cfg.IPv6.Enabled {
+		forwarding = append(forwarding, struct{ key, name string }{"net.ipv6.conf.all.forwarding", "IPv6 转发"})
+	}
+	for _, f := range forwarding {
+		data, err := os.ReadFile(sysctlPath(f.key))
+		if err == nil && strings.TrimSpace(string(data)) == "1" {
+			r.Pass("网络", f.name, "已开启")
+			continue
+		}
+		r.Add("网络", f.name, doctor.Fail, "未开启", "sysctl -w "+f.key+"=1，并确认 "+sysctlDropIn+" 存在且没有编号更大的 sysctl 配置将其关闭")
+	}
+
+	checkNAT(r, cfg)
+	checkPublicIP(r, cfg)
+}
+
+// checkPublicIP 检查回显服务能否一致地确认公网 IP，以及该地址是否在本机网卡上
+func checkPublicIP(r *doctor.Report, cfg *Config) {
+	ip, err4 := discoverPublicIP(publicip.IPv4)
+	if err4 != nil {
+		var err6 error
+		if ip, err6 = discoverPublicIP(publicip.IPv6); err6 != nil && cfg.PublicIP != "" {
+			r.Add("网络", "公网 IP", doctor.Warn, "无法在线确认，使用配置的 public_ip "+cfg.PublicIP, "离线环境可忽略；否则检查服务器的出站网络与 DNS 解析")
+			return
+		} else if err6 != nil {
+			r.Add("网络", "公网 IP", doctor.Fail, fmt.Sprintf("无法确认\n  %v\n  %v", err4, err6),
+				"检查服务器的出站网络与 DNS 解析；离线环境可在安装配置中填写 public_ip")
+			return
+		}
+	}
+	if configured := cfg.publicIP(publicip.FamilyOf(netip.MustParseAddr(ip))); configured != "" && configured != ip {
+		r.Add("网络", "公网 IP", doctor.Warn, fmt.Sprintf("探测到 %s，与配置的 public_ip %s 不一致", ip, configured),
+			"公网地址变更后修改 "+installedConfigPath+" 中的 public_ip 并重新运行 l2tp 安装")
+		return
+	}
+	if localAddress(ip) {
+		r.Pass("网络", "公网 IP", ip)
+		return
+	}
+	r.Add("网络", "公网 IP", doctor.Warn, ip+" 不在本机网卡上 (NAT 或云平台弹性 IP)",
+		"在云平台安全组中放行 UDP 500/4500 与 VPN 端口，IPsec 依赖 NAT-T，客户端需要支持 UDP 封装")
+}
+
+// checkNAT 通过防火墙后端检查客户端网段的地址伪装
+func checkNAT(r *doctor.Report, cfg *Config) {
+	fw, err := newFirewall(cfg)
+	if err != nil {
+		r.Add("网络", "NAT 规则", doctor.Fail, err.Error(), "检查配置文件中的 firewall.backend")
+		return
+	}
+	rules, err := firewallRules(cfg)
+	if err == nil {
+		err = fw.Verify(rules)
+	}
+	if err != nil {
+		r.Add("网络", "NAT 规则", doctor.Fail, fmt.Sprintf("%s: %v", fw.Name(), err), "规则可能被其他程序清空，重新运行 l2tp 安装即可重新下发，不会产生重复规则")
+		return
+	}
+	r.Pass("网络", "NAT 规则", fw.Name())
+}
+
+// localAddress 地址是否配置在本机网卡上
+func localAddress(ip string) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// valueOr value 为空时返回默认值
+func valueOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"l2tp/internal/chap"
+	"l2tp/internal/profile"
+
+	"github.com/skip2/go-qrcode"
+)
+
+func exportUsage() {
+	fmt.Println(`用法: l2tp export [选项] <用户名>
+
+生成可直接导入的客户端配置:
+  mobileconfig              iOS / macOS 描述文件 (仅 L2TP)
+  windows                   Windows PowerShell 脚本 (Add-VpnConnection)
+  nm                        NetworkManager 连接文件
+  linux                     strongSwan + xl2tpd 客户端配置 (仅 L2TP)
+
+选项:
+  -server l2tpd|pptpd       账号所属服务 (默认优先 l2tpd)
+  -format <格式>            只生成指定格式，多个用逗号分隔 (默认全部)
+  -host <IP或域名>          客户端连接的服务器地址 (默认公网 IP)
+  -out <目录>               输出目录 (默认 ./<用户名>-vpn)
+  -qr                       在终端显示连接信息二维码`)
+}
+
+// runExportCommand 处理 l2tp export 子命令
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	server := fs.String("server", "", "账号所属服务: l2tpd 或 pptpd")
+	formats := fs.String("format", strings.Join(profile.Formats, ","), "导出格式，多个用逗号分隔")
+	host := fs.String("host", "", "服务器地址")
+	out := fs.String("out", "", "输出目录")
+	showQR := fs.Bool("qr", false, "在终端显示二维码")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		exportUsage()
+		return fmt.Errorf("需要指定一个用户名")
+	}
+	name := positional[0]
+
+	selected := strings.Split(*formats, ",")
+	for i, f := range selected {
+		selected[i] = strings.TrimSpace(f)
+		if !slices.Contains(profile.Formats, selected[i]) {
+			return fmt.Errorf("未知的导出格式 %q，可选: %s", selected[i], strings.Join(profile.Formats, ", "))
+		}
+	}
+
+	cfg, err := loadInstalledConfig()
+	if err != nil {
+		return err
+	}
+	entry, err := findExportEntry(name, *server)
+	if err != nil {
+		return err
+	}
+
+	p := profile.Profile{
+		Server:   *host,
+		Protocol: profile.L2TP,
+		User:     entry.Client,
+		Password: entry.Secret,
+		PSK:      cfg.L2TP.PSK,
+	}
+	if entry.Server == "pptpd" {
+		p.Protocol, p.PSK = profile.PPTP, ""
+	}
+	if p.Server == "" {
+		if p.Server, err = getPublicIP(cfg); err != nil {
+			return err
+		}
+	}
+	p.Name = fmt.Sprintf("%s-%s", p.Protocol, p.Server)
+
+	dir := *out
+	if dir == "" {
+		dir = name + "-vpn"
+	}
+	for _, format := range selected {
+		if !profile.Supported(format, p.Protocol) {
+			fmt.Printf("%s %s 不支持 %s，已跳过\n", Tip, format, strings.ToUpper(p.Protocol))
+			continue
+		}
+		files, err := profile.Generate(p, format)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			path := filepath.Join(dir, f.Path)
+			if err := writeFile(path, f.Content, f.Mode); err != nil {
+				return err
+			}
+			fmt.Printf("%s 已生成 %s\n", Info, path)
+		}
+	}
+
+	summary := profile.Summary(p)
+	fmt.Println()
+	fmt.Print(summary)
+	if *showQR {
+		qr, err := qrcode.New(summary, qrcode.Medium)
+		if err != nil {
+			return fmt.Errorf("生成二维码失败: %v", err)
+		}
+		fmt.Println()
+		fmt.Print(qr.ToSmallString(false))
+	}
+	return nil
+}
+
+// findExportEntry 查找要导出的账号，未指定服务时优先使用 L2TP 账号
+func findExportEntry(name, server string) (*chap.Entry, error) {
+	file, err := readChapSecrets()
+	if err != nil {
+		return nil, err
+	}
+	servers := chap.Servers
+	if server != "" {
+		if err := chap.ValidServer(server); err != nil {
+			return nil, err
+		}
+		servers = []string{server}
+	}
+	for _, s := range servers {
+		entry := file.Find(name, s)
+		if entry == nil {
+			continue
+		}
+		if entry.Disabled {
+			return nil, fmt.Errorf("%s 账号 %s 已被禁用", s, name)
+		}
+		return entry, nil
+	}
+	return nil, fmt.Errorf("用户 %s 不存在", name)
+}
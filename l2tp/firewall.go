@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"l2tp/internal/firewall"
+	"l2tp/internal/nft"
+	"l2tp/internal/render"
+	"l2tp/internal/split"
+)
+
+// newFirewall 按配置选择防火墙后端，未指定时自动检测，测试中替换为 firewall.Fake
+var newFirewall = func(cfg *Config) (firewall.Firewall, error) {
+	cmd := firewall.Commands{
+		Run:        runCommand,
+		RunQuiet:   runCommandQuiet,
+		Output:     runCommandOutput,
+		ReadFile:   os.ReadFile,
+		WriteFile:  writeFile,
+		RemoveFile: removeFile,
+		Services:   serviceManager(),
+	}
+	if cfg.Firewall.Backend != "" {
+		return firewall.New(cfg.Firewall.Backend, cmd)
+	}
+	return firewall.Detect(cmd), nil
+}
+
+// 透明代理使用的防火墙标记与策略路由表
+const (
+	tproxyMark  = "1"
+	tproxyTable = "100"
+)
+
+// firewallRules 按已启用的协议生成需要放行的端口、客户端网段与透明代理规则，未填写的项跳过
+func firewallRules(cfg *Config) (firewall.Rules, error) {
+	rules := firewall.Rules{Interface: defaultInterface(false), SSHPorts: sshPorts()}
+	addSubnet := func(ipRange string) {
+		if ipRange != "" {
+			rules.Subnets = append(rules.Subnets, ipRange+".0/24")
+		}
+	}
+	if cfg.l2tpEnabled() {
+		rules.UDPPorts = append(rules.UDPPorts, "500", "4500")
+		if cfg.L2TP.Port != "" {
+			rules.UDPPorts = append(rules.UDPPorts, cfg.L2TP.Port)
+		}
+		addSubnet(cfg.L2TP.IPRange)
+		if cfg.IKEv2.Enabled {
+			addSubnet(cfg.IKEv2.IPRange)
+		}
+	}
+	if cfg.pptpEnabled() {
+		if cfg.PPTP.Port != "" {
+			rules.TCPPorts = append(rules.TCPPorts, cfg.PPTP.Port)
+		}
+		rules.GRE = true
+		addSubnet(cfg.PPTP.IPRange)
+	}
+	for _, allow := range cfg.Firewall.Allow {
+		port, err := firewall.ParsePort(allow)
+		if err != nil {
+			return rules, err
+		}
+		rules.Allow = append(rules.Allow, port)
+	}
+	if cfg.IPv6.Enabled && cfg.IPv6.Prefix != "" {
+		rules.Subnets6 = []string{cfg.IPv6.Prefix}
+		if cfg.IPv6.Mode == ipv6ModeNAT {
+			rules.NAT6 = true
+			rules.Interface6 = defaultInterface(true)
+		}
+	}
+	if cfg.TProxy && cfg.ProxyPort != "" {
+		// 分流 L2TP 客户端，只安装 PPTP 时分流 PPTP 客户端
+		ipRange := cfg.L2TP.IPRange
+		if !cfg.l2tpEnabled() {
+			ipRange = cfg.PPTP.IPRange
+		}
+		compiled, err := split.Compile(cfg.Routing.lists())
+		if err != nil {
+			return rules, err
+		}
+		rules.TProxy = &firewall.TProxy{
+			Port:   cfg.ProxyPort,
+			Subnet: ipRange + ".0/24",
+			Mark:   tproxyMark,
+			Bypass: compiled.Bypass,
+			Proxy:  compiled.Proxy,
+		}
+		if compiled.Domains() {
+			rules.TProxy.DNSPort = splitDNSPort
+		}
+	}
+	return rules, nil
+}
+
+// setupFirewall 通过检测到的防火墙后端下发全部规则，可重复执行
+func setupFirewall(cfg *Config) error {
+	fw, err := newFirewall(cfg)
+	if err != nil {
+		return err
+	}
+	rules, err := firewallRules(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.TProxy && cfg.Routing.enabled() && fw.Name() != firewall.NFTables {
+		return fmt.Errorf("routing 分流列表编译为 nftables 集合，当前防火墙为 %s，请改用 firewall.backend: nftables", fw.Name())
+	}
+	fmt.Printf("%s 防火墙: %s\n", Tip, fw.Name())
+	if fw.Name() == firewall.NFTables {
+		fmt.Printf("%s 入站默认丢弃，已放行 SSH 端口 %s\n", Tip, strings.Join(rules.SSHPorts, ", "))
+	}
+	if err := recordMasquerade(fw); err != nil {
+		fmt.Printf("%s 写入安装清单失败，卸载时将保留 firewalld 的地址伪装: %v\n", Tip, err)
+	}
+	return fw.Apply(rules)
+}
+
+// removeFirewall 删除本工具下发的规则，失败时只提示。删除时不需要分流列表，列表文件已不存在也能卸载；
+// keepMasquerade 为 true 时保留安装前已开启的 firewalld 地址伪装
+func removeFirewall(cfg *Config, keepMasquerade bool) {
+	c := *cfg
+	c.Routing = RoutingConfig{}
+	cfg = &c
+	fw, err := newFirewall(cfg)
+	if err == nil {
+		var rules firewall.Rules
+		rules, err = firewallRules(cfg)
+		rules.KeepMasquerade = keepMasquerade
+		if err == nil {
+			err = fw.Remove(rules)
+		}
+	}
+	if err != nil {
+		fmt.Printf("%s 清理防火墙规则失败: %v\n", Tip, err)
+	}
+}
+
+// setupTProxy 配置透明代理分流：策略路由由 l2tp-tproxy 服务在开机时配置，TPROXY 规则由防火墙后端持久化。
+// 重复执行时两者都会调整到当前配置，不会追加重复规则
+func setupTProxy(cfg *Config, port string) error {
+	fmt.Printf("%s 配置透明代理分流规则 (端口: %s)...\n", Tip, port)
+	cfg.ProxyPort = port
+	cfg.TProxy = true
+
+	unit, err := render.TProxyService(render.TProxyRoute{Mark: tproxyMark, Table: tproxyTable})
+	if err != nil {
+		return err
+	}
+	if err := writeServiceFile(unit.Path, unit.Content, unit.Mode); err != nil {
+		return err
+	}
+	// restart 先执行 ExecStop 删除旧规则，再按当前配置添加
+	if err := startService(render.TProxyServiceName); err != nil {
+		return fmt.Errorf("配置策略路由失败: %v", err)
+	}
+
+	if err := setupFirewall(cfg); err != nil {
+		return err
+	}
+	fmt.Printf("%s 透明代理分流规则配置完成\n", Green)
+	return nil
+}
+
+// removeTProxy 停用策略路由服务并删除规则，旧版本直接添加的规则同样会被清理
+func removeTProxy() {
+	if err := removeService(render.TProxyServiceName); err != nil {
+		fmt.Printf("%s 删除 %s 服务失败: %v\n", Tip, render.TProxyServiceName, err)
+	}
+	runCommandQuiet("sh", "-c", fmt.Sprintf("while ip rule del fwmark %s table %s 2>/dev/null; do :; done", tproxyMark, tproxyTable))
+	runCommandQuiet("/bin/ip", "route", "flush", "table", tproxyTable)
+}
+
+// reloadNftables 回滚后按规则文件重新加载本工具的表，文件不存在时删除这些表
+func reloadNftables() {
+	if fileExists(firewall.NFTRulesPath) {
+		if err := runCommand("nft", "-f", firewall.NFTRulesPath); err != nil {
+			fmt.Printf("%s 警告: 重新加载 nftables 规则失败: %v\n", Tip, err)
+		}
+		return
+	}
+	for _, table := range nft.Tables {
+		runCommandQuiet("nft", append([]string{"delete", "table"}, strings.Fields(table)...)...)
+	}
+}
+
+// sshPorts 返回 sshd 实际监听的端口，读取失败时使用 22
+func sshPorts() []string {
+	var ports []string
+	if out, err := runCommandOutput("sshd", "-T"); err == nil {
+		for _, line := range strings.Split(out, "\n") {
+			port, ok := strings.CutPrefix(strings.TrimSpace(line), "port ")
+			if ok && !slices.Contains(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		ports = []string{"22"}
+	}
+	return ports
+}
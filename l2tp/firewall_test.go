@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"l2tp/internal/doctor"
+	"l2tp/internal/firewall"
+	"l2tp/internal/service"
+)
+
+// TestMain 测试结果不依赖主机实际使用的服务管理器，默认按 systemd 生成命令
+func TestMain(m *testing.M) {
+	useServiceManager(service.Systemd)
+	os.Exit(m.Run())
+}
+
+// useServiceManager 将服务管理器替换为指定类型
+func useServiceManager(name string) {
+	sm, err := service.New(name, service.Commands{Run: runCommand, RunQuiet: runCommandQuiet, Output: runCommandOutput})
+	if err != nil {
+		panic(err)
+	}
+	serviceManager = func() service.Manager { return sm }
+}
+
+// useFakeFirewall 将防火墙后端替换为 firewall.Fake，测试结束后恢复
+func useFakeFirewall(t *testing.T) *firewall.Fake {
+	t.Helper()
+	fake := &firewall.Fake{}
+	orig := newFirewall
+	newFirewall = func(*Config) (firewall.Firewall, error) { return fake, nil }
+	t.Cleanup(func() { newFirewall = orig })
+	return fake
+}
+
+func TestSetupFirewall(t *testing.T) {
+	fake := useFakeFirewall(t)
+	cfg := testConfig()
+	cfg.Firewall.Allow = []string{"443/tcp"}
+	if err := setupFirewall(cfg); err != nil {
+		t.Fatal(err)
+	}
+	r := fake.Rules
+	if !slices.Equal(fake.Calls, []string{"apply"}) {
+		t.Fatalf("调用 = %v", fake.Calls)
+	}
+	if !slices.Equal(r.UDPPorts, []string{"500", "4500", "1701"}) || !slices.Equal(r.TCPPorts, []string{"1723"}) || !r.GRE {
+		t.Errorf("端口 = %v %v，GRE = %v", r.UDPPorts, r.TCPPorts, r.GRE)
+	}
+	if !slices.Equal(r.Subnets, []string{"10.10.10.0/24", "10.10.20.0/24", "192.168.30.0/24"}) {
+		t.Errorf("客户端网段 = %v", r.Subnets)
+	}
+	if len(r.Allow) != 1 || r.Allow[0] != (firewall.Port{Proto: "tcp", Ports: "443"}) {
+		t.Errorf("额外放行 = %v", r.Allow)
+	}
+	if r.TProxy != nil {
+		t.Error("未开启 -out 时不应配置透明代理")
+	}
+}
+
+func TestFirewallRulesProtocol(t *testing.T) {
+	useFakeFirewall(t)
+	cfg := testConfig()
+	cfg.Protocol = protocolPPTP
+	cfg.IKEv2.Enabled = false
+	cfg.ProxyPort, cfg.TProxy = "12345", true
+	r, err := firewallRules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.UDPPorts) != 0 || !slices.Equal(r.Subnets, []string{"192.168.30.0/24"}) {
+		t.Errorf("仅 PPTP 时端口 = %v，网段 = %v", r.UDPPorts, r.Subnets)
+	}
+	if r.TProxy == nil || r.TProxy.Subnet != "192.168.30.0/24" || r.TProxy.Port != "12345" {
+		t.Errorf("仅 PPTP 时应分流 PPTP 客户端: %+v", r.TProxy)
+	}
+}
+
+func TestFirewallRulesRouting(t *testing.T) {
+	fake := useFakeFirewall(t)
+	cfg := testConfig()
+	cfg.ProxyPort, cfg.TProxy = "12345", true
+	cfg.Routing.Bypass.CIDRs = []string{"114.114.114.0/24", "10.1.0.0/16"}
+	cfg.Routing.Proxy.Domains = []string{"google.com"}
+	r, err := firewallRules(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(r.TProxy.Bypass, "114.114.114.0/24") || slices.Contains(r.TProxy.Bypass, "10.1.0.0/16") {
+		t.Errorf("直连网段 = %v", r.TProxy.Bypass)
+	}
+	if r.TProxy.DNSPort != splitDNSPort {
+		t.Errorf("配置域名时应重定向 DNS: %+v", r.TProxy)
+	}
+
+	if err := setupFirewall(cfg); err == nil || len(fake.Calls) != 0 {
+		t.Errorf("非 nftables 后端应拒绝分流列表: %v %v", err, fake.Calls)
+	}
+	cfg.Routing.Bypass.CIDRFiles = []string{"/nonexistent/cn.txt"}
+	removeFirewall(cfg, false)
+	if !slices.Equal(fake.Calls, []string{"remove"}) {
+		t.Errorf("列表文件不存在时也应能删除规则: %v", fake.Calls)
+	}
+}
+
+func TestRemoveFirewall(t *testing.T) {
+	fake := useFakeFirewall(t)
+	removeFirewall(testConfig(), true)
+	if !slices.Equal(fake.Calls, []string{"remove"}) {
+		t.Errorf("调用 = %v", fake.Calls)
+	}
+	if !fake.Rules.KeepMasquerade {
+		t.Error("应按安装清单保留 firewalld 的地址伪装")
+	}
+}
+
+func TestSetupTProxyIdempotent(t *testing.T) {
+	fake := useFakeFirewall(t)
+	dryRun, currentPlan = true, &plan{}
+	t.Cleanup(func() { dryRun, currentPlan = false, &plan{} })
+
+	cfg := testConfig()
+	for range 2 {
+		if err := setupTProxy(cfg, "12345"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !cfg.TProxy || fake.Rules.TProxy == nil || fake.Rules.TProxy.Subnet != "10.10.10.0/24" {
+		t.Errorf("透明代理规则 = %+v", fake.Rules.TProxy)
+	}
+	for _, c := range currentPlan.commands {
+		if strings.HasPrefix(c, "/bin/ip rule add") || strings.HasPrefix(c, "iptables") {
+			t.Errorf("不应直接追加规则: %s", c)
+		}
+	}
+	if !slices.Contains(currentPlan.commands, "systemctl restart l2tp-tproxy") {
+		t.Errorf("未通过服务配置策略路由: %v", currentPlan.commands)
+	}
+	if len(currentPlan.files) != 2 || currentPlan.files[0].path != "/etc/systemd/system/l2tp-tproxy.service" {
+		t.Errorf("写入的文件 = %v", currentPlan.files)
+	}
+}
+
+func TestSetupProxy(t *testing.T) {
+	dryRun, currentPlan, nonInteractive = true, &plan{}, true
+	t.Cleanup(func() { dryRun, currentPlan, nonInteractive = false, &plan{}, false })
+
+	cfg := testConfig()
+	cfg.ProxyPort = "12345"
+	if err := setupProxy(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(currentPlan.files) != 0 {
+		t.Errorf("未指定上游时不应生成代理配置: %v", currentPlan.files)
+	}
+
+	cfg.Proxy.Upstream = "127.0.0.1:1080"
+	if err := setupProxy(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Proxy.Engine != "sing-box" {
+		t.Errorf("默认内核 = %q，期望 sing-box", cfg.Proxy.Engine)
+	}
+	if len(currentPlan.files) != 2 || currentPlan.files[0].path != "/etc/l2tp/proxy.json" {
+		t.Errorf("写入的文件 = %v", currentPlan.files)
+	}
+	if !slices.Contains(currentPlan.commands, "systemctl restart l2tp-proxy") {
+		t.Errorf("未启动代理服务: %v", currentPlan.commands)
+	}
+
+	cfg.Proxy.Engine, cfg.Proxy.Upstream = "xray", "hy2://secret@1.2.3.4:8443"
+	if err := setupProxy(cfg); err == nil {
+		t.Error("Xray 不支持 hysteria2，应返回错误")
+	}
+}
+
+func TestSetupTProxyOpenRC(t *testing.T) {
+	useFakeFirewall(t)
+	useServiceManager(service.OpenRC)
+	dryRun, currentPlan = true, &plan{}
+	t.Cleanup(func() {
+		useServiceManager(service.Systemd)
+		dryRun, currentPlan = false, &plan{}
+	})
+
+	if err := setupTProxy(testConfig(), "12345"); err != nil {
+		t.Fatal(err)
+	}
+	if len(currentPlan.files) != 1 || currentPlan.files[0].path != "/etc/init.d/l2tp-tproxy" {
+		t.Errorf("写入的文件 = %v", currentPlan.files)
+	}
+	want := []string{"rc-update add l2tp-tproxy default", "rc-service l2tp-tproxy restart"}
+	if !slices.Equal(currentPlan.commands, want) {
+		t.Errorf("命令 = %v", currentPlan.commands)
+	}
+}
+
+func TestCheckNAT(t *testing.T) {
+	fake := useFakeFirewall(t)
+	r := &doctor.Report{}
+	checkNAT(r, testConfig())
+	fake.Err = errors.New("没有地址伪装规则")
+	checkNAT(r, testConfig())
+	if !slices.Equal(fake.Calls, []string{"verify", "verify"}) {
+		t.Fatalf("调用 = %v", fake.Calls)
+	}
+	if r.Checks[0].Status != doctor.Pass || r.Checks[1].Status != doctor.Fail || r.Checks[1].Hint == "" {
+		t.Errorf("检查结果 = %+v", r.Checks)
+	}
+	if !slices.Equal(fake.Rules.Subnets, []string{"10.10.10.0/24", "10.10.20.0/24", "192.168.30.0/24"}) {
+		t.Errorf("应按安装配置的网段检查: %v", fake.Rules.Subnets)
+	}
+}
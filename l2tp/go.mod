@@ -1,3 +1,15 @@
 module l2tp
 
 go 1.25.1
+
+require (
+	github.com/oschwald/maxminddb-golang v1.12.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.5.0
+)
+
+require (
+	golang.org/x/crypto v0.11.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+)
@@ -0,0 +1,278 @@
+// Package chap 解析与改写 pppd 的 chap-secrets 文件，保留注释与未修改行的原始格式
+package chap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// disabledPrefix 被禁用的账号以该前缀注释掉，便于重新启用
+const disabledPrefix = "#disabled# "
+
+// Servers 允许的服务名，对应 xl2tpd 与 pptpd 配置中的 name
+var Servers = []string{"l2tpd", "pptpd"}
+
+// Entry 一条账号记录
+type Entry struct {
+	Client   string
+	Server   string
+	Secret   string
+	IPs      []string
+	Disabled bool
+}
+
+// IP 返回第一个静态 IP，未分配时为空
+func (e *Entry) IP() string {
+	if len(e.IPs) == 0 || e.IPs[0] == "*" {
+		return ""
+	}
+	return e.IPs[0]
+}
+
+type line struct {
+	raw   string
+	entry *Entry
+	dirty bool
+}
+
+// File chap-secrets 文件内容
+type File struct {
+	lines []*line
+}
+
+// ValidServer 检查服务名是否受支持
+func ValidServer(server string) error {
+	if slices.Contains(Servers, server) {
+		return nil
+	}
+	return fmt.Errorf("无效的服务名 %q，可选: %s", server, strings.Join(Servers, ", "))
+}
+
+// Parse 解析文件内容，无法识别的行按原样保留
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return f, nil
+	}
+	for i, raw := range strings.Split(text, "\n") {
+		l := &line{raw: raw}
+		body, disabled := strings.CutPrefix(strings.TrimSpace(raw), disabledPrefix)
+		if disabled || (body != "" && !strings.HasPrefix(body, "#")) {
+			fields, err := splitFields(body)
+			if err != nil {
+				return nil, fmt.Errorf("第 %d 行: %v", i+1, err)
+			}
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("第 %d 行: 字段不足", i+1)
+			}
+			l.entry = &Entry{Client: fields[0], Server: fields[1], Secret: fields[2], IPs: fields[3:], Disabled: disabled}
+		}
+		f.lines = append(f.lines, l)
+	}
+	return f, nil
+}
+
+// Bytes 重新生成文件内容，仅改动过的行会重新格式化
+func (f *File) Bytes() []byte {
+	var sb strings.Builder
+	for _, l := range f.lines {
+		switch {
+		case l.entry == nil:
+			// 已删除的账号行不再输出
+			if l.dirty {
+				continue
+			}
+			sb.WriteString(l.raw)
+		case l.dirty:
+			sb.WriteString(format(l.entry))
+		default:
+			sb.WriteString(l.raw)
+		}
+		sb.WriteString("\n")
+	}
+	return []byte(sb.String())
+}
+
+// Entries 返回全部账号，包括已禁用的
+func (f *File) Entries() []*Entry {
+	var entries []*Entry
+	for _, l := range f.lines {
+		if l.entry != nil {
+			entries = append(entries, l.entry)
+		}
+	}
+	return entries
+}
+
+// Find 按用户名与服务名查找账号
+func (f *File) Find(client, server string) *Entry {
+	if l := f.find(client, server); l != nil {
+		return l.entry
+	}
+	return nil
+}
+
+func (f *File) find(client, server string) *line {
+	for _, l := range f.lines {
+		if l.entry != nil && l.entry.Client == client && l.entry.Server == server {
+			return l
+		}
+	}
+	return nil
+}
+
+// UsedIPs 返回已分配的静态 IP，包括已禁用账号占用的
+func (f *File) UsedIPs() map[string]bool {
+	used := make(map[string]bool)
+	for _, e := range f.Entries() {
+		for _, ip := range e.IPs {
+			used[ip] = true
+		}
+	}
+	return used
+}
+
+// Add 追加账号，同一服务下用户名不能重复，静态 IP 不能冲突
+func (f *File) Add(e Entry) error {
+	if err := validate(&e); err != nil {
+		return err
+	}
+	if f.find(e.Client, e.Server) != nil {
+		return fmt.Errorf("用户 %s 在 %s 中已存在", e.Client, e.Server)
+	}
+	used := f.UsedIPs()
+	for _, ip := range e.IPs {
+		if ip != "*" && used[ip] {
+			return fmt.Errorf("IP %s 已被占用", ip)
+		}
+	}
+	f.lines = append(f.lines, &line{entry: &e, dirty: true})
+	return nil
+}
+
+// Remove 删除账号
+func (f *File) Remove(client, server string) error {
+	l := f.find(client, server)
+	if l == nil {
+		return notFound(client, server)
+	}
+	l.entry = nil
+	l.dirty = true
+	return nil
+}
+
+// SetSecret 修改密码
+func (f *File) SetSecret(client, server, secret string) error {
+	l := f.find(client, server)
+	if l == nil {
+		return notFound(client, server)
+	}
+	updated := *l.entry
+	updated.Secret = secret
+	if err := validate(&updated); err != nil {
+		return err
+	}
+	l.entry.Secret = secret
+	l.dirty = true
+	return nil
+}
+
+// SetDisabled 禁用或重新启用账号
+func (f *File) SetDisabled(client, server string, disabled bool) error {
+	l := f.find(client, server)
+	if l == nil {
+		return notFound(client, server)
+	}
+	if l.entry.Disabled != disabled {
+		l.entry.Disabled = disabled
+		l.dirty = true
+	}
+	return nil
+}
+
+func notFound(client, server string) error {
+	return fmt.Errorf("用户 %s 在 %s 中不存在", client, server)
+}
+
+func validate(e *Entry) error {
+	if e.Client == "" || strings.ContainsAny(e.Client, " \t\"#\\") {
+		return fmt.Errorf("无效的用户名 %q", e.Client)
+	}
+	if err := ValidServer(e.Server); err != nil {
+		return err
+	}
+	if e.Secret == "" || strings.ContainsAny(e.Secret, "\n\r") {
+		return fmt.Errorf("密码不能为空或包含换行")
+	}
+	return nil
+}
+
+// format 生成一行记录，与安装时生成的文件保持相同的分隔风格
+func format(e *Entry) string {
+	fields := []string{e.Client, e.Server, quote(e.Secret)}
+	fields = append(fields, e.IPs...)
+	s := strings.Join(fields, "    ")
+	if e.Disabled {
+		s = disabledPrefix + s
+	}
+	return s
+}
+
+// quote 含空白或特殊字符的密码需要加引号
+func quote(s string) string {
+	if !strings.ContainsAny(s, " \t\"#\\") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// splitFields 按空白拆分字段，支持双引号与反斜杠转义，遇到 # 注释截止
+func splitFields(s string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField, inQuote, escaped := false, false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			inField = true
+		case inQuote:
+			if r == '"' {
+				inQuote = false
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"':
+			inQuote = true
+			inField = true
+		case r == '#':
+			if inField {
+				fields = append(fields, cur.String())
+			}
+			return fields, nil
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(r)
+			inField = true
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("引号未闭合")
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
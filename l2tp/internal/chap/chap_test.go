@@ -0,0 +1,94 @@
+package chap
+
+import (
+	"strings"
+	"testing"
+)
+
+const sample = `# Secrets for authentication using CHAP
+# client    server    secret    IP addresses
+alice    l2tpd    pass1    10.10.10.10
+bob	pptpd	"with space"	192.168.30.10   # 备注
+#disabled# carol    l2tpd    pass3    10.10.10.11
+`
+
+func TestParseRoundTrip(t *testing.T) {
+	f, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(f.Bytes()); got != sample {
+		t.Errorf("未修改时应原样输出:\n%s", got)
+	}
+
+	entries := f.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("应解析出 3 个账号，实际 %d", len(entries))
+	}
+	if entries[1].Secret != "with space" || entries[1].IP() != "192.168.30.10" {
+		t.Errorf("带引号的密码解析错误: %+v", entries[1])
+	}
+	if !entries[2].Disabled {
+		t.Errorf("carol 应为禁用状态")
+	}
+}
+
+func TestModify(t *testing.T) {
+	f, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add(Entry{Client: "dave", Server: "l2tpd", Secret: `a"b`, IPs: []string{"10.10.10.12"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Remove("alice", "l2tpd"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetDisabled("carol", "l2tpd", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetSecret("bob", "pptpd", "newpass"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Secrets for authentication using CHAP
+# client    server    secret    IP addresses
+bob    pptpd    newpass    192.168.30.10
+carol    l2tpd    pass3    10.10.10.11
+dave    l2tpd    "a\"b"    10.10.10.12
+`
+	got := string(f.Bytes())
+	if got != want {
+		t.Errorf("修改结果不符合预期:\n%s", got)
+	}
+
+	reparsed, err := Parse([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := reparsed.Find("dave", "l2tpd"); e == nil || e.Secret != `a"b` {
+		t.Errorf("转义后的密码应能还原: %+v", e)
+	}
+}
+
+func TestAddValidation(t *testing.T) {
+	f, err := Parse([]byte(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		entry Entry
+		want  string
+	}{
+		{Entry{Client: "alice", Server: "l2tpd", Secret: "x"}, "已存在"},
+		{Entry{Client: "eve", Server: "openvpn", Secret: "x"}, "无效的服务名"},
+		{Entry{Client: "eve", Server: "l2tpd", Secret: "x", IPs: []string{"10.10.10.11"}}, "已被占用"},
+		{Entry{Client: "e ve", Server: "l2tpd", Secret: "x"}, "无效的用户名"},
+	}
+	for _, c := range cases {
+		err := f.Add(c.entry)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("添加 %+v 应返回包含 %q 的错误，实际: %v", c.entry, c.want, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package credential 使用 crypto/rand 生成用户名、密码与 PSK，并对手动输入的值做强度检查
+package credential
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// 字符类别，符号只选取在 chap-secrets 与 ipsec.secrets 中无需转义的字符
+const (
+	Lower   = "abcdefghijklmnopqrstuvwxyz"
+	Upper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits  = "0123456789"
+	Symbols = "-_.+=@"
+)
+
+// classNames 配置文件中使用的类别名称
+var classNames = map[string]string{
+	"lower":   Lower,
+	"upper":   Upper,
+	"digits":  Digits,
+	"symbols": Symbols,
+}
+
+// Policy 生成与校验规则
+type Policy struct {
+	Length     int
+	Classes    []string
+	MinEntropy float64
+}
+
+// 默认规则
+var (
+	UserPolicy     = Policy{Length: 6, Classes: []string{Lower, Digits}}
+	PasswordPolicy = Policy{Length: 12, Classes: []string{Lower, Upper, Digits}, MinEntropy: 50}
+	PSKPolicy      = Policy{Length: 24, Classes: []string{Lower, Upper, Digits}, MinEntropy: 80}
+)
+
+// ParseClasses 将 lower、upper、digits、symbols 转换为字符集
+func ParseClasses(names []string) ([]string, error) {
+	var classes []string
+	for _, name := range names {
+		chars, ok := classNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("未知的字符类别 %q，可选: lower, upper, digits, symbols", name)
+		}
+		classes = append(classes, chars)
+	}
+	return classes, nil
+}
+
+// Validate 检查规则本身是否可用：生成结果需要满足自身的最低熵要求
+func (p Policy) Validate() error {
+	if len(p.Classes) == 0 {
+		return fmt.Errorf("至少需要一种字符类别")
+	}
+	if p.Length < len(p.Classes) {
+		return fmt.Errorf("长度 %d 小于字符类别数 %d", p.Length, len(p.Classes))
+	}
+	if limit := float64(p.Length) * math.Log2(float64(len(strings.Join(p.Classes, "")))); limit < p.MinEntropy {
+		return fmt.Errorf("长度 %d 最多只能提供 %.0f bit 熵，低于要求的 %.0f bit", p.Length, limit, p.MinEntropy)
+	}
+	return nil
+}
+
+// maxAttempts 拒绝采样的最大次数，熵要求接近长度上限时几乎无法生成，避免无限循环
+const maxAttempts = 1000
+
+// Generate 生成随机字符串，每种字符类别至少出现一次
+func (p Policy) Generate() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+	charset := strings.Join(p.Classes, "")
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		b := make([]byte, p.Length)
+		for i := range b {
+			b[i] = charset[randIndex(len(charset))]
+		}
+		s := string(b)
+		// 拒绝采样：缺少某类字符或熵估计不达标时重新生成
+		if p.hasAllClasses(s) && Entropy(s) >= p.MinEntropy {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("连续 %d 次生成的结果都不满足字符类别或 %.0f bit 熵的要求，请增加长度或降低 min_entropy", maxAttempts, p.MinEntropy)
+}
+
+// Check 校验手动输入的值
+func (p Policy) Check(s string) error {
+	if bits := Entropy(s); bits < p.MinEntropy {
+		return fmt.Errorf("强度不足: 估计 %.0f bit，至少需要 %.0f bit，请使用更长或包含大小写字母与数字的组合", bits, p.MinEntropy)
+	}
+	return nil
+}
+
+func (p Policy) hasAllClasses(s string) bool {
+	for _, class := range p.Classes {
+		if !strings.ContainsAny(s, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// Entropy 估计字符串的熵 (bit)：有效长度 × log2(字符集大小)。
+// 与前一个字符相同或连续递增/递减的字符 (aaa、123、cba) 不计入有效长度
+func Entropy(s string) float64 {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return 0
+	}
+
+	pool := 0
+	for _, class := range []string{Lower, Upper, Digits} {
+		if strings.ContainsAny(s, class) {
+			pool += len(class)
+		}
+	}
+	for _, r := range runes {
+		if !strings.ContainsRune(Lower+Upper+Digits, r) {
+			// 其他字符按 33 个 ASCII 符号计
+			pool += 33
+			break
+		}
+	}
+
+	effective := 1
+	for i := 1; i < len(runes); i++ {
+		d := runes[i] - runes[i-1]
+		if d < -1 || d > 1 {
+			effective++
+		}
+	}
+	return float64(effective) * math.Log2(float64(pool))
+}
+
+func randIndex(n int) int {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(fmt.Sprintf("读取系统随机数失败: %v", err))
+	}
+	return int(v.Int64())
+}
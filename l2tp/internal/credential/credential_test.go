@@ -0,0 +1,70 @@
+package credential
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, p := range []Policy{UserPolicy, PasswordPolicy, PSKPolicy} {
+		seen := make(map[string]bool)
+		for i := 0; i < 200; i++ {
+			s, err := p.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s) != p.Length {
+				t.Fatalf("长度应为 %d，实际 %q", p.Length, s)
+			}
+			if !p.hasAllClasses(s) {
+				t.Fatalf("%q 缺少部分字符类别", s)
+			}
+			if err := p.Check(s); err != nil {
+				t.Fatalf("生成的 %q 未通过自身校验: %v", s, err)
+			}
+			if seen[s] {
+				t.Fatalf("连续生成出现重复值 %q", s)
+			}
+			seen[s] = true
+		}
+	}
+}
+
+func TestGenerateGivesUp(t *testing.T) {
+	// 重复的类别让 Validate 按 52 个字符估计上限，实际熵按 26 个小写字母计算，最多约 37.6 bit
+	p := Policy{Length: 8, Classes: []string{Lower, Lower}, MinEntropy: 40}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("规则本身应可用: %v", err)
+	}
+	if _, err := p.Generate(); err == nil {
+		t.Fatal("无法满足的熵要求应返回错误")
+	}
+	if _, err := (Policy{Length: 8}).Generate(); err == nil {
+		t.Fatal("没有字符类别时应返回错误")
+	}
+}
+
+func TestCheckRejectsWeak(t *testing.T) {
+	weak := []string{"", "123456", "password", "aaaaaaaaaaaa", "abcdefgh1234", "Password1"}
+	for _, s := range weak {
+		if err := PasswordPolicy.Check(s); err == nil {
+			t.Errorf("%q 应被判定为弱密码", s)
+		}
+	}
+	if err := PasswordPolicy.Check("x7Kp2mQw9zLr"); err != nil {
+		t.Errorf("随机密码不应被拒绝: %v", err)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	classes, err := ParseClasses([]string{"lower", "symbols"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (Policy{Length: 4, Classes: classes, MinEntropy: 80}).Validate(); err == nil || !strings.Contains(err.Error(), "熵") {
+		t.Errorf("长度不足以达到最低熵时应报错，实际: %v", err)
+	}
+	if _, err := ParseClasses([]string{"emoji"}); err == nil {
+		t.Error("未知类别应报错")
+	}
+}
@@ -0,0 +1,195 @@
+// Package diff 生成按行比较的统一格式 (unified) 差异
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines 每个变更块前后保留的上下文行数
+const contextLines = 3
+
+// maxCells 超过该规模时不再计算最长公共子序列，直接整体替换
+const maxCells = 4_000_000
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	line string
+}
+
+// Unified 返回 a 到 b 的统一格式差异，内容相同时返回空字符串
+func Unified(fromName, toName, a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := compute(splitLines(a), splitLines(b))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks(ops) {
+		sb.WriteString(h)
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// compute 基于最长公共子序列得到逐行编辑序列
+func compute(a, b []string) []op {
+	n, m := len(a), len(b)
+	if n*m > maxCells {
+		ops := make([]op, 0, n+m)
+		for _, l := range a {
+			ops = append(ops, op{opDelete, l})
+		}
+		for _, l := range b {
+			ops = append(ops, op{opInsert, l})
+		}
+		return ops
+	}
+
+	// lcs[i][j] 为 a[i:] 与 b[j:] 的最长公共子序列长度
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]op, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{opEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{opDelete, a[i]})
+			i++
+		default:
+			ops = append(ops, op{opInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, op{opDelete, a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, op{opInsert, b[j]})
+	}
+	return ops
+}
+
+// hunks 将编辑序列按上下文合并为若干变更块
+func hunks(ops []op) []string {
+	var result []string
+	start := 0
+	for start < len(ops) {
+		// 找到下一处变更
+		first := start
+		for first < len(ops) && ops[first].kind == opEqual {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// 向后扩展，直到连续相同行超过两倍上下文
+		end := first
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == opEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*contextLines {
+				break
+			}
+			end = run
+		}
+
+		from := max(first-contextLines, start)
+		to := min(end+contextLines, len(ops))
+		result = append(result, formatHunk(ops, from, to))
+		start = to
+	}
+	return result
+}
+
+func formatHunk(ops []op, from, to int) string {
+	// 计算块起始行号
+	aLine, bLine := 1, 1
+	for _, o := range ops[:from] {
+		if o.kind != opInsert {
+			aLine++
+		}
+		if o.kind != opDelete {
+			bLine++
+		}
+	}
+
+	var body strings.Builder
+	aCount, bCount := 0, 0
+	for _, o := range ops[from:to] {
+		prefix := " "
+		switch o.kind {
+		case opEqual:
+			aCount++
+			bCount++
+		case opDelete:
+			prefix = "-"
+			aCount++
+		case opInsert:
+			prefix = "+"
+			bCount++
+		}
+		body.WriteString(prefix + o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			body.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+
+	// 空范围时按惯例使用前一行行号
+	if aCount == 0 {
+		aLine--
+	}
+	if bCount == 0 {
+		bLine--
+	}
+	return fmt.Sprintf("@@ -%s +%s @@\n%s", rangeSpec(aLine, aCount), rangeSpec(bLine, bCount), body.String())
+}
+
+func rangeSpec(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,39 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	want := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,3 +9,4 @@
+ i
+ j
+ k
++l
+`
+	if got := Unified("old", "new", a, b); got != want {
+		t.Errorf("差异不符合预期:\n%s", got)
+	}
+}
+
+func TestUnifiedNewFile(t *testing.T) {
+	want := "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if got := Unified("old", "new", "", "x\ny\n"); got != want {
+		t.Errorf("差异不符合预期:\n%s", got)
+	}
+}
+
+func TestUnifiedEqual(t *testing.T) {
+	if got := Unified("old", "new", "x\n", "x\n"); got != "" {
+		t.Errorf("内容相同时应返回空字符串，实际:\n%s", got)
+	}
+}
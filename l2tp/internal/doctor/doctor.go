@@ -0,0 +1,115 @@
+// Package doctor 汇总 l2tp doctor 的自检结果，输出带修复建议的文字报告或供监控使用的 JSON
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Status 单项检查的结果
+type Status string
+
+const (
+	Pass Status = "pass"
+	// Warn 不一定影响连接，例如模块未加载但会在首次使用时自动加载
+	Warn Status = "warn"
+	Fail Status = "fail"
+	// Skip 对应的功能未启用
+	Skip Status = "skip"
+)
+
+// Check 一项检查。Hint 为未通过时的修复建议
+type Check struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Status   Status `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// Report 按添加顺序保存的检查结果
+type Report struct {
+	Checks []Check
+}
+
+// Add 添加一项检查
+func (r *Report) Add(category, name string, status Status, detail, hint string) {
+	r.Checks = append(r.Checks, Check{Category: category, Name: name, Status: status, Detail: detail, Hint: hint})
+}
+
+// Pass 添加通过的检查
+func (r *Report) Pass(category, name, detail string) {
+	r.Add(category, name, Pass, detail, "")
+}
+
+// Count 返回指定结果的检查数量
+func (r *Report) Count(status Status) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// OK 没有失败的检查，警告不影响结果
+func (r *Report) OK() bool { return r.Count(Fail) == 0 }
+
+// WriteJSON 输出 JSON 报告，summary 中为各结果的数量
+func (r *Report) WriteJSON(w io.Writer) error {
+	checks := r.Checks
+	if checks == nil {
+		checks = []Check{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		OK      bool           `json:"ok"`
+		Summary map[Status]int `json:"summary"`
+		Checks  []Check        `json:"checks"`
+	}{
+		OK:      r.OK(),
+		Summary: map[Status]int{Pass: r.Count(Pass), Warn: r.Count(Warn), Fail: r.Count(Fail), Skip: r.Count(Skip)},
+		Checks:  checks,
+	})
+}
+
+// marks 各结果的标记与颜色
+var marks = map[Status]struct{ symbol, color string }{
+	Pass: {"✓", "\033[32m"},
+	Warn: {"!", "\033[33m"},
+	Fail: {"✗", "\033[31m"},
+	Skip: {"-", "\033[90m"},
+}
+
+// WriteText 按类别分组输出文字报告，color 为 false 时不输出颜色
+func (r *Report) WriteText(w io.Writer, color bool) {
+	paint := func(status Status, s string) string {
+		if !color {
+			return s
+		}
+		return marks[status].color + s + "\033[0m"
+	}
+	category := ""
+	for _, c := range r.Checks {
+		if c.Category != category {
+			if category != "" {
+				fmt.Fprintln(w)
+			}
+			category = c.Category
+			fmt.Fprintf(w, "[%s]\n", category)
+		}
+		fmt.Fprintf(w, "  %s %s", paint(c.Status, marks[c.Status].symbol), c.Name)
+		if c.Detail != "" {
+			fmt.Fprintf(w, ": %s", c.Detail)
+		}
+		fmt.Fprintln(w)
+		if c.Hint != "" && (c.Status == Fail || c.Status == Warn) {
+			fmt.Fprintf(w, "      修复: %s\n", c.Hint)
+		}
+	}
+	fmt.Fprintf(w, "\n结果: %d 项通过，%d 项警告，%d 项失败，%d 项跳过\n",
+		r.Count(Pass), r.Count(Warn), r.Count(Fail), r.Count(Skip))
+}
@@ -0,0 +1,151 @@
+package doctor
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListeningPorts(t *testing.T) {
+	udp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  101: 00000000:01F4 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 18342 2 0000000000000000 0
+  102: 00000000:1194 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 18344 2 0000000000000000 0
+  103: 0100007F:06A5 0200007F:0035 01 00000000:00000000 00:00000000 00000000     0        0 18345 2 0000000000000000 0
+`
+	ports := ListeningPorts([]byte(udp), false)
+	if !ports[500] || !ports[4500] || ports[1701] || len(ports) != 2 {
+		t.Errorf("UDP 端口 = %v", ports)
+	}
+
+	tcp := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:06BB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 21431 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000000000000:0016 0000000000000000FFFF00000A000002:C350 01 00000000:00000000 00:00000000 00000000     0        0 21432 1 0000000000000000 100 0 0 10 0
+`
+	ports = ListeningPorts([]byte(tcp), true)
+	if !ports[1723] || ports[22] {
+		t.Errorf("TCP 端口 = %v", ports)
+	}
+}
+
+func TestModuleLoaded(t *testing.T) {
+	modules := []byte("l2tp_ppp 45056 0 - Live 0x0000000000000000\nl2tp_core 40960 1 l2tp_ppp, Live 0x0000000000000000\n")
+	builtin := []byte("kernel/net/key/af_key.ko\nkernel/drivers/net/ppp/ppp_generic.ko\n")
+	for name, want := range map[string]bool{"l2tp_ppp": true, "l2tp-ppp": true, "af_key": true, "ppp_generic": true, "nf_nat_pptp": false, "l2tp": false} {
+		if got := ModuleLoaded(modules, builtin, name); got != want {
+			t.Errorf("%s: %v，期望 %v", name, got, want)
+		}
+	}
+}
+
+// report 每种结果各一项
+var report = Report{Checks: []Check{
+	{Category: "服务", Name: "xl2tpd", Status: Pass, Detail: "运行中"},
+	{Category: "服务", Name: "pptpd", Status: Fail, Detail: "未运行", Hint: "systemctl restart pptpd"},
+	{Category: "内核", Name: "nf_nat_pptp", Status: Warn, Detail: "未加载", Hint: "modprobe nf_nat_pptp"},
+	{Category: "网络", Name: "IPv6 转发", Status: Skip, Detail: "未启用 IPv6"},
+}}
+
+func TestAdd(t *testing.T) {
+	var r Report
+	r.Pass("服务", "xl2tpd", "运行中")
+	r.Add("服务", "pptpd", Fail, "未运行", "systemctl restart pptpd")
+	r.Add("内核", "nf_nat_pptp", Warn, "未加载", "modprobe nf_nat_pptp")
+	r.Add("网络", "IPv6 转发", Skip, "未启用 IPv6", "")
+	if !reflect.DeepEqual(r, report) {
+		t.Errorf("Report = %+v，期望 %+v", r, report)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		OK      bool           `json:"ok"`
+		Summary map[string]int `json:"summary"`
+		Checks  []Check        `json:"checks"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("输出不是合法的 JSON: %v\n%s", err, buf.String())
+	}
+	if out.OK || out.Summary["fail"] != 1 || out.Summary["pass"] != 1 || len(out.Checks) != 4 {
+		t.Errorf("JSON = %s", buf.String())
+	}
+	if out.Checks[1].Hint != "systemctl restart pptpd" {
+		t.Errorf("缺少修复建议: %+v", out.Checks[1])
+	}
+
+	buf.Reset()
+	(&Report{}).WriteJSON(&buf)
+	if !strings.Contains(buf.String(), `"checks": []`) {
+		t.Errorf("没有检查项时 checks 应为空数组: %s", buf.String())
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	report.WriteText(&buf, false)
+	want := `[服务]
+  ✓ xl2tpd: 运行中
+  ✗ pptpd: 未运行
+      修复: systemctl restart pptpd
+
+[内核]
+  ! nf_nat_pptp: 未加载
+      修复: modprobe nf_nat_pptp
+
+[网络]
+  - IPv6 转发: 未启用 IPv6
+
+结果: 1 项通过，1 项警告，1 项失败，1 项跳过
+`
+	if buf.String() != want {
+		t.Errorf("报告不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", want, buf.String())
+	}
+}
+
+func TestStateOf(t *testing.T) {
+	dep := []byte("kernel/drivers/net/ppp/ppp_generic.ko.xz: kernel/lib/crc-ccitt.ko.xz\nkernel/drivers/net/ppp/ppp_mppe.ko.zst: kernel/drivers/net/ppp/ppp_generic.ko.zst\nkernel/net/l2tp/l2tp_ppp.ko:\n")
+	files := ModuleFiles(dep)
+	procModules := []byte("ppp_generic 53248 0 - Live 0x0000000000000000\n")
+	builtin := []byte("kernel/net/key/af_key.ko\n")
+	for name, want := range map[string]ModuleState{
+		"ppp_generic": ModuleActive, "ppp_mppe": ModuleAvailable, "l2tp-ppp": ModuleAvailable, "af_key": ModuleBuiltin, "nf_nat_pptp": ModuleMissing,
+	} {
+		if got := StateOf(procModules, builtin, files, name); got != want {
+			t.Errorf("%s: %s，期望 %s", name, got, want)
+		}
+	}
+}
+
+func TestRecommend(t *testing.T) {
+	probe := func(device, container bool, states ...ModuleState) *PPPProbe {
+		return &PPPProbe{
+			Release:   "6.1.0-18-cloud-amd64",
+			Container: container,
+			Device:    device,
+			Modules:   map[string]ModuleState{"ppp_generic": states[0], "ppp_mppe": states[1]},
+			Required:  []string{"ppp_generic", "ppp_mppe"},
+		}
+	}
+	for _, c := range []struct {
+		name string
+		p    *PPPProbe
+		want Fix
+	}{
+		{"正常", probe(true, false, ModuleActive, ModuleBuiltin), FixNone},
+		{"缺少设备节点", probe(false, false, ModuleActive, ModuleActive), FixDevice},
+		{"加载失败", probe(false, false, ModuleAvailable, ModuleActive), FixModprobe},
+		{"模块缺失", probe(false, false, ModuleMissing, ModuleAvailable), FixKernel},
+		{"模块缺失优先于加载失败", probe(false, false, ModuleAvailable, ModuleMissing), FixKernel},
+		{"容器中模块缺失", probe(false, true, ModuleMissing, ModuleMissing), FixContainer},
+		{"容器中缺少设备", probe(false, true, ModuleActive, ModuleActive), FixContainer},
+	} {
+		if got, detail := c.p.Recommend(); got != c.want {
+			t.Errorf("%s: %s (%s)，期望 %s", c.name, got, detail, c.want)
+		}
+	}
+}
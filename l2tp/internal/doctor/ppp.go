@@ -0,0 +1,108 @@
+package doctor
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PPPModules 安装前探测并尝试加载的模块：ppp_generic 提供 /dev/ppp，ppp_mppe 为 PPTP 加密，
+// l2tp_ppp 为内核态 L2TP，af_key 为 IPsec 的 PF_KEY 接口
+var PPPModules = []string{"ppp_generic", "ppp_mppe", "l2tp_ppp", "af_key"}
+
+// ModuleState 模块在当前内核中的状态
+type ModuleState string
+
+const (
+	ModuleActive  ModuleState = "loaded"
+	ModuleBuiltin ModuleState = "builtin"
+	// ModuleAvailable 模块文件存在但尚未加载
+	ModuleAvailable ModuleState = "available"
+	// ModuleMissing 当前内核既未内置也没有模块文件，只能更换内核
+	ModuleMissing ModuleState = "missing"
+)
+
+// ModuleFiles 解析 /lib/modules/<release>/modules.dep，返回当前内核可加载的模块名，- 统一为 _
+func ModuleFiles(modulesDep []byte) map[string]bool {
+	files := make(map[string]bool)
+	for _, line := range strings.Split(string(modulesDep), "\n") {
+		// kernel/drivers/net/ppp/ppp_generic.ko.xz: kernel/lib/crc-ccitt.ko.xz
+		file, _, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name := path.Base(strings.TrimSpace(file))
+		if i := strings.Index(name, ".ko"); i > 0 {
+			files[strings.ReplaceAll(name[:i], "-", "_")] = true
+		}
+	}
+	return files
+}
+
+// StateOf 按 modules.builtin、/proc/modules 与 modules.dep 判断模块状态
+func StateOf(procModules, builtin []byte, files map[string]bool, name string) ModuleState {
+	switch {
+	case ModuleLoaded(nil, builtin, name):
+		return ModuleBuiltin
+	case ModuleLoaded(procModules, nil, name):
+		return ModuleActive
+	case files[strings.ReplaceAll(name, "-", "_")]:
+		return ModuleAvailable
+	}
+	return ModuleMissing
+}
+
+// Fix 修复 PPP 支持的方式，按影响从小到大排列
+type Fix string
+
+const (
+	FixNone Fix = "none"
+	// FixDevice 模块已加载但缺少设备节点，mknod 即可
+	FixDevice Fix = "mknod"
+	// FixModprobe 模块存在但未加载，自动加载失败时需要查看 modprobe 的报错
+	FixModprobe Fix = "modprobe"
+	// FixContainer 容器共享宿主机内核，只能在宿主机上处理
+	FixContainer Fix = "container"
+	// FixKernel 当前内核不提供必需的模块，只能更换内核
+	FixKernel Fix = "kernel"
+)
+
+// PPPProbe 一次 PPP 能力探测的结果
+type PPPProbe struct {
+	Release   string
+	Container bool
+	// Device /dev/ppp 是否存在
+	Device  bool
+	Modules map[string]ModuleState
+	// Required 缺失时无法提供 VPN 的模块，其余模块缺失只影响性能或兼容性
+	Required []string
+}
+
+// Recommend 返回影响最小的修复方式与说明。只有必需的模块在当前内核中不存在时才建议更换内核
+func (p *PPPProbe) Recommend() (Fix, string) {
+	for _, name := range p.Required {
+		if p.Modules[name] != ModuleMissing {
+			continue
+		}
+		if p.Container {
+			return FixContainer, fmt.Sprintf("容器共享宿主机内核，宿主机内核 %s 不提供 %s", p.Release, name)
+		}
+		return FixKernel, fmt.Sprintf("当前内核 %s 不提供 %s (/lib/modules/%s 中没有该模块)", p.Release, name, p.Release)
+	}
+	for _, name := range p.Required {
+		if p.Modules[name] != ModuleAvailable {
+			continue
+		}
+		if p.Container {
+			return FixContainer, fmt.Sprintf("容器内无法加载模块，请在宿主机上执行 modprobe %s", name)
+		}
+		return FixModprobe, fmt.Sprintf("%s 未加载，执行 modprobe %s，失败时查看报错 (开启 Secure Boot 时可能拒绝加载未签名模块)", name, name)
+	}
+	if !p.Device {
+		if p.Container {
+			return FixContainer, "容器中缺少 /dev/ppp，启动容器时需要映射设备 (--device /dev/ppp 与 --cap-add NET_ADMIN)"
+		}
+		return FixDevice, "ppp_generic 已加载但缺少 /dev/ppp，创建设备节点: mknod /dev/ppp c 108 0"
+	}
+	return FixNone, ""
+}
@@ -0,0 +1,59 @@
+package doctor
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// /proc/net/{tcp,udp}[6] 中的连接状态
+const (
+	tcpListen   = "0A"
+	udpUnconned = "07"
+)
+
+// ListeningPorts 解析 /proc/net/tcp、tcp6、udp 或 udp6，返回正在监听的本地端口。
+// 读取 /proc 而不是调用 ss，Alpine 等精简系统上同样可用
+func ListeningPorts(data []byte, tcp bool) map[int]bool {
+	state := udpUnconned
+	if tcp {
+		state = tcpListen
+	}
+	ports := make(map[int]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// sl local_address rem_address st ...，第一行为表头
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != state {
+			continue
+		}
+		_, hex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if port, err := strconv.ParseUint(hex, 16, 16); err == nil {
+			ports[int(port)] = true
+		}
+	}
+	return ports
+}
+
+// ModuleLoaded 模块是否已加载 (/proc/modules) 或编译进内核 (modules.builtin)，
+// 模块名中的 - 与 _ 等价
+func ModuleLoaded(procModules, builtin []byte, name string) bool {
+	name = strings.ReplaceAll(name, "-", "_")
+	for _, line := range strings.Split(string(procModules), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	for _, line := range strings.Split(string(builtin), "\n") {
+		base := strings.TrimSuffix(path.Base(strings.TrimSpace(line)), ".ko")
+		if strings.ReplaceAll(base, "-", "_") == name {
+			return true
+		}
+	}
+	return false
+}
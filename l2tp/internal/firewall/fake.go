@@ -0,0 +1,31 @@
+package firewall
+
+// Fake 只记录调用而不修改系统的 Firewall，供测试使用
+type Fake struct {
+	// Calls 依次记录 apply、remove 与 verify
+	Calls []string
+	// Rules 最近一次调用的参数
+	Rules Rules
+	// Err 非空时所有调用返回该错误
+	Err error
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Apply(r Rules) error {
+	f.Calls = append(f.Calls, "apply")
+	f.Rules = r
+	return f.Err
+}
+
+func (f *Fake) Verify(r Rules) error {
+	f.Calls = append(f.Calls, "verify")
+	f.Rules = r
+	return f.Err
+}
+
+func (f *Fake) Remove(r Rules) error {
+	f.Calls = append(f.Calls, "remove")
+	f.Rules = r
+	return f.Err
+}
@@ -0,0 +1,144 @@
+// Package firewall 将 VPN 端口、NAT 与透明代理规则下发到主机实际使用的防火墙，
+// 支持 nftables、iptables、firewalld 与 ufw，只增删本工具自己的规则
+package firewall
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"l2tp/internal/nft"
+	"l2tp/internal/service"
+)
+
+// Rules 需要下发的规则，各后端按自身能力转换
+type Rules = nft.Rules
+
+// Port 额外放行的端口
+type Port = nft.Port
+
+// TProxy 透明代理参数
+type TProxy = nft.TProxy
+
+// ParsePort 解析 80/tcp、60000-61000/udp 形式的端口
+var ParsePort = nft.ParsePort
+
+// Bypass 透明代理默认绕过的本地与保留网段
+var Bypass = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+}
+
+// 后端名称
+const (
+	NFTables  = "nftables"
+	IPTables  = "iptables"
+	Firewalld = "firewalld"
+	UFW       = "ufw"
+)
+
+// Backends 全部后端
+var Backends = []string{NFTables, IPTables, Firewalld, UFW}
+
+// Firewall 防火墙后端。Apply 可重复调用，每次都以 r 完整替换本工具的规则；
+// Remove 删除 Apply 添加的全部规则，r 为安装时的参数，部分后端需要据此逐条删除；
+// Verify 检查客户端网段的 NAT 是否已在内核中生效，用于 l2tp doctor
+type Firewall interface {
+	Name() string
+	Apply(r Rules) error
+	Remove(r Rules) error
+	Verify(r Rules) error
+}
+
+// Masquerading 安装前调用，返回 firewalld 默认区域的永久配置是否已开启地址伪装，调用方记录在安装清单中，
+// 卸载时据此设置 Rules.KeepMasquerade。其他后端不修改共享的设置，总是返回 false
+func Masquerading(fw Firewall) bool {
+	f, ok := fw.(*firewalld)
+	return ok && f.masquerading()
+}
+
+// Commands 执行命令与读写文件的函数，由调用方注入以支持 dry-run 与事务备份
+type Commands struct {
+	Run func(name string, args ...string) error
+	// RunQuiet 失败时不报错，用于删除可能不存在的规则
+	RunQuiet func(name string, args ...string) error
+	// Output 只读查询，dry-run 模式下同样执行
+	Output     func(name string, args ...string) (string, error)
+	ReadFile   func(path string) ([]byte, error)
+	WriteFile  func(path string, data []byte, perm os.FileMode) error
+	RemoveFile func(path string) error
+	// Services 管理开机加载规则的服务，为空时使用 systemd
+	Services service.Manager
+}
+
+// services 返回注入的服务管理器，未注入时使用 systemd
+func (c Commands) services() service.Manager {
+	if c.Services != nil {
+		return c.Services
+	}
+	m, _ := service.New(service.Systemd, service.Commands{Run: c.Run, RunQuiet: c.RunQuiet, Output: c.Output})
+	return m
+}
+
+// New 创建指定名称的后端
+func New(name string, cmd Commands) (Firewall, error) {
+	switch name {
+	case NFTables:
+		return &nftables{cmd: cmd}, nil
+	case IPTables:
+		return &iptables{cmd: cmd}, nil
+	case Firewalld:
+		return &firewalld{cmd: cmd}, nil
+	case UFW:
+		return &ufw{cmd: cmd}, nil
+	}
+	return nil, fmt.Errorf("未知的防火墙后端 %q，可选: %s", name, strings.Join(Backends, ", "))
+}
+
+// Detect 检测主机正在使用的防火墙。firewalld 与 ufw 启用时会接管规则，必须通过它们配置；
+// 否则 iptables 处于 legacy 模式时使用 iptables，避免与 nftables 规则混用，其余情况使用 nftables
+func Detect(cmd Commands) Firewall {
+	if out, err := cmd.Output("firewall-cmd", "--state"); err == nil && out == "running" {
+		return &firewalld{cmd: cmd}
+	}
+	if out, err := cmd.Output("ufw", "status"); err == nil && strings.Contains(out, "Status: active") {
+		return &ufw{cmd: cmd}
+	}
+	if out, err := cmd.Output("iptables", "--version"); err == nil && strings.Contains(out, "legacy") {
+		return &iptables{cmd: cmd}
+	}
+	if _, err := cmd.Output("nft", "--version"); err != nil {
+		return &iptables{cmd: cmd}
+	}
+	return &nftables{cmd: cmd}
+}
+
+// verifyNATChain 检查 iptables 的 L2TP_VPN_POSTROUTING 链已从 POSTROUTING 跳转，并对每个客户端网段做地址伪装
+func verifyNATChain(cmd Commands, r Rules) error {
+	if _, err := cmd.Output("iptables", "-t", "nat", "-C", chainNAT.parent, "-j", chainNAT.name); err != nil {
+		return fmt.Errorf("nat 表 %s 链中没有跳转到 %s 的规则", chainNAT.parent, chainNAT.name)
+	}
+	out, err := cmd.Output("iptables", "-t", "nat", "-S", chainNAT.name)
+	if err != nil {
+		return fmt.Errorf("读取 %s 链失败: %v", chainNAT.name, err)
+	}
+	return verifySubnets(out, r.Subnets, "MASQUERADE")
+}
+
+// verifySubnets 检查规则输出中每个客户端网段都出现，且包含地址伪装
+func verifySubnets(out string, subnets []string, masquerade string) error {
+	if !strings.Contains(out, masquerade) {
+		return fmt.Errorf("没有地址伪装规则")
+	}
+	for _, s := range subnets {
+		if !strings.Contains(out, s) {
+			return fmt.Errorf("网段 %s 没有地址伪装规则", s)
+		}
+	}
+	return nil
+}
+
+// portArg 将 60000-61000 转换为 iptables、ufw 使用的 60000:61000
+func portArg(ports string) string {
+	return strings.ReplaceAll(ports, "-", ":")
+}
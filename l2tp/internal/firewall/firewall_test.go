@@ -0,0 +1,340 @@
+package firewall
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"l2tp/internal/nft/nfttest"
+)
+
+// recorder 记录执行的命令与写入的文件，outputs 为只读查询的返回值，未列出的查询返回错误
+type recorder struct {
+	commands []string
+	files    map[string]string
+	outputs  map[string]string
+}
+
+func newRecorder(outputs map[string]string) *recorder {
+	return &recorder{files: make(map[string]string), outputs: outputs}
+}
+
+func (r *recorder) commandsFor() Commands {
+	run := func(name string, args ...string) error {
+		r.commands = append(r.commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+	return Commands{
+		Run:      run,
+		RunQuiet: run,
+		Output: func(name string, args ...string) (string, error) {
+			out, ok := r.outputs[strings.Join(append([]string{name}, args...), " ")]
+			if !ok {
+				return "", errors.New("not found")
+			}
+			return out, nil
+		},
+		ReadFile: func(path string) ([]byte, error) {
+			content, ok := r.files[path]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return []byte(content), nil
+		},
+		WriteFile: func(path string, data []byte, perm os.FileMode) error {
+			r.files[path] = string(data)
+			return nil
+		},
+		RemoveFile: func(path string) error {
+			delete(r.files, path)
+			return nil
+		},
+	}
+}
+
+func (r *recorder) ran(command string) bool {
+	return slices.Contains(r.commands, command)
+}
+
+func TestDetect(t *testing.T) {
+	cases := []struct {
+		outputs map[string]string
+		want    string
+	}{
+		{map[string]string{"firewall-cmd --state": "running", "ufw status": "Status: active", "nft --version": "nftables v1.0.6"}, Firewalld},
+		{map[string]string{"firewall-cmd --state": "not running", "ufw status": "Status: active", "nft --version": "nftables v1.0.6"}, UFW},
+		{map[string]string{"ufw status": "Status: inactive", "iptables --version": "iptables v1.8.9 (legacy)", "nft --version": "nftables v1.0.6"}, IPTables},
+		{map[string]string{"iptables --version": "iptables v1.8.9 (nf_tables)", "nft --version": "nftables v1.0.6"}, NFTables},
+		{map[string]string{"iptables --version": "iptables v1.8.4"}, IPTables},
+	}
+	for _, c := range cases {
+		if got := Detect(newRecorder(c.outputs).commandsFor()).Name(); got != c.want {
+			t.Errorf("Detect(%v) = %s，期望 %s", c.outputs, got, c.want)
+		}
+	}
+}
+
+func TestNFTablesApplyRemove(t *testing.T) {
+	rec := newRecorder(nil)
+	rec.files[NFTablesConf] = "#!/usr/sbin/nft -f\nflush ruleset\ntable inet docker {}\n"
+	fw, _ := New(NFTables, rec.commandsFor())
+
+	for range 2 {
+		if err := fw.Apply(nfttest.Rules()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(rec.files[NFTRulesPath], "tproxy to :12345") {
+		t.Errorf("规则文件缺少透明代理:\n%s", rec.files[NFTRulesPath])
+	}
+	if n := strings.Count(rec.files[NFTablesConf], nftInclude); n != 1 {
+		t.Errorf("include 出现 %d 次，期望 1 次", n)
+	}
+	if !rec.ran("nft -f " + NFTRulesPath) {
+		t.Errorf("未加载规则: %v", rec.commands)
+	}
+
+	if err := fw.Remove(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.files[NFTablesConf]; got != "#!/usr/sbin/nft -f\nflush ruleset\ntable inet docker {}\n" {
+		t.Errorf("卸载后 %s = %q，应与安装前一致", NFTablesConf, got)
+	}
+	if _, ok := rec.files[NFTRulesPath]; ok {
+		t.Error("卸载后规则文件应被删除")
+	}
+	for _, c := range rec.commands {
+		if strings.HasPrefix(c, "nft delete table") && !strings.Contains(c, "l2tp_") {
+			t.Errorf("删除了不属于本工具的表: %s", c)
+		}
+	}
+}
+
+func TestNFTablesIncludeRHEL(t *testing.T) {
+	const sysconfig = "/etc/sysconfig/nftables.conf"
+	const original = "# Uncomment the include statement here to load the default config sets.\n# include \"/etc/nftables/main.nft\"\n"
+	rec := newRecorder(map[string]string{
+		"systemctl show -p ExecStart --value nftables": "{ path=/sbin/nft ; argv[]=/sbin/nft -f /etc/sysconfig/nftables.conf ; ignore_errors=no ; start_time=[n/a] ; stop_time=[n/a] ; pid=0 ; code=(null) ; status=0/0 }",
+	})
+	rec.files[sysconfig] = original
+	fw, _ := New(NFTables, rec.commandsFor())
+
+	if err := fw.Apply(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	// RHEL 系的 nftables.service 只加载 /etc/sysconfig/nftables.conf，include 写入其他文件重启后规则会丢失
+	if !strings.Contains(rec.files[sysconfig], nftInclude) {
+		t.Errorf("%s 缺少 include:\n%s", sysconfig, rec.files[sysconfig])
+	}
+	if _, ok := rec.files[NFTablesConf]; ok {
+		t.Errorf("不应创建 %s", NFTablesConf)
+	}
+
+	if err := fw.Remove(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.files[sysconfig]; got != original {
+		t.Errorf("卸载后 %s = %q，应与安装前一致", sysconfig, got)
+	}
+}
+
+func TestNFTablesConfFallback(t *testing.T) {
+	// 非 systemd 主机按已存在的文件选择，Alpine 为 /etc/nftables.nft
+	rec := newRecorder(nil)
+	rec.files["/etc/nftables.nft"] = "#!/usr/sbin/nft -f\n"
+	if got := (&nftables{cmd: rec.commandsFor()}).conf(); got != "/etc/nftables.nft" {
+		t.Errorf("conf() = %s", got)
+	}
+	if got := (&nftables{cmd: newRecorder(nil).commandsFor()}).conf(); got != NFTablesConf {
+		t.Errorf("没有任何主配置时 conf() = %s，期望 %s", got, NFTablesConf)
+	}
+	if got := execStartConf("{ path=/usr/sbin/nft ; argv[]=/usr/sbin/nft -f /etc/nftables.conf ; ignore_errors=no }"); got != NFTablesConf {
+		t.Errorf("execStartConf = %q", got)
+	}
+}
+
+func TestIPTablesApply(t *testing.T) {
+	rec := newRecorder(nil)
+	fw, _ := New(IPTables, rec.commandsFor())
+	if err := fw.Apply(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	script := rec.files[IPTablesScript]
+	for _, want := range []string{
+		"ensure iptables filter L2TP_VPN_INPUT INPUT\n",
+		"iptables -t filter -A L2TP_VPN_INPUT -p udp -m multiport --dports 500,4500,1701 -j ACCEPT\n",
+		"iptables -t filter -A L2TP_VPN_INPUT -p tcp --dport 8000:8100 -j ACCEPT\n",
+		"iptables -t filter -A L2TP_VPN_INPUT ! -i ppp+ -p tcp --dport 12345 -j DROP\n",
+		"iptables -t nat -A L2TP_VPN_POSTROUTING -s 192.168.30.0/24 -o eth0 -j MASQUERADE\n",
+		"iptables -t mangle -A SINGBOX -d 10.0.0.0/8 -j RETURN\n",
+		"iptables -t mangle -A SINGBOX -s 10.10.10.0/24 -p udp -j TPROXY --on-port 12345 --tproxy-mark 1\n",
+		// 未启用 IPv6 时删除残留的链
+		"drop ip6tables nat L2TP_VPN_POSTROUTING POSTROUTING\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("脚本缺少: %s", want)
+		}
+	}
+	if strings.Contains(script, " -P ") || strings.Contains(script, "-F INPUT") {
+		t.Errorf("不应修改内置链:\n%s", script)
+	}
+	for _, want := range []string{"sh " + IPTablesScript, "systemctl enable " + IPTablesServiceName} {
+		if !rec.ran(want) {
+			t.Errorf("缺少命令: %s", want)
+		}
+	}
+	if !strings.Contains(rec.files[iptablesUnitPath], "ExecStart=/bin/sh "+IPTablesScript) {
+		t.Errorf("开机服务不正确:\n%s", rec.files[iptablesUnitPath])
+	}
+
+	if err := fw.Remove(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.files[IPTablesScript]; ok {
+		t.Error("卸载后脚本应被删除")
+	}
+	if !rec.ran("iptables -t mangle -X SINGBOX") || !rec.ran("iptables -D INPUT -p tcp --dport 12345 -j DROP") {
+		t.Errorf("卸载时未清理透明代理规则: %v", rec.commands)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"!", "-i", "ppp+", "-m", "comment", "--comment", "it's"})
+	if want := `! -i ppp+ -m comment --comment 'it'\''s'`; got != want {
+		t.Errorf("shellJoin = %s，期望 %s", got, want)
+	}
+}
+
+func TestFirewalld(t *testing.T) {
+	rec := newRecorder(map[string]string{"firewall-cmd --get-default-zone": "drop"})
+	fw, _ := New(Firewalld, rec.commandsFor())
+	if err := fw.Apply(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"firewall-cmd --permanent --zone=drop --add-port=1701/udp",
+		"firewall-cmd --permanent --zone=drop --add-port=8000-8100/tcp",
+		"firewall-cmd --permanent --zone=drop --add-protocol=gre",
+		"firewall-cmd --permanent --zone=drop --add-masquerade",
+		"firewall-cmd --permanent --zone=trusted --add-source=10.10.10.0/24",
+		"firewall-cmd --permanent --direct --add-rule ipv4 mangle PREROUTING 1 -s 10.10.10.0/24 -p tcp -j TPROXY --on-port 12345 --tproxy-mark 1",
+		"firewall-cmd --reload",
+	} {
+		if !rec.ran(want) {
+			t.Errorf("缺少命令: %s", want)
+		}
+	}
+
+	rec.commands = nil
+	if err := fw.Remove(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	if !rec.ran("firewall-cmd --permanent --zone=drop --remove-port=1701/udp") {
+		t.Errorf("卸载时未删除端口: %v", rec.commands)
+	}
+	if !rec.ran("firewall-cmd --permanent --zone=drop --remove-masquerade") {
+		t.Errorf("地址伪装由本工具开启，卸载时应关闭: %v", rec.commands)
+	}
+}
+
+func TestFirewalldKeepMasquerade(t *testing.T) {
+	// Docker 主机或路由器在安装前已开启地址伪装
+	rec := newRecorder(map[string]string{
+		"firewall-cmd --get-default-zone":                           "public",
+		"firewall-cmd --permanent --zone=public --query-masquerade": "yes",
+	})
+	fw, _ := New(Firewalld, rec.commandsFor())
+	if !Masquerading(fw) {
+		t.Fatal("应识别安装前已开启的地址伪装")
+	}
+	r := nfttest.Rules()
+	r.KeepMasquerade = true
+	if err := fw.Remove(r); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ran("firewall-cmd --permanent --zone=public --remove-masquerade") {
+		t.Errorf("安装前已开启的地址伪装不应被关闭: %v", rec.commands)
+	}
+	if !rec.ran("firewall-cmd --permanent --zone=public --remove-port=1701/udp") {
+		t.Errorf("卸载时未删除端口: %v", rec.commands)
+	}
+
+	if Masquerading(&firewalld{cmd: newRecorder(nil).commandsFor()}) {
+		t.Error("查询失败 (firewall-cmd 输出 no 时以非零状态退出) 应视为未开启")
+	}
+	if nft, _ := New(NFTables, rec.commandsFor()); Masquerading(nft) {
+		t.Error("nftables 后端不修改共享的地址伪装设置")
+	}
+}
+
+func TestUFW(t *testing.T) {
+	rec := newRecorder(nil)
+	original := "# ufw before.rules\n*filter\n:ufw-before-input - [0:0]\nCOMMIT\n"
+	rec.files[UFWBeforeRules] = original
+	fw, _ := New(UFW, rec.commandsFor())
+	for range 2 {
+		if err := fw.Apply(nfttest.Rules()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := rec.files[UFWBeforeRules]
+	if strings.Count(before, ufwBlockBegin) != 1 || !strings.HasSuffix(before, original) {
+		t.Errorf("before.rules 区块不正确:\n%s", before)
+	}
+	for _, want := range []string{
+		"-A L2TP_VPN_POSTROUTING -s 10.10.10.0/24 -o eth0 -j MASQUERADE",
+		"-A SINGBOX -s 10.10.10.0/24 -p tcp -j TPROXY --on-port 12345 --tproxy-mark 1",
+	} {
+		if !strings.Contains(before, want) {
+			t.Errorf("before.rules 缺少 %s", want)
+		}
+	}
+	if _, ok := rec.files[UFWBefore6Rules]; ok {
+		t.Error("未启用 IPv6 时不应创建 before6.rules")
+	}
+	for _, want := range []string{"ufw allow 1723/tcp", "ufw allow 8000:8100/tcp", "ufw route allow from 192.168.30.0/24", "ufw reload"} {
+		if !rec.ran(want) {
+			t.Errorf("缺少命令: %s", want)
+		}
+	}
+
+	if err := fw.Remove(nfttest.Rules()); err != nil {
+		t.Fatal(err)
+	}
+	if rec.files[UFWBeforeRules] != original {
+		t.Errorf("卸载后 before.rules 应恢复原样:\n%s", rec.files[UFWBeforeRules])
+	}
+	if !rec.ran("ufw delete allow 1723/tcp") {
+		t.Error("卸载时未删除 ufw 规则")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	nat := "table ip l2tp_nat {\n\tchain postrouting {\n\t\tip saddr { 10.10.10.0/24, 192.168.30.0/24 } oif \"eth0\" masquerade\n\t}\n}"
+	chain := "-N L2TP_VPN_POSTROUTING\n-A L2TP_VPN_POSTROUTING -s 10.10.10.0/24 -o eth0 -j MASQUERADE\n-A L2TP_VPN_POSTROUTING -s 192.168.30.0/24 -o eth0 -j MASQUERADE"
+	cases := []struct {
+		backend string
+		outputs map[string]string
+		ok      bool
+	}{
+		{NFTables, map[string]string{"nft list table ip l2tp_nat": nat}, true},
+		{NFTables, nil, false},
+		{NFTables, map[string]string{"nft list table ip l2tp_nat": strings.ReplaceAll(nat, ", 192.168.30.0/24", "")}, false},
+		{IPTables, map[string]string{"iptables -t nat -C POSTROUTING -j L2TP_VPN_POSTROUTING": "", "iptables -t nat -S L2TP_VPN_POSTROUTING": chain}, true},
+		{IPTables, map[string]string{"iptables -t nat -S L2TP_VPN_POSTROUTING": chain}, false},
+		{UFW, map[string]string{"iptables -t nat -C POSTROUTING -j L2TP_VPN_POSTROUTING": "", "iptables -t nat -S L2TP_VPN_POSTROUTING": "-N L2TP_VPN_POSTROUTING"}, false},
+		{Firewalld, map[string]string{"firewall-cmd --zone=public --query-masquerade": "yes"}, true},
+		{Firewalld, map[string]string{"firewall-cmd --zone=public --query-masquerade": "no"}, false},
+	}
+	for i, c := range cases {
+		fw, err := New(c.backend, newRecorder(c.outputs).commandsFor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.Verify(nfttest.Rules()); (err == nil) != c.ok {
+			t.Errorf("#%d %s: Verify = %v", i, c.backend, err)
+		}
+	}
+}
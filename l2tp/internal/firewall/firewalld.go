@@ -0,0 +1,92 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+)
+
+// firewalld RHEL 系默认启用，直接写 nftables/iptables 的规则会在 firewalld 重新加载时被覆盖，
+// 因此通过 firewall-cmd 写入永久配置
+type firewalld struct {
+	cmd Commands
+}
+
+func (f *firewalld) Name() string { return Firewalld }
+
+// Apply 在默认区域放行 VPN 端口并开启地址伪装，客户端网段加入 trusted 区域，
+// 透明代理通过 direct 规则写入 mangle 表
+func (f *firewalld) Apply(r Rules) error {
+	for _, args := range f.settings(r, "--add-") {
+		if err := f.cmd.Run("firewall-cmd", args...); err != nil {
+			return err
+		}
+	}
+	return f.cmd.Run("firewall-cmd", "--reload")
+}
+
+// Remove 删除 Apply 添加的设置。安装前已开启的地址伪装 (r.KeepMasquerade) 保留
+func (f *firewalld) Remove(r Rules) error {
+	for _, args := range f.settings(r, "--remove-") {
+		f.cmd.RunQuiet("firewall-cmd", args...)
+	}
+	return f.cmd.Run("firewall-cmd", "--reload")
+}
+
+// Verify 检查默认区域已开启地址伪装
+func (f *firewalld) Verify(Rules) error {
+	zone := f.zone()
+	if out, err := f.cmd.Output("firewall-cmd", "--zone="+zone, "--query-masquerade"); err != nil || out != "yes" {
+		return fmt.Errorf("firewalld 区域 %s 未开启地址伪装", zone)
+	}
+	return nil
+}
+
+// settings 生成 firewall-cmd 参数，action 为 --add- 或 --remove-
+func (f *firewalld) settings(r Rules, action string) [][]string {
+	zone := "--zone=" + f.zone()
+	var settings [][]string
+	add := func(args ...string) {
+		settings = append(settings, append([]string{"--permanent"}, args...))
+	}
+	for _, p := range r.UDPPorts {
+		add(zone, action+"port="+p+"/udp")
+	}
+	for _, p := range r.TCPPorts {
+		add(zone, action+"port="+p+"/tcp")
+	}
+	for _, p := range r.Allow {
+		add(zone, action+"port="+p.Ports+"/"+p.Proto)
+	}
+	if r.GRE {
+		add(zone, action+"protocol=gre")
+	}
+	if action == "--add-" || !r.KeepMasquerade {
+		add(zone, action+"masquerade")
+	}
+	for _, s := range append(r.Subnets, r.Subnets6...) {
+		add("--zone=trusted", action+"source="+s)
+	}
+	if t := r.TProxy; t != nil {
+		for _, b := range t.Bypass {
+			add("--direct", action+"rule", "ipv4", "mangle", "PREROUTING", "0", "-d", b, "-j", "RETURN")
+		}
+		for _, proto := range []string{"tcp", "udp"} {
+			add("--direct", action+"rule", "ipv4", "mangle", "PREROUTING", "1", "-s", t.Subnet, "-p", proto, "-j", "TPROXY", "--on-port", t.Port, "--tproxy-mark", t.Mark)
+		}
+	}
+	return settings
+}
+
+// masquerading 默认区域的永久配置是否已开启地址伪装，未开启时 firewall-cmd 输出 no 并以非零状态退出
+func (f *firewalld) masquerading() bool {
+	out, err := f.cmd.Output("firewall-cmd", "--permanent", "--zone="+f.zone(), "--query-masquerade")
+	return err == nil && out == "yes"
+}
+
+// zone 默认区域，查询失败时使用 public
+func (f *firewalld) zone() string {
+	if out, err := f.cmd.Output("firewall-cmd", "--get-default-zone"); err == nil && out != "" && !strings.ContainsAny(out, " \n") {
+		return out
+	}
+	return "public"
+}
@@ -0,0 +1,228 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chain 本工具的一条自定义链，由内置链 parent 跳转进入，卸载时整条链删除
+type chain struct {
+	bin, table, name, parent string
+}
+
+// 本工具使用的全部链。SINGBOX 沿用旧版本的链名，卸载时可以一并清理旧版本的规则
+var (
+	chainInput      = chain{"iptables", "filter", "L2TP_VPN_INPUT", "INPUT"}
+	chainForward    = chain{"iptables", "filter", "L2TP_VPN_FORWARD", "FORWARD"}
+	chainNAT        = chain{"iptables", "nat", "L2TP_VPN_POSTROUTING", "POSTROUTING"}
+	chainTProxy     = chain{"iptables", "mangle", "SINGBOX", "PREROUTING"}
+	chainForward6   = chain{"ip6tables", "filter", "L2TP_VPN_FORWARD", "FORWARD"}
+	chainNAT6       = chain{"ip6tables", "nat", "L2TP_VPN_POSTROUTING", "POSTROUTING"}
+	iptablesChains  = []chain{chainInput, chainForward, chainNAT, chainTProxy, chainForward6, chainNAT6}
+	iptablesNATOnly = []chain{chainNAT, chainTProxy, chainNAT6}
+)
+
+// chainRules 按 Rules 生成各条链的规则，不需要的链不出现在结果中
+func chainRules(r Rules) map[chain][][]string {
+	rules := make(map[chain][][]string)
+	var input [][]string
+	if t := r.TProxy; t != nil {
+		// 透明代理端口只供 PPP 客户端使用，禁止公网访问
+		for _, proto := range []string{"tcp", "udp"} {
+			input = append(input, []string{"!", "-i", "ppp+", "-p", proto, "--dport", t.Port, "-j", "DROP"})
+		}
+	}
+	if len(r.UDPPorts) > 0 {
+		input = append(input, []string{"-p", "udp", "-m", "multiport", "--dports", strings.Join(r.UDPPorts, ","), "-j", "ACCEPT"})
+	}
+	if len(r.TCPPorts) > 0 {
+		input = append(input, []string{"-p", "tcp", "-m", "multiport", "--dports", strings.Join(r.TCPPorts, ","), "-j", "ACCEPT"})
+	}
+	if r.GRE {
+		input = append(input, []string{"-p", "gre", "-j", "ACCEPT"})
+	}
+	for _, p := range r.Allow {
+		input = append(input, []string{"-p", p.Proto, "--dport", portArg(p.Ports), "-j", "ACCEPT"})
+	}
+	rules[chainInput] = input
+
+	established := []string{"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT"}
+	forward := [][]string{established}
+	for _, s := range r.Subnets {
+		forward = append(forward, []string{"-s", s, "-j", "ACCEPT"})
+		rules[chainNAT] = append(rules[chainNAT], []string{"-s", s, "-o", r.Interface, "-j", "MASQUERADE"})
+	}
+	rules[chainForward] = forward
+
+	if len(r.Subnets6) > 0 {
+		forward6 := [][]string{established}
+		for _, s := range r.Subnets6 {
+			forward6 = append(forward6, []string{"-s", s, "-j", "ACCEPT"})
+			if r.NAT6 {
+				rules[chainNAT6] = append(rules[chainNAT6], []string{"-s", s, "-o", r.Interface6, "-j", "MASQUERADE"})
+			}
+		}
+		rules[chainForward6] = forward6
+	}
+
+	if t := r.TProxy; t != nil {
+		var tproxy [][]string
+		for _, b := range t.Bypass {
+			tproxy = append(tproxy, []string{"-d", b, "-j", "RETURN"})
+		}
+		for _, proto := range []string{"tcp", "udp"} {
+			tproxy = append(tproxy, []string{"-s", t.Subnet, "-p", proto, "-j", "TPROXY", "--on-port", t.Port, "--tproxy-mark", t.Mark})
+		}
+		rules[chainTProxy] = tproxy
+	}
+	return rules
+}
+
+// iptables 的规则不会自动保存，Apply 生成可重复执行的脚本并由开机服务执行
+const (
+	IPTablesScript      = "/etc/l2tp/iptables.sh"
+	IPTablesServiceName = "l2tp-iptables"
+	iptablesUnitPath    = "/etc/systemd/system/" + IPTablesServiceName + ".service"
+)
+
+const iptablesUnit = `[Unit]
+Description=L2TP VPN iptables 规则
+After=network-pre.target
+Before=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh ` + IPTablesScript + `
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// iptables 在独立的自定义链中维护规则，不修改内置链的默认策略。
+// iptables 为 legacy 模式或没有 nft 命令时使用
+type iptables struct {
+	cmd Commands
+}
+
+func (f *iptables) Name() string { return IPTables }
+
+// Apply 写入脚本并执行，脚本重建本工具的链，不再需要的链 (例如关闭透明代理后) 一并删除
+func (f *iptables) Apply(r Rules) error {
+	if err := f.cmd.WriteFile(IPTablesScript, iptablesScript(r), 0755); err != nil {
+		return err
+	}
+	services := f.cmd.services()
+	unit, err := services.Install(IPTablesServiceName, []byte(iptablesUnit))
+	if err != nil {
+		return err
+	}
+	if err := f.cmd.WriteFile(unit.Path, unit.Content, unit.Mode); err != nil {
+		return err
+	}
+	if err := f.cmd.Run("sh", IPTablesScript); err != nil {
+		return fmt.Errorf("加载 iptables 规则失败: %v", err)
+	}
+	if err := services.Reload(); err != nil {
+		return fmt.Errorf("重新加载服务配置失败: %v", err)
+	}
+	return services.Enable(IPTablesServiceName)
+}
+
+// Remove 删除本工具的全部链、开机脚本，以及旧版本直接插入 INPUT 的透明代理端口规则
+func (f *iptables) Remove(r Rules) error {
+	for _, c := range iptablesChains {
+		f.cmd.RunQuiet(c.bin, "-t", c.table, "-D", c.parent, "-j", c.name)
+		f.cmd.RunQuiet(c.bin, "-t", c.table, "-F", c.name)
+		f.cmd.RunQuiet(c.bin, "-t", c.table, "-X", c.name)
+	}
+	if r.TProxy != nil {
+		for _, proto := range []string{"tcp", "udp"} {
+			f.cmd.RunQuiet("iptables", "-D", "INPUT", "-p", proto, "--dport", r.TProxy.Port, "-j", "DROP")
+		}
+	}
+	services := f.cmd.services()
+	services.Disable(IPTablesServiceName)
+	if err := f.cmd.RemoveFile(services.Path(IPTablesServiceName)); err != nil {
+		return err
+	}
+	return f.cmd.RemoveFile(IPTablesScript)
+}
+
+func (f *iptables) Verify(r Rules) error { return verifyNATChain(f.cmd, r) }
+
+// iptablesScript 生成 sh 脚本。链已存在时清空后重建，跳转规则先检查再插入，重复执行不会产生重复规则
+func iptablesScript(r Rules) []byte {
+	var sb strings.Builder
+	sb.WriteString(`#!/bin/sh
+# 由 l2tp 生成，请勿手动修改
+set -e
+
+# ensure <命令> <表> <链> <内置链>: 创建或清空链，并确保内置链的第一条规则跳转到该链
+ensure() {
+    "$1" -t "$2" -N "$3" 2>/dev/null || true
+    "$1" -t "$2" -F "$3"
+    "$1" -t "$2" -C "$4" -j "$3" 2>/dev/null || "$1" -t "$2" -I "$4" 1 -j "$3"
+}
+
+# drop <命令> <表> <链> <内置链>: 删除不再需要的链
+drop() {
+    "$1" -t "$2" -D "$4" -j "$3" 2>/dev/null || true
+    "$1" -t "$2" -F "$3" 2>/dev/null || true
+    "$1" -t "$2" -X "$3" 2>/dev/null || true
+}
+`)
+	rules := chainRules(r)
+	for _, c := range iptablesChains {
+		sb.WriteString("\n")
+		list, ok := rules[c]
+		if !ok {
+			fmt.Fprintf(&sb, "drop %s %s %s %s\n", c.bin, c.table, c.name, c.parent)
+			continue
+		}
+		fmt.Fprintf(&sb, "ensure %s %s %s %s\n", c.bin, c.table, c.name, c.parent)
+		for _, rule := range list {
+			fmt.Fprintf(&sb, "%s -t %s -A %s %s\n", c.bin, c.table, c.name, shellJoin(rule))
+		}
+	}
+	return []byte(sb.String())
+}
+
+// shellJoin 拼接 sh 参数，含特殊字符的参数加单引号
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.,:/+!-") == "" {
+			quoted[i] = arg
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// restoreBlock 生成 iptables-restore 格式的规则，供 ufw 的 before.rules 使用
+func restoreBlock(bin string, rules map[chain][][]string) string {
+	var sb strings.Builder
+	for _, table := range []string{"nat", "mangle"} {
+		var chains []chain
+		for _, c := range iptablesNATOnly {
+			if _, ok := rules[c]; ok && c.bin == bin && c.table == table {
+				chains = append(chains, c)
+			}
+		}
+		if len(chains) == 0 {
+			continue
+		}
+		sb.WriteString("*" + table + "\n")
+		for _, c := range chains {
+			sb.WriteString(":" + c.name + " - [0:0]\n")
+			sb.WriteString("-A " + c.parent + " -j " + c.name + "\n")
+			for _, rule := range rules[c] {
+				sb.WriteString("-A " + c.name + " " + strings.Join(rule, " ") + "\n")
+			}
+		}
+		sb.WriteString("COMMIT\n")
+	}
+	return sb.String()
+}
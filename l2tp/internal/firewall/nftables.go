@@ -0,0 +1,153 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"l2tp/internal/nft"
+)
+
+// NFTRulesPath 本工具的 nftables 规则文件，由发行版的 nftables 主配置通过 include 在开机时加载
+const NFTRulesPath = "/etc/l2tp/nftables.nft"
+
+// NFTablesConf Debian/Ubuntu 的 nftables 服务加载的主配置文件，只在其中追加一行 include
+const NFTablesConf = "/etc/nftables.conf"
+
+// NFTablesConfs 各发行版 nftables 服务开机加载的主配置文件: RHEL 系为 /etc/sysconfig/nftables.conf，
+// Alpine 为 /etc/nftables.nft，其余为 /etc/nftables.conf
+var NFTablesConfs = []string{"/etc/sysconfig/nftables.conf", "/etc/nftables.nft", NFTablesConf}
+
+// nftInclude 主配置中加载本工具规则的语句
+var nftInclude = fmt.Sprintf("include %q", NFTRulesPath)
+
+// nftables 使用本工具专用的表，入站链默认丢弃，见 internal/nft
+type nftables struct {
+	cmd Commands
+}
+
+func (f *nftables) Name() string { return NFTables }
+
+// Apply 写入规则文件并用 nft -f 原子加载，不会清空 Docker、fail2ban 等已有规则
+func (f *nftables) Apply(r Rules) error {
+	data, err := nft.Ruleset(r)
+	if err != nil {
+		return err
+	}
+	if err := f.cmd.WriteFile(NFTRulesPath, data, 0644); err != nil {
+		return err
+	}
+	if err := f.addInclude(); err != nil {
+		return err
+	}
+	if err := f.cmd.Run("nft", "-f", NFTRulesPath); err != nil {
+		return fmt.Errorf("加载 nftables 规则失败: %v", err)
+	}
+	// 只设置开机加载；restart 会重新执行发行版配置中的 flush ruleset
+	return f.cmd.services().Enable("nftables")
+}
+
+// Remove 只删除本工具的表、include 与规则文件
+func (f *nftables) Remove(Rules) error {
+	for _, table := range nft.Tables {
+		f.cmd.RunQuiet("nft", append([]string{"delete", "table"}, strings.Fields(table)...)...)
+	}
+	if err := f.removeInclude(); err != nil {
+		return err
+	}
+	return f.cmd.RemoveFile(NFTRulesPath)
+}
+
+// Verify 检查 NAT 表已加载，并对每个客户端网段做地址伪装
+func (f *nftables) Verify(r Rules) error {
+	out, err := f.cmd.Output("nft", append([]string{"list", "table"}, strings.Fields(nft.NATTable)...)...)
+	if err != nil {
+		return fmt.Errorf("nftables 表 %s 不存在", nft.NATTable)
+	}
+	return verifySubnets(out, r.Subnets, "masquerade")
+}
+
+// conf 返回 nftables 服务开机加载的主配置文件: 优先取 systemd 单元 ExecStart 中 nft -f 的参数，
+// 查询失败时 (OpenRC 等) 使用第一个已存在的候选文件，都不存在时为 /etc/nftables.conf
+func (f *nftables) conf() string {
+	if out, err := f.cmd.Output("systemctl", "show", "-p", "ExecStart", "--value", "nftables"); err == nil {
+		if path := execStartConf(out); path != "" {
+			return path
+		}
+	}
+	for _, path := range NFTablesConfs {
+		if _, err := f.cmd.ReadFile(path); err == nil {
+			return path
+		}
+	}
+	return NFTablesConf
+}
+
+// execStartConf 从 systemctl show 输出的 ExecStart 中取第一个 nft -f 的文件参数，例如
+// { path=/sbin/nft ; argv[]=/sbin/nft -f /etc/sysconfig/nftables.conf ; ... }
+func execStartConf(execStart string) string {
+	fields := strings.Fields(execStart)
+	for i, field := range fields {
+		if field == "-f" && i+1 < len(fields) && strings.HasPrefix(fields[i+1], "/") {
+			return strings.TrimSuffix(fields[i+1], ";")
+		}
+	}
+	return ""
+}
+
+// addInclude 在 nftables 主配置末尾追加 include，已存在时不重复添加
+func (f *nftables) addInclude() error {
+	conf := f.conf()
+	content, err := f.cmd.ReadFile(conf)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(content) == 0 {
+		content = []byte("#!/usr/sbin/nft -f\n")
+	}
+	if slices.ContainsFunc(strings.Split(string(content), "\n"), isNFTInclude) {
+		return nil
+	}
+	if !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+	content = append(content, nftInclude+"\n"...)
+	// Debian 的主配置为可执行脚本，RHEL 系的默认权限为 0600；文件已存在时保持原权限
+	mode := os.FileMode(0600)
+	if conf == NFTablesConf {
+		mode = 0755
+	}
+	return f.cmd.WriteFile(conf, content, mode)
+}
+
+// removeInclude 从所有候选主配置中删除 addInclude 追加的 include，文件其余内容保持不变
+func (f *nftables) removeInclude() error {
+	confs := NFTablesConfs
+	if conf := f.conf(); !slices.Contains(confs, conf) {
+		confs = append(slices.Clone(confs), conf)
+	}
+	for _, conf := range confs {
+		content, err := f.cmd.ReadFile(conf)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(string(content), "\n")
+		kept := slices.DeleteFunc(slices.Clone(lines), isNFTInclude)
+		if len(kept) == len(lines) {
+			continue
+		}
+		if err := f.cmd.WriteFile(conf, []byte(strings.Join(kept, "\n")), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNFTInclude(line string) bool {
+	return strings.TrimSpace(line) == nftInclude
+}
@@ -0,0 +1,103 @@
+package firewall
+
+import (
+	"os"
+	"strings"
+)
+
+// ufw 的规则文件，NAT 与透明代理规则写入其中由本工具维护的区块，ufw 启动时加载
+const (
+	UFWBeforeRules  = "/etc/ufw/before.rules"
+	UFWBefore6Rules = "/etc/ufw/before6.rules"
+)
+
+const (
+	ufwBlockBegin = "# BEGIN l2tp-vpn (由 l2tp 维护，请勿手动修改)"
+	ufwBlockEnd   = "# END l2tp-vpn"
+)
+
+// ufw Ubuntu 上常用，入站与转发通过 ufw allow / ufw route allow 放行，
+// ufw 没有 NAT 命令，地址伪装与 TPROXY 写入 before.rules
+type ufw struct {
+	cmd Commands
+}
+
+func (f *ufw) Name() string { return UFW }
+
+func (f *ufw) Apply(r Rules) error {
+	for _, args := range ufwRules(r) {
+		if err := f.cmd.Run("ufw", args...); err != nil {
+			return err
+		}
+	}
+	rules := chainRules(r)
+	for path, bin := range map[string]string{UFWBeforeRules: "iptables", UFWBefore6Rules: "ip6tables"} {
+		if err := f.writeBlock(path, restoreBlock(bin, rules)); err != nil {
+			return err
+		}
+	}
+	return f.cmd.Run("ufw", "reload")
+}
+
+func (f *ufw) Remove(r Rules) error {
+	for _, args := range ufwRules(r) {
+		f.cmd.RunQuiet("ufw", append([]string{"delete"}, args...)...)
+	}
+	for _, path := range []string{UFWBeforeRules, UFWBefore6Rules} {
+		if err := f.writeBlock(path, ""); err != nil {
+			return err
+		}
+	}
+	return f.cmd.Run("ufw", "reload")
+}
+
+// Verify ufw 启动时从 before.rules 加载与 iptables 后端相同的 NAT 链
+func (f *ufw) Verify(r Rules) error { return verifyNATChain(f.cmd, r) }
+
+// ufwRules 生成 ufw 命令参数，删除时在前面加 delete 即可
+func ufwRules(r Rules) [][]string {
+	var rules [][]string
+	for _, p := range r.UDPPorts {
+		rules = append(rules, []string{"allow", p + "/udp"})
+	}
+	for _, p := range r.TCPPorts {
+		rules = append(rules, []string{"allow", p + "/tcp"})
+	}
+	for _, p := range r.Allow {
+		rules = append(rules, []string{"allow", portArg(p.Ports) + "/" + p.Proto})
+	}
+	if r.GRE {
+		rules = append(rules, []string{"allow", "proto", "gre", "from", "any", "to", "any"})
+	}
+	// 透明代理的流量以原始目的端口进入 INPUT，需要放行客户端网段的全部入站
+	for _, s := range append(r.Subnets, r.Subnets6...) {
+		rules = append(rules, []string{"allow", "from", s}, []string{"route", "allow", "from", s})
+	}
+	return rules
+}
+
+// writeBlock 用 block 替换文件开头由本工具维护的区块，block 为空时删除区块
+func (f *ufw) writeBlock(path, block string) error {
+	content, err := f.cmd.ReadFile(path)
+	if os.IsNotExist(err) && block == "" {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	rest := string(content)
+	if begin := strings.Index(rest, ufwBlockBegin); begin >= 0 {
+		if end := strings.Index(rest[begin:], ufwBlockEnd); end >= 0 {
+			rest = rest[:begin] + strings.TrimPrefix(rest[begin+end+len(ufwBlockEnd):], "\n")
+		}
+	}
+	updated := rest
+	if block != "" {
+		// 放在 *filter 之前，避免与 ufw 自身的规则交错
+		updated = ufwBlockBegin + "\n" + block + ufwBlockEnd + "\n" + rest
+	}
+	if updated == string(content) {
+		return nil
+	}
+	return f.cmd.WriteFile(path, []byte(updated), 0640)
+}
@@ -0,0 +1,170 @@
+// Package grub 合并 /etc/default/grub 中的键值，并从 grub-mkconfig 生成的 grub.cfg 中查找内核对应的启动项
+package grub
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// assignment 匹配 KEY=value，允许前置 export
+var assignment = regexp.MustCompile(`^\s*(?:export\s+)?([A-Z_][A-Z0-9_]*)=`)
+
+// Merge 将 settings 合并到 /etc/default/grub：已有的键原地替换 (重复出现的每一处都替换，shell 以最后一次为准)，
+// 缺少的键按名称排序追加到末尾，其他行包括 GRUB_CMDLINE_LINUX 中的串口等参数保持不变
+func Merge(data []byte, settings map[string]string) []byte {
+	text := strings.TrimSuffix(string(data), "\n")
+	var lines []string
+	if text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	seen := make(map[string]bool)
+	for i, line := range lines {
+		m := assignment.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		if value, ok := settings[m[1]]; ok {
+			lines[i] = m[1] + "=" + quote(value)
+			seen[m[1]] = true
+		}
+	}
+
+	var missing []string
+	for key := range settings {
+		if !seen[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		lines = append(lines, "", "# 由 l2tp 切换内核时添加")
+		for _, key := range missing {
+			lines = append(lines, key+"="+quote(settings[key]))
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// Value 返回键最后一次赋值的值，去掉引号，未设置时 ok 为 false
+func Value(data []byte, key string) (value string, ok bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		m := assignment.FindStringSubmatch(line)
+		if m == nil || m[1] != key {
+			continue
+		}
+		rest := strings.TrimSpace(line[len(m[0]):])
+		if len(rest) >= 2 && (rest[0] == '"' || rest[0] == '\'') && rest[len(rest)-1] == rest[0] {
+			rest = rest[1 : len(rest)-1]
+		}
+		value, ok = rest, true
+	}
+	return value, ok
+}
+
+// quote 值只含安全字符时原样写入，否则加双引号
+func quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'$`\\;&|<>()*?") {
+		return value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + r.Replace(value) + `"`
+}
+
+// Entry grub.cfg 中的一个启动项
+type Entry struct {
+	// Title 菜单标题，ID 为 $menuentry_id_option 指定的标识
+	Title string
+	ID    string
+	// Path grub-reboot 与 grub-set-default 使用的完整路径，子菜单中的项形如 "子菜单>启动项"
+	Path string
+	// Kernel linux 命令加载的内核文件，例如 /boot/vmlinuz-6.1.0-18-amd64
+	Kernel string
+}
+
+var (
+	menuLine = regexp.MustCompile(`^\s*(menuentry|submenu)\s+(?:'([^']*)'|"([^"]*)")(.*)\{\s*$`)
+	idOption = regexp.MustCompile(`\$menuentry_id_option\s+'([^']*)'`)
+)
+
+// Entries 按出现顺序解析 grub.cfg 中的启动项，不包括子菜单本身
+func Entries(cfg []byte) []Entry {
+	type block struct {
+		menu  bool
+		entry int // 当前启动项在 entries 中的下标，子菜单与其他块为 -1
+		name  string
+	}
+	var stack []block
+	var entries []Entry
+	for _, line := range strings.Split(string(cfg), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if m := menuLine.FindStringSubmatch(line); m != nil {
+			title := m[2] + m[3]
+			name := title
+			if id := idOption.FindStringSubmatch(m[4]); id != nil {
+				name = id[1]
+			}
+			b := block{menu: true, entry: -1, name: name}
+			if m[1] == "menuentry" {
+				var path []string
+				for _, parent := range stack {
+					if parent.menu {
+						path = append(path, parent.name)
+					}
+				}
+				e := Entry{Title: title, Path: strings.Join(append(path, name), ">")}
+				if name != title {
+					e.ID = name
+				}
+				b.entry = len(entries)
+				entries = append(entries, e)
+			}
+			stack = append(stack, b)
+			continue
+		}
+		switch {
+		case trimmed == "}":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case strings.HasSuffix(trimmed, "{"):
+			stack = append(stack, block{entry: -1})
+		case strings.HasPrefix(trimmed, "linux"):
+			// linux 或 linuxefi 命令，第一个参数为内核文件
+			fields := strings.Fields(trimmed)
+			if len(fields) > 1 && len(stack) > 0 {
+				if i := stack[len(stack)-1].entry; i >= 0 && entries[i].Kernel == "" {
+					entries[i].Kernel = fields[1]
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// FindKernel 查找加载指定版本内核的启动项，跳过恢复模式；优先标题中带版本号的高级选项，
+// 顶层的默认项会随已安装内核变化，只在没有高级选项时使用
+func FindKernel(entries []Entry, version string) (Entry, error) {
+	var fallback *Entry
+	for i, e := range entries {
+		if !strings.HasSuffix(e.Kernel, "/vmlinuz-"+version) || strings.Contains(strings.ToLower(e.Title), "recovery") {
+			continue
+		}
+		if strings.Contains(e.Title, version) {
+			return e, nil
+		}
+		if fallback == nil {
+			fallback = &entries[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, nil
+	}
+	return Entry{}, fmt.Errorf("grub.cfg 中没有内核 %s 的启动项", version)
+}
+
+// SavedDefault grub.cfg 是否按 grubenv 中的 saved_entry 选择默认项，grub-reboot 与 grub-set-default 依赖于此
+func SavedDefault(cfg []byte) bool {
+	return strings.Contains(string(cfg), `set default="${saved_entry}"`)
+}
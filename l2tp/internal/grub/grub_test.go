@@ -0,0 +1,90 @@
+package grub
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用当前生成结果覆盖 testdata 下的 golden 文件")
+
+func TestMerge(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "default.grub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Merge(data, map[string]string{"GRUB_DEFAULT": "saved", "GRUB_DISABLE_OS_PROBER": "true"})
+
+	golden := filepath.Join("testdata", "default.grub.golden")
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("读取 %s 失败: %v (使用 -update 生成)", golden, err)
+	}
+	if string(want) != string(got) {
+		t.Errorf("与 %s 不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", golden, want, got)
+	}
+
+	// 串口参数与 shell 表达式保持原样，再次合并结果不变
+	if v, _ := Value(got, "GRUB_CMDLINE_LINUX"); v != "console=tty0 console=ttyS0,115200 earlyprintk=ttyS0,115200 consoleblank=0" {
+		t.Errorf("GRUB_CMDLINE_LINUX = %q", v)
+	}
+	if again := Merge(got, map[string]string{"GRUB_DEFAULT": "saved", "GRUB_DISABLE_OS_PROBER": "true"}); string(again) != string(got) {
+		t.Errorf("重复合并改变了内容:\n%s", again)
+	}
+}
+
+func TestValue(t *testing.T) {
+	data := []byte("GRUB_DEFAULT=0\nexport GRUB_DEFAULT='saved'\n#GRUB_TIMEOUT=5\n")
+	if v, ok := Value(data, "GRUB_DEFAULT"); !ok || v != "saved" {
+		t.Errorf("GRUB_DEFAULT = %q, %v", v, ok)
+	}
+	if _, ok := Value(data, "GRUB_TIMEOUT"); ok {
+		t.Error("注释中的键不应生效")
+	}
+}
+
+func TestEntries(t *testing.T) {
+	cfg, err := os.ReadFile(filepath.Join("testdata", "grub.cfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := Entries(cfg)
+	if len(entries) != 5 {
+		t.Fatalf("启动项 = %+v", entries)
+	}
+	if entries[0].Path != "gnulinux-simple-6f2d" || entries[0].Kernel != "/boot/vmlinuz-6.1.0-18-cloud-amd64" {
+		t.Errorf("默认项 = %+v", entries[0])
+	}
+
+	e, err := FindKernel(entries, "6.1.0-18-amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Path != "gnulinux-advanced-6f2d>gnulinux-6.1.0-18-amd64-advanced-6f2d" || e.Title != "Debian GNU/Linux, with Linux 6.1.0-18-amd64" {
+		t.Errorf("标准内核启动项 = %+v", e)
+	}
+	if _, err := FindKernel(entries, "6.1.0-17-amd64"); err == nil {
+		t.Error("不存在的内核应返回错误")
+	}
+	if !SavedDefault(cfg) {
+		t.Error("应识别 saved_entry")
+	}
+}
+
+func TestFindKernelWithoutIDs(t *testing.T) {
+	cfg := []byte(`menuentry "Ubuntu" {
+	linux /vmlinuz-5.15.0-91-generic root=/dev/vda1
+}
+`)
+	e, err := FindKernel(Entries(cfg), "5.15.0-91-generic")
+	if err != nil || e.Path != "Ubuntu" || e.ID != "" {
+		t.Errorf("启动项 = %+v, %v", e, err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package nft 生成本工具专用的 nftables 表，只管理自己命名的表，不会清空 Docker、fail2ban 等其他规则
+package nft
+
+import (
+	_ "embed"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+//go:embed ruleset.nft.tmpl
+var rulesetTemplate string
+
+var ruleset = template.Must(template.New("ruleset").Funcs(template.FuncMap{
+	"join": strings.Join,
+}).Parse(rulesetTemplate))
+
+// 本工具管理的表，卸载时只删除这些表
+const (
+	FilterTable = "inet l2tp_vpn"
+	NATTable    = "ip l2tp_nat"
+	NAT6Table   = "ip6 l2tp_nat6"
+	TProxyTable = "ip l2tp_tproxy"
+)
+
+// 透明代理表中的集合: 目的地址属于 ProxySet 时经过代理，否则属于 BypassSet 时直连
+const (
+	BypassSet = "bypass"
+	ProxySet  = "proxy"
+)
+
+// Tables 全部表，生成的规则总会先删除它们，已不需要的表 (例如关闭 IPv6 后的 NAT66) 也会被清理。
+// 元素为 nft 命令中的 "<family> <name>" 形式
+var Tables = []string{FilterTable, NATTable, NAT6Table, TProxyTable}
+
+// Rules 生成规则所需的参数
+type Rules struct {
+	// Interface 与 Interface6 为 IPv4、IPv6 出口网卡
+	Interface  string
+	Interface6 string
+	// SSHPorts 入站默认丢弃，必须放行 SSH 以免断开管理连接
+	SSHPorts []string
+	// UDPPorts、TCPPorts 为 VPN 服务端口，GRE 供 PPTP 使用
+	UDPPorts []string
+	TCPPorts []string
+	GRE      bool
+	// Allow 额外放行的端口，见 ParsePort
+	Allow []Port
+	// Subnets 与 Subnets6 为客户端网段，出站时做地址转换
+	Subnets  []string
+	Subnets6 []string
+	// NAT6 客户端 IPv6 使用 ULA 前缀时需要 NAT66
+	NAT6 bool
+	// TProxy 非空时将客户端流量转发到本机透明代理
+	TProxy *TProxy
+	// KeepMasquerade 安装前 firewalld 默认区域已开启地址伪装，卸载时保留。
+	// nftables 等后端的地址伪装位于本工具专用的表或链中，不使用此项
+	KeepMasquerade bool
+}
+
+// TProxy 透明代理参数，来自 Subnet 的 TCP/UDP 流量打上 Mark 后交给本机 Port，
+// 策略路由 (fwmark 对应的路由表) 由调用方配置
+type TProxy struct {
+	Port   string
+	Subnet string
+	Mark   string
+	// Bypass 不经过代理的目的网段，可以包含 GeoIP 编译出的上万条网段
+	Bypass []string
+	// Proxy 总是经过代理的目的网段，优先于 Bypass
+	Proxy []string
+	// DNSPort 非空时客户端的 DNS 请求重定向到本机该端口，由 dnsmasq 按域名向集合添加地址
+	DNSPort string
+}
+
+// Port 一条端口放行规则
+type Port struct {
+	Proto string
+	// Ports 单个端口或 1000-2000 形式的范围
+	Ports string
+}
+
+// ParsePort 解析 80/tcp、60000-61000/udp 形式的端口
+func ParsePort(s string) (Port, error) {
+	ports, proto, ok := strings.Cut(s, "/")
+	if !ok || (proto != "tcp" && proto != "udp") {
+		return Port{}, fmt.Errorf("端口 %q 应为 <端口>/tcp 或 <端口>/udp", s)
+	}
+	lo, hi, isRange := strings.Cut(ports, "-")
+	if !validPort(lo) || (isRange && (!validPort(hi) || atoi(lo) >= atoi(hi))) {
+		return Port{}, fmt.Errorf("端口 %q 无效", s)
+	}
+	return Port{Proto: proto, Ports: ports}, nil
+}
+
+// Ruleset 生成可直接用 nft -f 加载的规则文件
+func Ruleset(r Rules) ([]byte, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	data := struct {
+		Rules
+		Tables                              []string
+		Filter, NAT, NAT6Table, TProxyTable string
+		BypassSet, ProxySet                 string
+	}{r, Tables, FilterTable, NATTable, NAT6Table, TProxyTable, BypassSet, ProxySet}
+
+	var sb strings.Builder
+	if err := ruleset.Execute(&sb, data); err != nil {
+		return nil, fmt.Errorf("生成 nftables 规则失败: %v", err)
+	}
+	return []byte(sb.String()), nil
+}
+
+func (r Rules) validate() error {
+	if r.Interface == "" {
+		return fmt.Errorf("缺少参数: Interface")
+	}
+	if len(r.Subnets) == 0 {
+		return fmt.Errorf("缺少参数: Subnets")
+	}
+	if r.NAT6 && (r.Interface6 == "" || len(r.Subnets6) == 0) {
+		return fmt.Errorf("NAT66 需要 Interface6 与 Subnets6")
+	}
+	if t := r.TProxy; t != nil {
+		if !validPort(t.Port) || t.Subnet == "" || t.Mark == "" {
+			return fmt.Errorf("透明代理参数不完整: %+v", *t)
+		}
+		if t.DNSPort != "" && !validPort(t.DNSPort) {
+			return fmt.Errorf("DNS 端口 %q 无效", t.DNSPort)
+		}
+		for _, s := range append(append([]string{t.Subnet}, t.Bypass...), t.Proxy...) {
+			if p, err := netip.ParsePrefix(s); err != nil || !p.Addr().Is4() {
+				return fmt.Errorf("透明代理网段 %q 无效", s)
+			}
+		}
+	}
+	for _, list := range [][]string{r.SSHPorts, r.UDPPorts, r.TCPPorts} {
+		for _, p := range list {
+			if !validPort(p) {
+				return fmt.Errorf("端口 %q 无效", p)
+			}
+		}
+	}
+	for _, s := range append(r.Subnets, r.Subnets6...) {
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return fmt.Errorf("网段 %q 无效", s)
+		}
+	}
+	return nil
+}
+
+func validPort(s string) bool {
+	n := atoi(s)
+	return n > 0 && n <= 65535
+}
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return -1
+	}
+	return n
+}
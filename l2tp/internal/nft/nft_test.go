@@ -0,0 +1,139 @@
+package nft_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"l2tp/internal/nft"
+	"l2tp/internal/nft/nfttest"
+)
+
+var update = flag.Bool("update", false, "用当前生成结果覆盖 testdata 下的 golden 文件")
+
+// goldenCases 每个场景对应 testdata 下的一个文件
+func goldenCases() map[string]nft.Rules {
+	// 默认场景不额外放行端口，也不开启透明代理
+	defaults := nfttest.Rules()
+	defaults.Allow, defaults.TProxy = nil, nil
+
+	l2tpOnly := defaults
+	l2tpOnly.TCPPorts = nil
+	l2tpOnly.GRE = false
+	l2tpOnly.Subnets = []string{"10.10.10.0/24", "10.10.20.0/24"}
+	l2tpOnly.Allow = []nft.Port{{Proto: "tcp", Ports: "443"}, {Proto: "udp", Ports: "60000-61000"}}
+
+	dualStack := defaults
+	dualStack.Interface6 = "ens3"
+	dualStack.Subnets6 = []string{"fd12:3456:789a::/48"}
+	dualStack.NAT6 = true
+
+	tproxy := defaults
+	tproxy.TProxy = &nft.TProxy{Port: "12345", Subnet: "10.10.10.0/24", Mark: "1", Bypass: []string{"10.0.0.0/8", "192.168.0.0/16"}}
+
+	split := defaults
+	split.TProxy = &nft.TProxy{
+		Port: "12345", Subnet: "10.10.10.0/24", Mark: "1",
+		Bypass:  []string{"10.0.0.0/8", "1.0.1.0/24", "1.0.2.0/23"},
+		Proxy:   []string{"8.8.8.8/32"},
+		DNSPort: "5335",
+	}
+
+	return map[string]nft.Rules{
+		"default":    defaults,
+		"l2tp-only":  l2tpOnly,
+		"dual-stack": dualStack,
+		"tproxy":     tproxy,
+		"split":      split,
+	}
+}
+
+func TestRulesetGolden(t *testing.T) {
+	for name, rules := range goldenCases() {
+		t.Run(name, func(t *testing.T) {
+			got, err := nft.Ruleset(rules)
+			if err != nil {
+				t.Fatalf("生成失败: %v", err)
+			}
+			golden := filepath.Join("testdata", name+".nft.golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("读取 %s 失败: %v (使用 -update 生成)", golden, err)
+			}
+			if string(want) != string(got) {
+				t.Errorf("与 %s 不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", golden, want, got)
+			}
+		})
+	}
+}
+
+func TestRulesetOnlyOwnTables(t *testing.T) {
+	got, err := nft.Ruleset(nfttest.Rules())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(got), "flush ruleset") {
+		t.Error("不应清空整个规则集")
+	}
+	for _, line := range strings.Split(string(got), "\n") {
+		if !strings.HasPrefix(line, "table ") && !strings.HasPrefix(line, "delete table ") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(line, "delete "), "table "), " {")
+		known := false
+		for _, table := range nft.Tables {
+			known = known || name == table
+		}
+		if !known {
+			t.Errorf("操作了不属于本工具的表: %s", line)
+		}
+	}
+}
+
+func TestRulesetInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *nft.Rules)
+	}{
+		{"缺少客户端网段", func(r *nft.Rules) { r.Subnets = nil }},
+		{"NAT66 缺少 IPv6 网段", func(r *nft.Rules) { r.NAT6 = true }},
+		{"透明代理缺少网段", func(r *nft.Rules) { r.TProxy = &nft.TProxy{Port: "12345", Mark: "1"} }},
+		{"直连网段无效", func(r *nft.Rules) {
+			r.TProxy = &nft.TProxy{Port: "12345", Subnet: "10.10.10.0/24", Mark: "1", Bypass: []string{"10.0.0.0/8; flush ruleset"}}
+		}},
+		{"端口超出范围", func(r *nft.Rules) { r.UDPPorts = []string{"70000"} }},
+	}
+	for _, tt := range tests {
+		r := nfttest.Rules()
+		tt.modify(&r)
+		if _, err := nft.Ruleset(r); err == nil {
+			t.Errorf("%s时应返回错误", tt.name)
+		}
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	valid := map[string]nft.Port{
+		"80/tcp":          {Proto: "tcp", Ports: "80"},
+		"60000-61000/udp": {Proto: "udp", Ports: "60000-61000"},
+	}
+	for s, want := range valid {
+		got, err := nft.ParsePort(s)
+		if err != nil || got != want {
+			t.Errorf("ParsePort(%q) = %v, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"80", "80/icmp", "0/tcp", "2000-1000/tcp", "a-b/udp", "80/tcp; flush ruleset"} {
+		if _, err := nft.ParsePort(s); err == nil {
+			t.Errorf("ParsePort(%q) 应返回错误", s)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// Package nfttest 提供 nft 与 firewall 测试共用的规则
+package nfttest
+
+import "l2tp/internal/nft"
+
+// Rules 同时启用 L2TP/IPSec 与 PPTP 的规则，额外放行一个端口段并开启透明代理，
+// 覆盖各防火墙后端需要转换的全部字段
+func Rules() nft.Rules {
+	return nft.Rules{
+		Interface: "eth0",
+		SSHPorts:  []string{"22"},
+		UDPPorts:  []string{"500", "4500", "1701"},
+		TCPPorts:  []string{"1723"},
+		GRE:       true,
+		Allow:     []nft.Port{{Proto: "tcp", Ports: "8000-8100"}},
+		Subnets:   []string{"10.10.10.0/24", "192.168.30.0/24"},
+		TProxy:    &nft.TProxy{Port: "12345", Subnet: "10.10.10.0/24", Mark: "1", Bypass: []string{"10.0.0.0/8"}},
+	}
+}
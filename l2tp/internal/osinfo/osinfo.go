@@ -0,0 +1,285 @@
+// Package osinfo 解析 os-release，按 ID 与 ID_LIKE 在支持的发行版中查找匹配项，检查最低版本，
+// 并给出各发行版的包管理器、软件包名与安装前需要启用的软件源
+package osinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Release os-release 中用到的字段
+type Release struct {
+	ID string
+	// IDLike 按相近程度排列的上游发行版，例如 Linux Mint 为 [ubuntu debian]
+	IDLike     []string
+	VersionID  string
+	PrettyName string
+	// PlatformID RHEL 系的平台标识，例如 platform:el9，衍生版的版本号与 RHEL 不一致时以此为准
+	PlatformID string
+}
+
+// Parse 解析 os-release，ID 统一转为小写 (openEuler 等发行版不符合规范)
+func Parse(data []byte) Release {
+	var r Release
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = unquote(value)
+		switch key {
+		case "ID":
+			r.ID = strings.ToLower(value)
+		case "ID_LIKE":
+			r.IDLike = strings.Fields(strings.ToLower(value))
+		case "VERSION_ID":
+			r.VersionID = value
+		case "PRETTY_NAME":
+			r.PrettyName = value
+		case "PLATFORM_ID":
+			r.PlatformID = value
+		}
+	}
+	return r
+}
+
+// unquote 去掉 shell 风格的引号，双引号内处理反斜杠转义
+func unquote(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch q := value[0]; {
+	case q == '\'' && value[len(value)-1] == q:
+		return value[1 : len(value)-1]
+	case q == '"' && value[len(value)-1] == q:
+		var b strings.Builder
+		inner := value[1 : len(value)-1]
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' && i+1 < len(inner) {
+				i++
+			}
+			b.WriteByte(inner[i])
+		}
+		return b.String()
+	}
+	return value
+}
+
+// Read 读取 root 下的 /etc/os-release，不存在时读取 /usr/lib/os-release
+func Read(root string) (Release, error) {
+	data, err := os.ReadFile(filepath.Join(root, "etc/os-release"))
+	if err != nil {
+		data, err = os.ReadFile(filepath.Join(root, "usr/lib/os-release"))
+	}
+	if err != nil {
+		return Release{}, fmt.Errorf("读取 os-release 失败: %v", err)
+	}
+	return Parse(data), nil
+}
+
+// Name 用于提示的系统名称
+func (r Release) Name() string {
+	if r.PrettyName != "" {
+		return r.PrettyName
+	}
+	return strings.TrimSpace(r.ID + " " + r.VersionID)
+}
+
+// Distro 一个支持的发行版
+type Distro struct {
+	// Name 发行版名称，用于提示
+	Name string
+	// IDs 与 os-release 的 ID 或 ID_LIKE 比较的标识
+	IDs []string
+	// MinVersion 支持的最低 VERSION_ID，为空时不检查。只对 ID 直接匹配的系统检查，衍生版使用自己的版本号
+	MinVersion string
+	// Manager 包管理器: apt、dnf 或 apk
+	Manager string
+	// Packages 通用软件包名到发行版包名的映射，映射为空表示该发行版不需要单独安装，未列出的使用通用名
+	Packages map[string]string
+	// Repos 安装软件包之前需要安装的软件源包，{major} 替换为主版本号
+	Repos []string
+}
+
+// aptPackages Debian 与 Ubuntu 的软件包名: 新版本的 strongswan 元包不再依赖提供 ipsec 命令与 ipsec.conf 的
+// strongswan-starter；eap-mschapv2 等插件单独打包；只需要 dnsmasq 程序，不安装其默认的 DNS 服务
+var aptPackages = map[string]string{
+	"strongswan":     "strongswan-starter",
+	"strongswan-eap": "libcharon-extauth-plugins",
+	"dnsmasq":        "dnsmasq-base",
+}
+
+// rpmPackages RHEL 系与 Fedora 的 strongswan 包已包含 eap 插件
+var rpmPackages = map[string]string{
+	"strongswan-eap": "",
+}
+
+// Distros 支持的发行版，按顺序匹配 ID，再按 ID_LIKE 的顺序匹配衍生版
+var Distros = []Distro{
+	{
+		Name: "Ubuntu", IDs: []string{"ubuntu"}, MinVersion: "20.04", Manager: "apt",
+		Packages: merge(aptPackages, map[string]string{"linux-image": "linux-image-generic", "linux-headers": "linux-headers-generic"}),
+	},
+	{
+		Name: "Debian", IDs: []string{"debian"}, MinVersion: "10", Manager: "apt",
+		Packages: merge(aptPackages, map[string]string{"linux-image": "linux-image-amd64", "linux-headers": "linux-headers-amd64"}),
+	},
+	{
+		// RHEL 本身的源中没有 epel-release
+		Name: "RHEL", IDs: []string{"rhel"}, MinVersion: "8", Manager: "dnf", Packages: rpmPackages,
+		Repos: []string{"https://dl.fedoraproject.org/pub/epel/epel-release-latest-{major}.noarch.rpm"},
+	},
+	{
+		Name: "Oracle Linux", IDs: []string{"ol"}, MinVersion: "8", Manager: "dnf", Packages: rpmPackages,
+		Repos: []string{"oracle-epel-release-el{major}"},
+	},
+	{
+		// xl2tpd、pptpd 与 strongswan 位于 EPEL
+		Name: "CentOS/AlmaLinux/Rocky", IDs: []string{"centos", "almalinux", "rocky"}, MinVersion: "8", Manager: "dnf", Packages: rpmPackages,
+		Repos: []string{"epel-release"},
+	},
+	{
+		Name: "Fedora", IDs: []string{"fedora"}, MinVersion: "38", Manager: "dnf", Packages: rpmPackages,
+	},
+	{
+		// openEuler 的 os-release 没有 ID_LIKE，软件包位于自身的 everything 源
+		Name: "openEuler", IDs: []string{"openeuler"}, MinVersion: "20.03", Manager: "dnf", Packages: rpmPackages,
+	},
+	{
+		Name: "Alpine", IDs: []string{"alpine"}, MinVersion: "3.16", Manager: "apk",
+		Packages: map[string]string{"strongswan-eap": ""},
+	},
+}
+
+// merge 返回 base 与 extra 合并后的新映射
+func merge(base, extra map[string]string) map[string]string {
+	m := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		m[k] = v
+	}
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+// System 识别出的系统
+type System struct {
+	Release
+	Distro Distro
+	// Like 通过 ID_LIKE 中的该项匹配，直接匹配时为空
+	Like string
+}
+
+// Detect 先按 ID 查找发行版并检查最低版本，找不到时按 ID_LIKE 的顺序查找，衍生版不检查版本
+func Detect(r Release) (*System, error) {
+	if r.ID == "" {
+		return nil, fmt.Errorf("os-release 中没有 ID，无法识别操作系统")
+	}
+	if d, ok := lookup(r.ID); ok {
+		if d.MinVersion != "" && r.VersionID != "" && compareVersion(r.VersionID, d.MinVersion) < 0 {
+			return nil, fmt.Errorf("%s 版本过低，最低支持 %s %s", r.Name(), d.Name, d.MinVersion)
+		}
+		return &System{Release: r, Distro: d}, nil
+	}
+	for _, like := range r.IDLike {
+		if d, ok := lookup(like); ok {
+			return &System{Release: r, Distro: d, Like: like}, nil
+		}
+	}
+	return nil, fmt.Errorf("不支持的操作系统: %s (ID=%s, ID_LIKE=%s)", r.Name(), r.ID, strings.Join(r.IDLike, " "))
+}
+
+func lookup(id string) (Distro, bool) {
+	for _, d := range Distros {
+		for _, v := range d.IDs {
+			if v == id {
+				return d, true
+			}
+		}
+	}
+	return Distro{}, false
+}
+
+// Manager 包管理器
+func (s *System) Manager() string {
+	return s.Distro.Manager
+}
+
+// Package 返回通用软件包名在该发行版中的包名，不需要单独安装时返回空
+func (s *System) Package(name string) string {
+	if pkg, ok := s.Distro.Packages[name]; ok {
+		return pkg
+	}
+	return name
+}
+
+// Packages 将通用软件包名转换为发行版包名，去掉不需要安装的项
+func (s *System) Packages(names ...string) []string {
+	var packages []string
+	for _, name := range names {
+		if pkg := s.Package(name); pkg != "" {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages
+}
+
+// Repos 安装软件包前需要安装的软件源包
+func (s *System) Repos() []string {
+	var repos []string
+	for _, repo := range s.Distro.Repos {
+		repos = append(repos, strings.ReplaceAll(repo, "{major}", s.Major()))
+	}
+	return repos
+}
+
+// Major 主版本号，RHEL 系优先使用 PLATFORM_ID 中的 el 版本
+func (s *System) Major() string {
+	if el, ok := strings.CutPrefix(s.PlatformID, "platform:el"); ok && el != "" {
+		return el
+	}
+	major, _, _ := strings.Cut(s.VersionID, ".")
+	return major
+}
+
+// Derived 是否为通过 ID_LIKE 识别的衍生发行版
+func (s *System) Derived() bool {
+	return s.Like != ""
+}
+
+// String 用于提示，例如 "Linux Mint 21.3 (按 ubuntu 处理)"
+func (s *System) String() string {
+	if s.Derived() {
+		return fmt.Sprintf("%s (按 %s 处理)", s.Name(), s.Like)
+	}
+	return s.Name()
+}
+
+// compareVersion 按点分隔的数字逐段比较版本号，非数字部分按 0 处理
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
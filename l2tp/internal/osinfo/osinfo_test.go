@@ -0,0 +1,93 @@
+package osinfo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	// strongswan、strongswan-eap、dnsmasq、xl2tpd 的发行版包名: apt 系单独打包，其他发行版的 strongswan 已包含 eap 插件
+	apt := []string{"strongswan-starter", "libcharon-extauth-plugins", "dnsmasq-base", "xl2tpd"}
+	common := []string{"strongswan", "dnsmasq", "xl2tpd"}
+	tests := []struct {
+		file     string
+		distro   string
+		like     string
+		manager  string
+		packages []string
+		repos    []string
+		err      string
+	}{
+		{file: "debian-12", distro: "Debian", manager: "apt", packages: apt},
+		{file: "debian-9", err: "版本过低"},
+		{file: "ubuntu-22.04", distro: "Ubuntu", manager: "apt", packages: apt},
+		{file: "armbian", distro: "Debian", manager: "apt", packages: apt},
+		{file: "linuxmint-21.3", distro: "Ubuntu", like: "ubuntu", manager: "apt", packages: apt},
+		{file: "deepin-23", distro: "Debian", like: "debian", manager: "apt", packages: apt},
+		{file: "kali-2024.1", distro: "Debian", like: "debian", manager: "apt", packages: apt},
+		{file: "rocky-9.3", distro: "CentOS/AlmaLinux/Rocky", manager: "dnf", packages: common, repos: []string{"epel-release"}},
+		{file: "centos-7", err: "版本过低"},
+		{file: "rhel-9.4", distro: "RHEL", manager: "dnf", packages: common, repos: []string{"https://dl.fedoraproject.org/pub/epel/epel-release-latest-9.noarch.rpm"}},
+		{file: "ol-8.9", distro: "Oracle Linux", manager: "dnf", packages: common, repos: []string{"oracle-epel-release-el8"}},
+		{file: "anolis-8.8", distro: "RHEL", like: "rhel", manager: "dnf", packages: common, repos: []string{"https://dl.fedoraproject.org/pub/epel/epel-release-latest-8.noarch.rpm"}},
+		{file: "openeuler-22.03", distro: "openEuler", manager: "dnf", packages: common},
+		{file: "alpine-3.19", distro: "Alpine", manager: "apk", packages: common},
+		{file: "arch", err: "不支持的操作系统: Arch Linux (ID=arch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file+".os-release"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := Detect(Parse(data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v，期望包含 %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Distro.Name != tt.distro || s.Like != tt.like || s.Manager() != tt.manager {
+				t.Errorf("识别为 %s (like %q, %s)，期望 %s (like %q, %s)", s.Distro.Name, s.Like, s.Manager(), tt.distro, tt.like, tt.manager)
+			}
+			if got := s.Packages("strongswan", "strongswan-eap", "dnsmasq", "xl2tpd"); !reflect.DeepEqual(got, tt.packages) {
+				t.Errorf("软件包 = %v，期望 %v", got, tt.packages)
+			}
+			if got := s.Repos(); !reflect.DeepEqual(got, tt.repos) {
+				t.Errorf("软件源 = %v，期望 %v", got, tt.repos)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	r := Parse([]byte("# comment\nID=\"openEuler\"\nID_LIKE='rhel  fedora'\nPRETTY_NAME=\"Test \\\"OS\\\" 1\"\nVERSION_ID=1.2\n"))
+	want := Release{ID: "openeuler", IDLike: []string{"rhel", "fedora"}, VersionID: "1.2", PrettyName: `Test "OS" 1`}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("Parse = %+v，期望 %+v", r, want)
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"22.04", "20.04", 1},
+		{"3.19.1", "3.16", 1},
+		{"3.9", "3.16", -1},
+		{"10", "10.0", 0},
+		{"8.10", "8.9", 1},
+	}
+	for _, tt := range tests {
+		if got := compareVersion(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersion(%q, %q) = %d，期望 %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,497 @@
+// Package pki 为 IKEv2 证书认证提供内置 CA：签发服务端与客户端证书、吊销并导出 PKCS#12，
+// 仅依赖 crypto/x509，不需要 openssl
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// 证书有效期
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	certValidity = 3 * 365 * 24 * time.Hour
+)
+
+// ServerName 服务端证书在索引中的名称
+const ServerName = "server"
+
+// oidIKEIntermediate IKE 中间证书扩展用途，macOS 与 Windows 的 IKEv2 客户端要求服务端证书包含
+var oidIKEIntermediate = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 2, 2}
+
+// Record 索引中的一条签发记录
+type Record struct {
+	Name      string    `json:"name"`
+	Serial    string    `json:"serial"`
+	Server    bool      `json:"server,omitempty"`
+	NotAfter  time.Time `json:"not_after"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+// Revoked 是否已吊销
+func (r Record) Revoked() bool {
+	return !r.RevokedAt.IsZero()
+}
+
+type index struct {
+	CRLNumber int64     `json:"crl_number"`
+	Records   []*Record `json:"records"`
+}
+
+// Authority 保存在 strongSwan 目录 (通常为 /etc/ipsec.d) 下的 CA，
+// 证书与私钥按 strongSwan 的约定分别放在 cacerts、certs、private、crls 子目录
+type Authority struct {
+	Dir string
+	// KeyType 新生成私钥的类型: rsa 或 ecdsa
+	KeyType string
+	// WriteFile 写文件的方式，由调用方注入以支持 dry-run 与快照
+	WriteFile func(path string, data []byte, perm os.FileMode) error
+
+	// 本次运行中生成或读取过的 CA 与索引，dry-run 时文件并未真正写入，后续签发依赖这里的缓存
+	caCert *x509.Certificate
+	caKey  crypto.Signer
+	idx    *index
+}
+
+// CACertPath CA 证书路径
+func (a *Authority) CACertPath() string { return filepath.Join(a.Dir, "cacerts", "l2tp-ca.crt") }
+
+// CAKeyPath CA 私钥路径
+func (a *Authority) CAKeyPath() string { return filepath.Join(a.Dir, "private", "l2tp-ca.key") }
+
+// CRLPath 吊销列表路径，strongSwan 启动时自动加载 crls 目录
+func (a *Authority) CRLPath() string { return filepath.Join(a.Dir, "crls", "l2tp-ca.crl") }
+
+// CertPath 证书路径
+func (a *Authority) CertPath(name string) string {
+	if name == ServerName {
+		return filepath.Join(a.Dir, "certs", "server.crt")
+	}
+	return filepath.Join(a.Dir, "certs", "client-"+name+".crt")
+}
+
+// KeyPath 私钥路径
+func (a *Authority) KeyPath(name string) string {
+	if name == ServerName {
+		return filepath.Join(a.Dir, "private", "server.key")
+	}
+	return filepath.Join(a.Dir, "private", "client-"+name+".key")
+}
+
+func (a *Authority) indexPath() string { return filepath.Join(a.Dir, "l2tp-pki.json") }
+
+// Exists CA 是否已初始化
+func (a *Authority) Exists() bool {
+	if a.caCert != nil {
+		return true
+	}
+	_, err := os.Stat(a.CACertPath())
+	return err == nil
+}
+
+// Init 生成自签名 CA
+func (a *Authority) Init(commonName string) error {
+	key, err := a.generateKey()
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("生成 CA 证书失败: %v", err)
+	}
+
+	if err := a.writeKey(a.CAKeyPath(), key); err != nil {
+		return err
+	}
+	if err := a.WriteFile(a.CACertPath(), pemEncode("CERTIFICATE", der), 0644); err != nil {
+		return err
+	}
+	if a.caCert, err = x509.ParseCertificate(der); err != nil {
+		return err
+	}
+	a.caKey = key
+	// 新 CA 从空索引开始，旧 CA 签发的证书随之失效
+	return a.saveIndex(&index{})
+}
+
+// IssueServer 签发服务端证书，第一个 id 作为 CN。id 为 IP 时写入 IP SAN，否则写入 DNS SAN，
+// 双栈主机可同时传入 IPv4 与 IPv6 地址
+func (a *Authority) IssueServer(ids ...string) (*Record, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("服务端证书至少需要一个标识")
+	}
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: ids[0]},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{
+			oidIKEIntermediate,
+		},
+	}
+	for _, id := range ids {
+		if ip := net.ParseIP(id); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, id)
+		}
+	}
+	return a.issue(ServerName, tmpl, true)
+}
+
+// IssueClient 签发客户端证书，CN 与 SAN 均为用户名，客户端以此作为身份标识
+func (a *Authority) IssueClient(name string) (*Record, error) {
+	if name == "" || name == ServerName || filepath.Base(name) != name {
+		return nil, fmt.Errorf("无效的证书名称 %q", name)
+	}
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		DNSNames:    []string{name},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return a.issue(name, tmpl, false)
+}
+
+func (a *Authority) issue(name string, tmpl *x509.Certificate, server bool) (*Record, error) {
+	caCert, caKey, err := a.loadCA()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := a.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if existing := idx.active(name); existing != nil && !server {
+		return nil, fmt.Errorf("%s 已有有效证书 (序列号 %s)，请先吊销", name, existing.Serial)
+	}
+
+	key, err := a.generateKey()
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = now.Add(-time.Hour)
+	tmpl.NotAfter = now.Add(certValidity)
+	if tmpl.NotAfter.After(caCert.NotAfter) {
+		tmpl.NotAfter = caCert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, fmt.Errorf("签发 %s 证书失败: %v", name, err)
+	}
+	if err := a.writeKey(a.KeyPath(name), key); err != nil {
+		return nil, err
+	}
+	if err := a.WriteFile(a.CertPath(name), pemEncode("CERTIFICATE", der), 0644); err != nil {
+		return nil, err
+	}
+
+	// 重新签发服务端证书时旧记录直接替换，无需吊销
+	if server {
+		idx.remove(name)
+	}
+	record := &Record{Name: name, Serial: serial.Text(16), Server: server, NotAfter: tmpl.NotAfter}
+	idx.Records = append(idx.Records, record)
+	return record, a.saveIndex(idx)
+}
+
+// Revoke 吊销客户端证书并重新生成 CRL
+func (a *Authority) Revoke(name string) error {
+	idx, err := a.loadIndex()
+	if err != nil {
+		return err
+	}
+	record := idx.active(name)
+	if record == nil {
+		return fmt.Errorf("%s 没有有效的证书", name)
+	}
+	if record.Server {
+		return fmt.Errorf("不能吊销服务端证书，请使用 issue -server 重新签发")
+	}
+	record.RevokedAt = time.Now()
+	if err := a.writeCRL(idx); err != nil {
+		return err
+	}
+	return a.saveIndex(idx)
+}
+
+// List 返回全部签发记录
+func (a *Authority) List() ([]*Record, error) {
+	idx, err := a.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	records := append([]*Record(nil), idx.Records...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// Active 返回名称对应的有效证书记录
+func (a *Authority) Active(name string) (*Record, error) {
+	idx, err := a.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	return idx.active(name), nil
+}
+
+// ExportPKCS12 导出客户端证书、私钥与 CA 证书。使用 3DES/SHA-1 以兼容 iOS、macOS、Windows 与 Android 的导入
+func (a *Authority) ExportPKCS12(name, password string) ([]byte, error) {
+	idx, err := a.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	if idx.active(name) == nil {
+		return nil, fmt.Errorf("%s 没有有效的证书", name)
+	}
+	caCert, _, err := a.loadCA()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := readCert(a.CertPath(name))
+	if err != nil {
+		return nil, err
+	}
+	key, err := readKey(a.KeyPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12.LegacyDES.Encode(key, cert, []*x509.Certificate{caCert}, password)
+}
+
+// ServerCertMatches 检查现有服务端证书是否包含全部 id、私钥类型与 KeyType 一致且仍在有效期内
+func (a *Authority) ServerCertMatches(ids ...string) bool {
+	cert, err := readCert(a.CertPath(ServerName))
+	if err != nil || time.Now().After(cert.NotAfter) {
+		return false
+	}
+	want := x509.RSA
+	if a.KeyType == "ecdsa" {
+		want = x509.ECDSA
+	}
+	if cert.PublicKeyAlgorithm != want {
+		return false
+	}
+	for _, id := range ids {
+		if ip := net.ParseIP(id); ip != nil {
+			if !slices.ContainsFunc(cert.IPAddresses, ip.Equal) {
+				return false
+			}
+		} else if cert.VerifyHostname(id) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Authority) writeCRL(idx *index) error {
+	caCert, caKey, err := a.loadCA()
+	if err != nil {
+		return err
+	}
+	var revoked []x509.RevocationListEntry
+	for _, r := range idx.Records {
+		if !r.Revoked() {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(r.Serial, 16)
+		if !ok {
+			return fmt.Errorf("索引中的序列号无效: %s", r.Serial)
+		}
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: r.RevokedAt})
+	}
+
+	idx.CRLNumber++
+	tmpl := &x509.RevocationList{
+		Number:                    big.NewInt(idx.CRLNumber),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                caCert.NotAfter,
+		RevokedCertificateEntries: revoked,
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, caCert, caKey)
+	if err != nil {
+		return fmt.Errorf("生成 CRL 失败: %v", err)
+	}
+	return a.WriteFile(a.CRLPath(), pemEncode("X509 CRL", der), 0644)
+}
+
+func (a *Authority) loadCA() (*x509.Certificate, crypto.Signer, error) {
+	if a.caCert != nil {
+		return a.caCert, a.caKey, nil
+	}
+	if !a.Exists() {
+		return nil, nil, fmt.Errorf("CA 尚未初始化，请先执行 l2tp cert init")
+	}
+	cert, err := readCert(a.CACertPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := readKey(a.CAKeyPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	a.caCert, a.caKey = cert, key
+	return cert, key, nil
+}
+
+func (a *Authority) loadIndex() (*index, error) {
+	if a.idx != nil {
+		return a.idx, nil
+	}
+	data, err := os.ReadFile(a.indexPath())
+	if os.IsNotExist(err) {
+		return &index{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	idx := &index{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", a.indexPath(), err)
+	}
+	a.idx = idx
+	return idx, nil
+}
+
+func (a *Authority) saveIndex(idx *index) error {
+	data, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return err
+	}
+	a.idx = idx
+	return a.WriteFile(a.indexPath(), data, 0600)
+}
+
+func (idx *index) active(name string) *Record {
+	for _, r := range idx.Records {
+		if r.Name == name && !r.Revoked() {
+			return r
+		}
+	}
+	return nil
+}
+
+func (idx *index) remove(name string) {
+	kept := idx.Records[:0]
+	for _, r := range idx.Records {
+		if r.Name != name {
+			kept = append(kept, r)
+		}
+	}
+	idx.Records = kept
+}
+
+func (a *Authority) generateKey() (crypto.Signer, error) {
+	switch a.KeyType {
+	case "", "rsa":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("不支持的私钥类型: %s", a.KeyType)
+	}
+}
+
+// writeKey 按 strongSwan 在 ipsec.secrets 中 RSA/ECDSA 条目习惯的 PKCS#1 与 SEC 1 格式保存私钥
+func (a *Authority) writeKey(path string, key crypto.Signer) error {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return a.WriteFile(path, pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(k)), 0600)
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return err
+		}
+		return a.WriteFile(path, pemEncode("EC PRIVATE KEY", der), 0600)
+	default:
+		return fmt.Errorf("不支持的私钥类型 %T", key)
+	}
+}
+
+func readCert(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s 不是 PEM 证书", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func readKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s 不是 PEM 私钥", path)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s 的私钥类型不受支持", path)
+	}
+	return signer, nil
+}
+
+func pemEncode(blockType string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,201 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func newAuthority(t *testing.T, keyType string) *Authority {
+	t.Helper()
+	return &Authority{
+		Dir:     t.TempDir(),
+		KeyType: keyType,
+		WriteFile: func(path string, data []byte, perm os.FileMode) error {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(path, data, perm)
+		},
+	}
+}
+
+func parseCertFile(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	cert, err := readCert(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIssueServer(t *testing.T) {
+	for _, keyType := range []string{"rsa", "ecdsa"} {
+		t.Run(keyType, func(t *testing.T) {
+			a := newAuthority(t, keyType)
+			if err := a.Init("L2TP VPN CA"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.IssueServer("203.0.113.7"); err != nil {
+				t.Fatal(err)
+			}
+
+			ca := parseCertFile(t, a.CACertPath())
+			cert := parseCertFile(t, a.CertPath(ServerName))
+			if err := cert.CheckSignatureFrom(ca); err != nil {
+				t.Fatalf("服务端证书未由 CA 签发: %v", err)
+			}
+			if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("203.0.113.7")) {
+				t.Errorf("IP SAN = %v", cert.IPAddresses)
+			}
+			if !slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageServerAuth) {
+				t.Errorf("缺少 serverAuth 用途")
+			}
+			if len(cert.UnknownExtKeyUsage) != 1 || !cert.UnknownExtKeyUsage[0].Equal(oidIKEIntermediate) {
+				t.Errorf("缺少 IKE 中间证书用途: %v", cert.UnknownExtKeyUsage)
+			}
+			if !a.ServerCertMatches("203.0.113.7") || a.ServerCertMatches("198.51.100.1") {
+				t.Errorf("ServerCertMatches 结果不正确")
+			}
+			other := *a
+			other.KeyType = map[string]string{"rsa": "ecdsa", "ecdsa": "rsa"}[keyType]
+			if other.ServerCertMatches("203.0.113.7") {
+				t.Errorf("私钥类型不同时不应复用服务端证书")
+			}
+
+			info, err := os.Stat(a.KeyPath(ServerName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("私钥权限 = %v", info.Mode().Perm())
+			}
+			if _, err := readKey(a.KeyPath(ServerName)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestIssueServerDNSName(t *testing.T) {
+	a := newAuthority(t, "ecdsa")
+	if err := a.Init("L2TP VPN CA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.IssueServer("vpn.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCertFile(t, a.CertPath(ServerName))
+	if !slices.Equal(cert.DNSNames, []string{"vpn.example.com"}) || len(cert.IPAddresses) != 0 {
+		t.Errorf("SAN = %v %v", cert.DNSNames, cert.IPAddresses)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	a := newAuthority(t, "ecdsa")
+	if err := a.Init("L2TP VPN CA"); err != nil {
+		t.Fatal(err)
+	}
+	record, err := a.IssueClient("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.IssueClient("alice"); err == nil {
+		t.Fatal("重复签发应当失败")
+	}
+	if err := a.Revoke("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Revoke("alice"); err == nil {
+		t.Fatal("重复吊销应当失败")
+	}
+
+	data, err := os.ReadFile(a.CRLPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(parseCertFile(t, a.CACertPath())); err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Text(16) != record.Serial {
+		t.Errorf("CRL 条目 = %v", crl.RevokedCertificateEntries)
+	}
+
+	// 吊销后可以重新签发
+	if _, err := a.IssueClient("alice"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := a.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("记录数 = %d", len(records))
+	}
+}
+
+func TestExportPKCS12(t *testing.T) {
+	a := newAuthority(t, "rsa")
+	if err := a.Init("L2TP VPN CA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.IssueClient("bob"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := a.ExportPKCS12("bob", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cert, caCerts, err := pkcs12.DecodeChain(data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "bob" || !slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageClientAuth) {
+		t.Errorf("客户端证书 = %v %v", cert.Subject, cert.ExtKeyUsage)
+	}
+	if len(caCerts) != 1 || caCerts[0].Subject.CommonName != "L2TP VPN CA" {
+		t.Errorf("CA 证书链不正确")
+	}
+
+	if _, err := a.ExportPKCS12("nobody", "secret"); err == nil {
+		t.Fatal("导出不存在的证书应当失败")
+	}
+}
+
+func TestIssueWithoutInit(t *testing.T) {
+	a := newAuthority(t, "rsa")
+	if _, err := a.IssueClient("alice"); err == nil {
+		t.Fatal("未初始化 CA 时签发应当失败")
+	}
+	if _, err := a.IssueClient("../etc"); err == nil {
+		t.Fatal("非法名称应当被拒绝")
+	}
+}
+
+func TestIssueServerDualStack(t *testing.T) {
+	a := newAuthority(t, "ecdsa")
+	if err := a.Init("L2TP VPN CA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.IssueServer("203.0.113.7", "2001:db8::7"); err != nil {
+		t.Fatal(err)
+	}
+	cert := parseCertFile(t, a.CertPath(ServerName))
+	if cert.Subject.CommonName != "203.0.113.7" || len(cert.IPAddresses) != 2 {
+		t.Errorf("CN = %s，IP SAN = %v", cert.Subject.CommonName, cert.IPAddresses)
+	}
+	if !a.ServerCertMatches("203.0.113.7", "2001:db8::7") || a.ServerCertMatches("203.0.113.7", "2001:db8::8") {
+		t.Errorf("ServerCertMatches 应检查全部标识")
+	}
+}
@@ -0,0 +1,191 @@
+// Package profile 为指定账号生成各平台可直接导入的客户端配置，不直接写盘
+package profile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"l2tp/internal/render"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"xml":    xmlEscape,
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"ps":     psQuote,
+	"lower":  strings.ToLower,
+}).ParseFS(templateFS, "templates/*.tmpl"))
+
+// 协议
+const (
+	L2TP = "l2tp"
+	PPTP = "pptp"
+)
+
+// 导出格式
+const (
+	MobileConfig   = "mobileconfig"
+	Windows        = "windows"
+	NetworkManager = "nm"
+	Linux          = "linux"
+)
+
+// Formats 全部导出格式
+var Formats = []string{MobileConfig, Windows, NetworkManager, Linux}
+
+// Profile 一个账号的连接参数
+type Profile struct {
+	// Name 连接名称，同时用作生成文件的文件名前缀
+	Name     string
+	Server   string
+	Protocol string
+	User     string
+	Password string
+	// PSK 仅 L2TP/IPSec 使用
+	PSK string
+}
+
+type fileSpec struct {
+	template string
+	// path 文件名，%s 替换为连接名称
+	path string
+	mode os.FileMode
+}
+
+// formatSpecs 各格式支持的协议与生成的文件，含密码的文件权限均为 0600
+var formatSpecs = map[string]map[string][]fileSpec{
+	MobileConfig: {
+		L2TP: {{"mobileconfig.tmpl", "%s.mobileconfig", 0600}},
+	},
+	Windows: {
+		L2TP: {{"windows.ps1.tmpl", "%s.ps1", 0600}},
+		PPTP: {{"windows.ps1.tmpl", "%s.ps1", 0600}},
+	},
+	NetworkManager: {
+		L2TP: {{"nm-l2tp.tmpl", "%s.nmconnection", 0600}},
+		PPTP: {{"nm-pptp.tmpl", "%s.nmconnection", 0600}},
+	},
+	Linux: {
+		L2TP: {
+			{"linux-ipsec.conf.tmpl", "linux/ipsec.conf", 0644},
+			{"linux-ipsec.secrets.tmpl", "linux/ipsec.secrets", 0600},
+			{"linux-xl2tpd.conf.tmpl", "linux/xl2tpd.conf", 0644},
+			{"linux-options.tmpl", "linux/options.l2tpd.client", 0600},
+			{"linux-README.tmpl", "linux/README", 0644},
+		},
+	},
+}
+
+// Supported 检查格式是否支持该协议，例如 iOS/macOS 自 iOS 10 起已不再支持 PPTP
+func Supported(format, protocol string) bool {
+	_, ok := formatSpecs[format][protocol]
+	return ok
+}
+
+// Generate 生成指定格式的配置文件，文件的 Path 为相对导出目录的路径
+func Generate(p Profile, format string) ([]render.File, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	specs, ok := formatSpecs[format]
+	if !ok {
+		return nil, fmt.Errorf("未知的导出格式 %q，可选: %s", format, strings.Join(Formats, ", "))
+	}
+	if !Supported(format, p.Protocol) {
+		return nil, fmt.Errorf("%s 不支持 %s", format, strings.ToUpper(p.Protocol))
+	}
+
+	data := struct {
+		Profile
+		UUID        string
+		PayloadUUID string
+	}{p, uuidFor(p.Name, p.Server, p.User), uuidFor(p.Name, p.Server, p.User, "payload")}
+
+	var files []render.File
+	for _, spec := range specs[p.Protocol] {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, spec.template, data); err != nil {
+			return nil, fmt.Errorf("生成 %s 失败: %v", spec.template, err)
+		}
+		name := spec.path
+		if strings.Contains(name, "%s") {
+			name = fmt.Sprintf(name, p.Name)
+		}
+		content := buf.Bytes()
+		if path.Ext(name) == ".ps1" {
+			// Windows PowerShell 5.1 按系统代码页读取无 BOM 的脚本，中文注释会乱码
+			content = append([]byte("\ufeff"), content...)
+		}
+		files = append(files, render.File{Path: name, Content: content, Mode: spec.mode})
+	}
+	return files, nil
+}
+
+// Summary 连接信息摘要，用于终端显示与二维码
+func Summary(p Profile) string {
+	var sb strings.Builder
+	if p.Protocol == PPTP {
+		sb.WriteString("Type: PPTP\n")
+	} else {
+		sb.WriteString("Type: L2TP/IPSec PSK\n")
+	}
+	fmt.Fprintf(&sb, "Server: %s\n", p.Server)
+	if p.Protocol != PPTP {
+		fmt.Fprintf(&sb, "PSK: %s\n", p.PSK)
+	}
+	fmt.Fprintf(&sb, "User: %s\n", p.User)
+	fmt.Fprintf(&sb, "Password: %s\n", p.Password)
+	return sb.String()
+}
+
+func (p Profile) validate() error {
+	if p.Protocol != L2TP && p.Protocol != PPTP {
+		return fmt.Errorf("协议应为 l2tp 或 pptp，当前为 %q", p.Protocol)
+	}
+	required := map[string]string{"Name": p.Name, "Server": p.Server, "User": p.User, "Password": p.Password}
+	if p.Protocol == L2TP {
+		required["PSK"] = p.PSK
+	}
+	for _, name := range []string{"Name", "Server", "User", "Password", "PSK"} {
+		if value, ok := required[name]; ok && value == "" {
+			return fmt.Errorf("缺少参数: %s", name)
+		}
+	}
+	if strings.ContainsAny(p.Name, "/\\ \t\n") {
+		return fmt.Errorf("连接名称 %q 不能包含空白或路径分隔符", p.Name)
+	}
+	for name, value := range map[string]string{"Server": p.Server, "User": p.User, "Password": p.Password, "PSK": p.PSK} {
+		if strings.ContainsAny(value, "\"\n\r") {
+			return fmt.Errorf("%s 不能包含双引号或换行", name)
+		}
+	}
+	return nil
+}
+
+// uuidFor 根据参数生成固定的 UUID，重新导入时覆盖同一个配置而不是新增一份
+func uuidFor(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "\x00")))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]))
+}
+
+var xmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+func xmlEscape(s string) string {
+	return xmlReplacer.Replace(s)
+}
+
+// psQuote PowerShell 单引号字符串，内部的单引号需要写两次
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,109 @@
+package profile
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用当前生成结果覆盖 testdata 下的 golden 文件")
+
+// profiles 各协议生成客户端配置使用的账号
+var profiles = map[string]Profile{
+	L2TP: {
+		Name: "l2tp-203.0.113.10", Server: "203.0.113.10", Protocol: L2TP,
+		User: "alice", Password: "Xk7pQ2mWz9Lr", PSK: "9fKq2LmX7pWz4TnB8vRc3HdY",
+	},
+	PPTP: {
+		Name: "l2tp-203.0.113.10", Server: "203.0.113.10", Protocol: PPTP,
+		User: "alice", Password: "Xk7pQ2mWz9Lr",
+	},
+}
+
+func TestGenerateGolden(t *testing.T) {
+	for _, protocol := range []string{L2TP, PPTP} {
+		for _, format := range Formats {
+			if !Supported(format, protocol) {
+				continue
+			}
+			t.Run(protocol+"/"+format, func(t *testing.T) {
+				files, err := Generate(profiles[protocol], format)
+				if err != nil {
+					t.Fatalf("生成失败: %v", err)
+				}
+				for _, f := range files {
+					golden := filepath.Join("testdata", protocol, f.Path+".golden")
+					if *update {
+						if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+							t.Fatal(err)
+						}
+						if err := os.WriteFile(golden, f.Content, 0644); err != nil {
+							t.Fatal(err)
+						}
+						continue
+					}
+					want, err := os.ReadFile(golden)
+					if err != nil {
+						t.Fatalf("读取 %s 失败: %v (使用 -update 生成)", golden, err)
+					}
+					if string(want) != string(f.Content) {
+						t.Errorf("%s 与 %s 不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", f.Path, golden, want, f.Content)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGenerateEscaping(t *testing.T) {
+	p := profiles[L2TP]
+	p.Password = "a<b>&c'd"
+	files, err := Generate(p, MobileConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(files[0].Content), "<string>a&lt;b&gt;&amp;c&apos;d</string>") {
+		t.Errorf("mobileconfig 中的密码未转义:\n%s", files[0].Content)
+	}
+
+	files, err = Generate(p, Windows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(files[0].Content), "$Password = 'a<b>&c''d'") {
+		t.Errorf("PowerShell 中的密码未转义:\n%s", files[0].Content)
+	}
+}
+
+func TestGenerateRejects(t *testing.T) {
+	noPSK, newline := profiles[L2TP], profiles[L2TP]
+	noPSK.PSK = ""
+	newline.Password = "a\nb"
+	tests := []struct {
+		name   string
+		p      Profile
+		format string
+	}{
+		{"mobileconfig 不应支持 PPTP", profiles[PPTP], MobileConfig},
+		{"未知格式应返回错误", profiles[L2TP], "openvpn"},
+		{"L2TP 缺少 PSK 时应返回错误", noPSK, Linux},
+		{"密码包含换行时应返回错误", newline, NetworkManager},
+	}
+	for _, tt := range tests {
+		if _, err := Generate(tt.p, tt.format); err == nil {
+			t.Error(tt.name)
+		}
+	}
+}
+
+func TestUUIDStable(t *testing.T) {
+	a, b := uuidFor("x", "y"), uuidFor("x", "y")
+	if a != b || a == uuidFor("x", "z") {
+		t.Errorf("UUID 应由参数唯一确定: %s %s", a, b)
+	}
+	if len(a) != 36 || a[14] != '5' {
+		t.Errorf("UUID 格式不正确: %s", a)
+	}
+}
@@ -0,0 +1,214 @@
+// Package publicip 探测服务器的公网地址：按协议族并发请求多个回显服务，
+// 至少两个来源返回同一地址才采用，全部失败时可改用默认路由的源地址
+package publicip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// Family 地址协议族
+type Family string
+
+const (
+	IPv4 Family = "IPv4"
+	IPv6 Family = "IPv6"
+)
+
+// network 强制请求使用该协议族连接，回显服务按连接的协议族返回地址
+func (f Family) network() string {
+	if f == IPv6 {
+		return "tcp6"
+	}
+	return "tcp4"
+}
+
+// FamilyOf 返回地址所属的协议族
+func FamilyOf(addr netip.Addr) Family {
+	if addr.Unmap().Is4() {
+		return IPv4
+	}
+	return IPv6
+}
+
+// Sources 返回访问者 IP 的服务，使用 HTTPS 避免被透明代理或认证页面改写。
+// 只支持单一协议族的服务在另一协议族上连接失败，不影响结果
+var Sources = []string{
+	"https://api64.ipify.org",
+	"https://icanhazip.com",
+	"https://api.ip.sb/ip",
+	"https://ifconfig.co/ip",
+	"https://checkip.amazonaws.com",
+	"https://4.ipw.cn",
+	"https://6.ipw.cn",
+}
+
+// Quorum 采用一个地址所需的一致来源数
+const Quorum = 2
+
+// Fetcher 通过指定协议族请求 url，返回响应正文
+type Fetcher func(ctx context.Context, family Family, url string) (string, error)
+
+// HTTPFetcher 通过 HTTP(S) 请求回显服务，只读取正文的前 256 字节
+func HTTPFetcher(ctx context.Context, family Family, url string) (string, error) {
+	dialer := &net.Dialer{}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, family.network(), addr)
+		},
+	}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	// 部分服务按 User-Agent 判断返回纯文本还是网页
+	req.Header.Set("User-Agent", "curl/8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	return string(body), err
+}
+
+// Parse 校验回显服务返回的正文：必须是单个该协议族的全局单播地址，
+// 认证页面、错误页面以及回环、链路本地地址都会被拒绝
+func Parse(body string, family Family) (netip.Addr, error) {
+	s := strings.TrimSpace(body)
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		if len(s) > 32 {
+			s = s[:32] + "..."
+		}
+		return netip.Addr{}, fmt.Errorf("返回的不是 IP 地址: %q", s)
+	}
+	addr = addr.Unmap()
+	if FamilyOf(addr) != family {
+		return netip.Addr{}, fmt.Errorf("返回了 %s 地址 %s", FamilyOf(addr), addr)
+	}
+	if !addr.IsGlobalUnicast() {
+		return netip.Addr{}, fmt.Errorf("%s 不是单播地址", addr)
+	}
+	return addr, nil
+}
+
+// cgnat 运营商级 NAT 共享地址 (RFC 6598)
+var cgnat = netip.MustParsePrefix("100.64.0.0/10")
+
+// Public 地址是否可以从公网访问，私有地址、ULA 与共享地址都不是
+func Public(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !cgnat.Contains(addr)
+}
+
+// Discover 并发请求全部来源，某个地址得到 Quorum 个来源一致返回时立即采用；
+// 来源之间不一致或成功的来源不足时返回错误，并列出各来源的结果
+func Discover(ctx context.Context, family Family, sources []string, fetch Fetcher) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		source string
+		addr   netip.Addr
+		err    error
+	}
+	results := make(chan result, len(sources))
+	for _, source := range sources {
+		go func() {
+			body, err := fetch(ctx, family, source)
+			if err != nil {
+				results <- result{source: source, err: err}
+				return
+			}
+			addr, err := Parse(body, family)
+			if err == nil && !Public(addr) {
+				err = fmt.Errorf("返回了非公网地址 %s", addr)
+			}
+			results <- result{source: source, addr: addr, err: err}
+		}()
+	}
+
+	quorum := min(Quorum, len(sources))
+	votes := make(map[netip.Addr]int)
+	var failures []string
+	for range sources {
+		r := <-results
+		if r.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", r.source, r.err))
+			continue
+		}
+		votes[r.addr]++
+		if votes[r.addr] >= quorum {
+			return r.addr.String(), nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", r.source, r.addr))
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("没有可用的 %s 探测来源", family)
+	}
+	sort.Strings(failures)
+	return "", fmt.Errorf("没有 %d 个来源返回一致的 %s 地址:\n    %s", quorum, family, strings.Join(failures, "\n    "))
+}
+
+// routeTargets 用于选择源地址的目的地址，UDP 连接不会发出任何数据包
+var routeTargets = map[Family]string{
+	IPv4: "8.8.8.8:53",
+	IPv6: "[2001:4860:4860::8888]:53",
+}
+
+// RouteAddress 返回内核访问公网时选择的源地址，即默认路由所在网卡的地址。
+// 服务器位于 NAT 之后时这是私有地址，调用方需要用 Public 判断
+func RouteAddress(family Family) (netip.Addr, error) {
+	conn, err := net.Dial("udp"+strings.TrimPrefix(family.network(), "tcp"), routeTargets[family])
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("没有 %s 默认路由: %v", family, err)
+	}
+	defer conn.Close()
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("无法读取 %s 源地址", family)
+	}
+	addr, ok := netip.AddrFromSlice(local.IP)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("无法读取 %s 源地址", family)
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() {
+		return netip.Addr{}, fmt.Errorf("默认路由的源地址 %s 不是单播地址", addr)
+	}
+	return addr, nil
+}
+
+// ParseOverride 解析手动指定的地址，双栈时用逗号分隔 IPv4 与 IPv6，每个协议族最多一个
+func ParseOverride(s string) (map[Family]netip.Addr, error) {
+	addrs := make(map[Family]netip.Addr)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil || addr.Zone() != "" {
+			return nil, fmt.Errorf("%q 不是 IP 地址", item)
+		}
+		addr = addr.Unmap()
+		if !addr.IsGlobalUnicast() {
+			return nil, fmt.Errorf("%s 不能作为服务器地址", addr)
+		}
+		family := FamilyOf(addr)
+		if _, ok := addrs[family]; ok {
+			return nil, fmt.Errorf("指定了多个 %s 地址", family)
+		}
+		addrs[family] = addr
+	}
+	return addrs, nil
+}
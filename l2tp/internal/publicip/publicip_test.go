@@ -0,0 +1,112 @@
+package publicip
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		body   string
+		family Family
+		want   string
+	}{
+		{"203.0.113.10\n", IPv4, "203.0.113.10"},
+		{"2001:db8::10\n", IPv6, "2001:db8::10"},
+		{"::ffff:203.0.113.10", IPv4, "203.0.113.10"},
+		{"2001:db8::10", IPv4, ""},
+		{"203.0.113.10", IPv6, ""},
+		{"127.0.0.1", IPv4, ""},
+		{"fe80::1", IPv6, ""},
+		{"0.0.0.0", IPv4, ""},
+		{"<html><head><title>Wi-Fi 登录</title></head></html>", IPv4, ""},
+		{"203.0.113.10 203.0.113.11", IPv4, ""},
+	} {
+		addr, err := Parse(c.body, c.family)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%q (%s) 应被拒绝，得到 %s", c.body, c.family, addr)
+			}
+			continue
+		}
+		if err != nil || addr.String() != c.want {
+			t.Errorf("%q (%s) = %s, %v，期望 %s", c.body, c.family, addr, err, c.want)
+		}
+	}
+}
+
+// fakeFetcher 按 URL 返回固定结果
+func fakeFetcher(bodies map[string]string) Fetcher {
+	return func(_ context.Context, _ Family, url string) (string, error) {
+		if body, ok := bodies[url]; ok {
+			return body, nil
+		}
+		return "", errors.New("连接超时")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	sources := []string{"a", "b", "c", "d"}
+	ctx := context.Background()
+
+	// 认证页面与私有地址不计票，两个来源一致即可
+	ip, err := Discover(ctx, IPv4, sources, fakeFetcher(map[string]string{
+		"a": "<html>login</html>", "b": "203.0.113.10", "c": "10.0.0.5", "d": "203.0.113.10\n",
+	}))
+	if err != nil || ip != "203.0.113.10" {
+		t.Errorf("Discover = %s, %v", ip, err)
+	}
+
+	// 只有一个来源成功时不能确认
+	_, err = Discover(ctx, IPv4, sources, fakeFetcher(map[string]string{"a": "203.0.113.10"}))
+	if err == nil {
+		t.Fatal("只有一个来源时应返回错误")
+	}
+	for _, want := range []string{"a: 203.0.113.10", "b: 连接超时"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("错误中缺少 %q: %v", want, err)
+		}
+	}
+
+	// 来源之间不一致
+	_, err = Discover(ctx, IPv4, sources, fakeFetcher(map[string]string{"a": "203.0.113.10", "b": "198.51.100.7"}))
+	if err == nil {
+		t.Error("来源不一致时应返回错误")
+	}
+
+	// IPv6 探测不接受 IPv4 结果
+	_, err = Discover(ctx, IPv6, sources, fakeFetcher(map[string]string{"a": "203.0.113.10", "b": "203.0.113.10"}))
+	if err == nil {
+		t.Error("IPv6 探测得到 IPv4 地址时应返回错误")
+	}
+}
+
+func TestPublic(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"203.0.113.10": true, "2001:db8::1": true,
+		"10.0.0.1": false, "192.168.1.1": false, "100.64.1.1": false, "fd00::1": false, "127.0.0.1": false,
+	} {
+		parsed, _ := ParseOverride(addr)
+		got := false
+		for _, a := range parsed {
+			got = Public(a)
+		}
+		if got != want {
+			t.Errorf("Public(%s) = %v，期望 %v", addr, got, want)
+		}
+	}
+}
+
+func TestParseOverride(t *testing.T) {
+	addrs, err := ParseOverride(" 203.0.113.10 , 2001:db8::10")
+	if err != nil || addrs[IPv4].String() != "203.0.113.10" || addrs[IPv6].String() != "2001:db8::10" {
+		t.Errorf("ParseOverride = %v, %v", addrs, err)
+	}
+	for _, s := range []string{"127.0.0.1", "example.com", "203.0.113.10,198.51.100.7", "fe80::1%eth0"} {
+		if _, err := ParseOverride(s); err == nil {
+			t.Errorf("%q 应被拒绝", s)
+		}
+	}
+}
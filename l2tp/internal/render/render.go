@@ -0,0 +1,296 @@
+// Package render 根据安装参数生成 L2TP/IPSec 与 PPTP 各守护进程的配置文件内容，不直接写盘
+package render
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"maps"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"v6head": v6Head,
+	"v6dns":  v6DNS,
+}).ParseFS(templateFS, "templates/*.tmpl"))
+
+// Settings 渲染所需的全部参数
+type Settings struct {
+	PublicIP string
+	// PublicIPv6 双栈主机的公网 IPv6，非空时 L2TP/IPSec 按协议族分别声明 leftid
+	PublicIPv6 string
+	L2TP       L2TP
+	PPTP       PPTP
+	IKEv2      IKEv2
+	IPv6       IPv6
+	Users      []User
+}
+
+// IPv6 客户端 IPv6 参数，前缀为空表示对应协议不分配 IPv6
+type IPv6 struct {
+	Enabled bool
+	// L2TPPrefix 与 PPTPPrefix 为 /56，客户端 IPv4 的最后一段作为其 /64 的编号
+	L2TPPrefix string
+	PPTPPrefix string
+	// IKEv2Pool IKEv2 客户端地址池
+	IKEv2Pool string
+	// DNS 逗号分隔的 IPv6 DNS
+	DNS string
+}
+
+// L2TP L2TP/IPSec 参数，IPRange 为 /24 网段前三段，例如 10.10.10
+type L2TP struct {
+	Enabled bool
+	IPRange string
+	Port    string
+	PSK     string
+}
+
+// PPTP PPTP 参数
+type PPTP struct {
+	Enabled bool
+	IPRange string
+}
+
+// IKEv2 基于 strongSwan 的 IKEv2 配置，与 L2TP/IPSec PSK 共存
+type IKEv2 struct {
+	Enabled bool
+	// Auth 客户端认证方式: eap (EAP-MSCHAPv2) 或 cert (客户端证书)
+	Auth string
+	// IPRange 分配给客户端的虚拟 IP 网段前三段
+	IPRange string
+	// ServerID 服务端标识，需与证书 SAN 一致
+	ServerID   string
+	ServerCert string
+	ServerKey  string
+	// CACert 内置 CA 证书路径，设置后在 ipsec.conf 中声明 CA，证书认证时只接受该 CA 签发的客户端
+	CACert string
+	// KeyType ipsec.secrets 中私钥类型: RSA 或 ECDSA
+	KeyType string
+	DNS     string
+	Users   []EAPUser
+}
+
+// EAPUser ipsec.secrets 中的 EAP 账号
+type EAPUser struct {
+	Name   string
+	Secret string
+}
+
+// User chap-secrets 中的一条账号记录
+type User struct {
+	Name   string
+	Server string
+	Secret string
+	IP     string
+}
+
+// File 一个待写入的文件，配置、服务与客户端配置的生成器共用
+type File struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// 文件所属的功能，未启用的功能不生成对应文件
+const (
+	protocolL2TP = "l2tp"
+	protocolPPTP = "pptp"
+	featureIPv6  = "ipv6"
+)
+
+// fileSpec 描述模板与目标文件的对应关系
+type fileSpec struct {
+	template string
+	path     string
+	mode     os.FileMode
+	// protocol 为空表示所有协议共用
+	protocol string
+}
+
+var fileSpecs = []fileSpec{
+	{"ipsec.conf.tmpl", "/etc/ipsec.conf", 0644, protocolL2TP},
+	{"ipsec.secrets.tmpl", "/etc/ipsec.secrets", 0600, protocolL2TP},
+	{"xl2tpd.conf.tmpl", "/etc/xl2tpd/xl2tpd.conf", 0644, protocolL2TP},
+	{"options.xl2tpd.tmpl", "/etc/ppp/options.xl2tpd", 0644, protocolL2TP},
+	{"pptpd.conf.tmpl", "/etc/pptpd.conf", 0644, protocolPPTP},
+	{"pptpd-options.tmpl", "/etc/ppp/pptpd-options", 0644, protocolPPTP},
+	{"chap-secrets.tmpl", "/etc/ppp/chap-secrets", 0600, ""},
+	{"ipv6-up.tmpl", "/etc/ppp/ip-up.d/l2tp-vpn-ipv6", 0755, featureIPv6},
+	{"ipv6-ra.conf.tmpl", "/etc/l2tp/ipv6-ra.conf", 0644, featureIPv6},
+	{"l2tp-ipv6-ra.service.tmpl", "/etc/systemd/system/l2tp-ipv6-ra.service", 0644, featureIPv6},
+}
+
+// RAServiceName 在 PPP 接口上发送 IPv6 RA 的服务
+const RAServiceName = "l2tp-ipv6-ra"
+
+// TProxyServiceName 开机时配置透明代理策略路由的服务
+const TProxyServiceName = "l2tp-tproxy"
+
+// TProxyRoute 透明代理策略路由参数，带 Mark 标记的流量查询路由表 Table 并交给本机
+type TProxyRoute struct {
+	Mark  string
+	Table string
+}
+
+// TProxyService 生成配置策略路由的 systemd 服务，TPROXY 规则本身由防火墙后端持久化
+func TProxyService(r TProxyRoute) (File, error) {
+	if !isNumber(r.Mark) || !isNumber(r.Table) {
+		return File{}, fmt.Errorf("透明代理标记与路由表应为数字: %+v", r)
+	}
+	var buf bytes.Buffer
+	if err := templates.ExecuteTemplate(&buf, "l2tp-tproxy.service.tmpl", r); err != nil {
+		return File{}, fmt.Errorf("渲染 %s 失败: %v", TProxyServiceName, err)
+	}
+	return File{Path: "/etc/systemd/system/" + TProxyServiceName + ".service", Content: buf.Bytes(), Mode: 0644}, nil
+}
+
+func isNumber(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// Render 生成已启用协议的配置文件，顺序固定
+func Render(s Settings) ([]File, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	files := make([]File, 0, len(fileSpecs))
+	for _, spec := range fileSpecs {
+		if !s.enabled(spec.protocol) {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, spec.template, s); err != nil {
+			return nil, fmt.Errorf("渲染 %s 失败: %v", spec.path, err)
+		}
+		files = append(files, File{Path: spec.path, Content: buf.Bytes(), Mode: spec.mode})
+	}
+	return files, nil
+}
+
+func (s Settings) enabled(feature string) bool {
+	switch feature {
+	case protocolL2TP:
+		return s.L2TP.Enabled
+	case protocolPPTP:
+		return s.PPTP.Enabled
+	case featureIPv6:
+		// 只有 PPP 客户端需要 ip-up 脚本与 RA，IKEv2 由 strongSwan 直接分配地址
+		return s.IPv6.Enabled && (s.IPv6.L2TPPrefix != "" || s.IPv6.PPTPPrefix != "")
+	}
+	return true
+}
+
+func (s Settings) validate() error {
+	if !s.L2TP.Enabled && !s.PPTP.Enabled {
+		return fmt.Errorf("至少需要启用 L2TP 或 PPTP 其中之一")
+	}
+	required := map[string]string{"PublicIP": s.PublicIP}
+	if s.L2TP.Enabled {
+		required["L2TP.IPRange"] = s.L2TP.IPRange
+		required["L2TP.Port"] = s.L2TP.Port
+		required["L2TP.PSK"] = s.L2TP.PSK
+	}
+	if s.PPTP.Enabled {
+		required["PPTP.IPRange"] = s.PPTP.IPRange
+	}
+	for _, name := range slices.Sorted(maps.Keys(required)) {
+		if required[name] == "" {
+			return fmt.Errorf("缺少参数: %s", name)
+		}
+	}
+	if strings.ContainsAny(s.L2TP.PSK, "\"\n") {
+		return fmt.Errorf("PSK 不能包含双引号或换行")
+	}
+	for _, u := range s.Users {
+		if u.Name == "" || u.Server == "" || u.Secret == "" {
+			return fmt.Errorf("账号记录不完整: %+v", u)
+		}
+	}
+	if s.IPv6.Enabled {
+		if err := s.IPv6.validate(); err != nil {
+			return err
+		}
+	}
+	if s.IKEv2.Enabled {
+		if !s.L2TP.Enabled {
+			return fmt.Errorf("IKEv2 需要同时启用 L2TP/IPSec")
+		}
+		return s.IKEv2.validate()
+	}
+	return nil
+}
+
+func (k IKEv2) validate() error {
+	if k.Auth != "eap" && k.Auth != "cert" {
+		return fmt.Errorf("IKEv2 认证方式应为 eap 或 cert，当前为 %q", k.Auth)
+	}
+	if k.KeyType != "RSA" && k.KeyType != "ECDSA" {
+		return fmt.Errorf("IKEv2 私钥类型应为 RSA 或 ECDSA，当前为 %q", k.KeyType)
+	}
+	required := []struct{ name, value string }{
+		{"IKEv2.IPRange", k.IPRange},
+		{"IKEv2.ServerID", k.ServerID},
+		{"IKEv2.ServerCert", k.ServerCert},
+		{"IKEv2.ServerKey", k.ServerKey},
+		{"IKEv2.DNS", k.DNS},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return fmt.Errorf("缺少参数: %s", r.name)
+		}
+	}
+	if k.Auth == "eap" && len(k.Users) == 0 {
+		return fmt.Errorf("EAP 认证至少需要一个账号")
+	}
+	for _, u := range k.Users {
+		if u.Name == "" || strings.ContainsAny(u.Name, " \t\"") || u.Secret == "" || strings.ContainsAny(u.Secret, "\"\n") {
+			return fmt.Errorf("EAP 账号 %q 的用户名或密码无效", u.Name)
+		}
+	}
+	return nil
+}
+
+func (v IPv6) validate() error {
+	for name, prefix := range map[string]string{"IPv6.L2TPPrefix": v.L2TPPrefix, "IPv6.PPTPPrefix": v.PPTPPrefix} {
+		if prefix == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(prefix); err != nil || !p.Addr().Is6() || p.Bits() != 56 || p.Masked() != p {
+			return fmt.Errorf("%s 应为 IPv6 /56 前缀，当前为 %q", name, prefix)
+		}
+	}
+	if v.IKEv2Pool != "" {
+		if p, err := netip.ParsePrefix(v.IKEv2Pool); err != nil || !p.Addr().Is6() {
+			return fmt.Errorf("IPv6.IKEv2Pool 应为 IPv6 前缀，当前为 %q", v.IKEv2Pool)
+		}
+	}
+	if v.DNS == "" {
+		return fmt.Errorf("缺少参数: IPv6.DNS")
+	}
+	return nil
+}
+
+// v6Head 返回 /56 前缀的前 56 位文本，ip-up 脚本在其后拼接两位十六进制的客户端编号得到 /64，
+// 例如 fd12:3456:789a:100::/56 返回 fd12:3456:789a:01
+func v6Head(prefix string) string {
+	b := netip.MustParsePrefix(prefix).Addr().As16()
+	return fmt.Sprintf("%x:%x:%x:%02x", uint16(b[0])<<8|uint16(b[1]), uint16(b[2])<<8|uint16(b[3]), uint16(b[4])<<8|uint16(b[5]), b[6])
+}
+
+// v6DNS 将逗号分隔的地址转换为 dnsmasq 的 [addr],[addr] 格式
+func v6DNS(dns string) string {
+	var addrs []string
+	for _, addr := range strings.Split(dns, ",") {
+		addrs = append(addrs, "["+strings.TrimSpace(addr)+"]")
+	}
+	return strings.Join(addrs, ",")
+}
@@ -0,0 +1,208 @@
+package render
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用当前渲染结果覆盖 testdata 下的 golden 文件")
+
+// goldenCases 每个场景对应 testdata 下的一个目录
+func goldenCases() map[string]Settings {
+	defaults := Settings{
+		PublicIP: "203.0.113.10",
+		L2TP:     L2TP{Enabled: true, IPRange: "10.10.10", Port: "1701", PSK: "testpsk"},
+		PPTP:     PPTP{Enabled: true, IPRange: "192.168.30"},
+		Users: []User{
+			{Name: "alice", Server: "l2tpd", Secret: "alicepass", IP: "10.10.10.10"},
+			{Name: "bob", Server: "pptpd", Secret: "bobpass", IP: "192.168.30.10"},
+			{Name: "carol", Server: "l2tpd", Secret: "carolpass", IP: "*"},
+		},
+	}
+
+	ikev2EAP := defaults
+	ikev2EAP.IKEv2 = IKEv2{
+		Enabled:    true,
+		Auth:       "eap",
+		IPRange:    "10.10.20",
+		ServerID:   "203.0.113.10",
+		ServerCert: "/etc/ipsec.d/certs/server.crt",
+		ServerKey:  "/etc/ipsec.d/private/server.key",
+		KeyType:    "RSA",
+		DNS:        "8.8.8.8,1.1.1.1",
+		Users:      []EAPUser{{Name: "alice", Secret: "alicepass"}},
+	}
+
+	ikev2Cert := ikev2EAP
+	ikev2Cert.IKEv2.Auth = "cert"
+	ikev2Cert.IKEv2.KeyType = "ECDSA"
+	ikev2Cert.IKEv2.Users = nil
+	ikev2Cert.IKEv2.CACert = "/etc/ipsec.d/cacerts/l2tp-ca.crt"
+
+	l2tpOnly := defaults
+	l2tpOnly.PPTP = PPTP{}
+	l2tpOnly.Users = []User{
+		{Name: "alice", Server: "l2tpd", Secret: "alicepass", IP: "10.10.10.10"},
+	}
+
+	dualStack := ikev2EAP
+	dualStack.PublicIPv6 = "2001:db8::10"
+	dualStack.IPv6 = IPv6{
+		Enabled:    true,
+		L2TPPrefix: "fd12:3456:789a::/56",
+		PPTPPrefix: "fd12:3456:789a:100::/56",
+		IKEv2Pool:  "fd12:3456:789a:200::/112",
+		DNS:        "2001:4860:4860::8888,2606:4700:4700::1111",
+	}
+
+	return map[string]Settings{
+		"default":    defaults,
+		"ikev2-eap":  ikev2EAP,
+		"ikev2-cert": ikev2Cert,
+		"l2tp-only":  l2tpOnly,
+		"dual-stack": dualStack,
+	}
+}
+
+func TestRenderGolden(t *testing.T) {
+	for name, settings := range goldenCases() {
+		t.Run(name, func(t *testing.T) {
+			files, err := Render(settings)
+			if err != nil {
+				t.Fatalf("渲染失败: %v", err)
+			}
+
+			for _, f := range files {
+				checkGolden(t, name, f)
+			}
+		})
+	}
+}
+
+// checkGolden 对比 testdata/<dir> 下的 golden 文件，-update 时覆盖
+func checkGolden(t *testing.T, dir string, f File) {
+	t.Helper()
+	dir = filepath.Join("testdata", dir)
+	golden := filepath.Join(dir, filepath.Base(f.Path)+".golden")
+	if *update {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, f.Content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("读取 %s 失败: %v (使用 -update 生成)", golden, err)
+	}
+	if string(want) != string(f.Content) {
+		t.Errorf("%s 与 %s 不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", f.Path, golden, want, f.Content)
+	}
+}
+
+func TestRenderModes(t *testing.T) {
+	files, err := Render(goldenCases()["default"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := map[string]bool{"/etc/ipsec.secrets": true, "/etc/ppp/chap-secrets": true}
+	for _, f := range files {
+		if secret[f.Path] && f.Mode != 0600 {
+			t.Errorf("%s 权限应为 0600，实际 %o", f.Path, f.Mode)
+		}
+	}
+}
+
+func TestRenderMissingField(t *testing.T) {
+	s := goldenCases()["default"]
+	s.L2TP.PSK = ""
+	if _, err := Render(s); err == nil {
+		t.Fatal("缺少 PSK 时应返回错误")
+	}
+
+	s = goldenCases()["ikev2-eap"]
+	s.IKEv2.Users = nil
+	if _, err := Render(s); err == nil {
+		t.Fatal("EAP 认证缺少账号时应返回错误")
+	}
+}
+
+func TestRenderProtocols(t *testing.T) {
+	paths := func(s Settings) []string {
+		t.Helper()
+		files, err := Render(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, f.Path)
+		}
+		return paths
+	}
+
+	s := goldenCases()["default"]
+	s.PPTP.Enabled = false
+	for _, p := range paths(s) {
+		if strings.Contains(p, "pptpd") {
+			t.Errorf("未启用 PPTP 时不应生成 %s", p)
+		}
+	}
+
+	s = goldenCases()["default"]
+	s.L2TP = L2TP{}
+	want := []string{"/etc/pptpd.conf", "/etc/ppp/pptpd-options", "/etc/ppp/chap-secrets"}
+	if got := paths(s); !slices.Equal(got, want) {
+		t.Errorf("仅 PPTP 时生成的文件 = %v，期望 %v", got, want)
+	}
+
+	s.PPTP.Enabled = false
+	if _, err := Render(s); err == nil {
+		t.Fatal("两种协议均未启用时应返回错误")
+	}
+
+	s = goldenCases()["ikev2-eap"]
+	s.L2TP.Enabled = false
+	if _, err := Render(s); err == nil {
+		t.Fatal("未启用 L2TP 时不应允许 IKEv2")
+	}
+}
+
+func TestV6Head(t *testing.T) {
+	cases := map[string]string{
+		"fd12:3456:789a::/56":     "fd12:3456:789a:00",
+		"fd12:3456:789a:100::/56": "fd12:3456:789a:01",
+		"2001:db8:0:ab00::/56":    "2001:db8:0:ab",
+	}
+	for prefix, want := range cases {
+		if got := v6Head(prefix); got != want {
+			t.Errorf("v6Head(%s) = %s，期望 %s", prefix, got, want)
+		}
+	}
+}
+
+func TestRenderIPv6Invalid(t *testing.T) {
+	s := goldenCases()["dual-stack"]
+	s.IPv6.L2TPPrefix = "fd12:3456:789a::/64"
+	if _, err := Render(s); err == nil {
+		t.Fatal("L2TP 前缀不是 /56 时应返回错误")
+	}
+}
+
+func TestTProxyService(t *testing.T) {
+	f, err := TProxyService(TProxyRoute{Mark: "1", Table: "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "tproxy", f)
+
+	if _, err := TProxyService(TProxyRoute{Mark: "1; reboot", Table: "100"}); err == nil {
+		t.Fatal("标记不是数字时应返回错误")
+	}
+}
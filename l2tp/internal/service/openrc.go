@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"text/template"
+
+	"l2tp/internal/render"
+)
+
+// openrc 将单元转换为 /etc/init.d 下的 openrc-run 脚本，常驻服务需要自动重启时由 supervise-daemon 托管
+type openrc struct {
+	cmd Commands
+}
+
+func (s *openrc) Name() string { return OpenRC }
+
+func (s *openrc) Path(name string) string { return "/etc/init.d/" + name }
+
+func (s *openrc) Install(name string, unit []byte) (render.File, error) {
+	u, err := ParseUnit(unit)
+	if err != nil {
+		return render.File{}, err
+	}
+	command, args := u.command()
+	var buf bytes.Buffer
+	err = openrcTemplate.Execute(&buf, struct {
+		*Unit
+		Command, Args string
+		Start, Stop   string
+		Network       bool
+		BeforeNet     bool
+		Services      []string
+	}{u, command, args, u.start("\t"), u.stop("\t"), u.needsNetwork(), u.beforeNetwork(), u.afterServices()})
+	if err != nil {
+		return render.File{}, err
+	}
+	return render.File{Path: s.Path(name), Content: buf.Bytes(), Mode: 0755}, nil
+}
+
+// Reload OpenRC 每次执行时读取脚本，不需要重新加载
+func (s *openrc) Reload() error { return nil }
+
+func (s *openrc) Enable(name string) error { return s.cmd.Run("rc-update", "add", name, "default") }
+
+func (s *openrc) Disable(name string) error {
+	s.cmd.RunQuiet("rc-service", name, "stop")
+	return s.cmd.RunQuiet("rc-update", "del", name, "default")
+}
+
+func (s *openrc) Restart(name string) error { return s.cmd.Run("rc-service", name, "restart") }
+
+func (s *openrc) Active(name string) bool {
+	_, err := s.cmd.Output("rc-service", name, "status")
+	return err == nil
+}
+
+func (s *openrc) Exists(name string) bool { return fileExists(s.Path(name)) }
+
+func (s *openrc) Logs(name string) string { return "/var/log/" + name + ".log" }
+
+var openrcTemplate = template.Must(template.New("openrc").Parse(`#!/sbin/openrc-run
+# 由 l2tp 根据 systemd 单元生成，重新安装时覆盖
+
+description="{{.Description}}"
+{{- if not .Oneshot}}
+command="{{.Command}}"
+command_args="{{.Args}}"
+output_log="/var/log/${RC_SVCNAME}.log"
+error_log="/var/log/${RC_SVCNAME}.log"
+{{- if .Restart}}
+supervisor="supervise-daemon"
+{{- if .RestartSec}}
+respawn_delay={{.RestartSec}}
+{{- end}}
+{{- else}}
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+{{- end}}
+{{- end}}
+
+depend() {
+{{- if .Network}}
+	need net
+{{- end}}
+{{- if .BeforeNet}}
+	before net
+{{- end}}
+{{- range .Services}}
+	after {{.}}
+{{- end}}
+	use logger
+}
+{{- if .Oneshot}}
+
+start() {
+	ebegin "Starting ${RC_SVCNAME}"
+	{{.Start}}
+	eend $?
+}
+
+stop() {
+	ebegin "Stopping ${RC_SVCNAME}"
+	{{.Stop}}
+	eend $?
+}
+{{- end}}
+`))
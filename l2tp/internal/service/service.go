@@ -0,0 +1,95 @@
+// Package service 屏蔽 systemd、OpenRC 与 SysV init 的差异，启用、重启、查询服务。
+// 本工具的服务统一以 systemd 单元的形式生成，非 systemd 主机上由 Install 转换为对应的 init 脚本
+package service
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"l2tp/internal/render"
+)
+
+// 服务管理器名称
+const (
+	Systemd = "systemd"
+	OpenRC  = "openrc"
+	SysV    = "sysv"
+)
+
+// Managers 全部服务管理器
+var Managers = []string{Systemd, OpenRC, SysV}
+
+// UnitDir 本工具生成的 systemd 单元所在目录
+const UnitDir = "/etc/systemd/system"
+
+// Manager 服务管理器。Disable 同时停止服务，服务不存在时不报错
+type Manager interface {
+	Name() string
+	// Path 服务 name 的服务文件路径
+	Path(name string) string
+	// Install 将本工具生成的 systemd 单元转换为该管理器的服务文件，写入由调用方完成
+	Install(name string, unit []byte) (render.File, error)
+	// Reload 服务文件变更后重新加载
+	Reload() error
+	Enable(name string) error
+	Disable(name string) error
+	Restart(name string) error
+	// Active 服务是否正在运行
+	Active(name string) bool
+	// Exists 服务是否已安装
+	Exists(name string) bool
+	// Logs 查看服务日志的方式，用于错误提示
+	Logs(name string) string
+}
+
+// Commands 执行命令的函数，由调用方注入以支持 dry-run
+type Commands struct {
+	Run func(name string, args ...string) error
+	// RunQuiet 失败时不报错，用于停止可能不存在的服务
+	RunQuiet func(name string, args ...string) error
+	// Output 只读查询，dry-run 模式下同样执行
+	Output func(name string, args ...string) (string, error)
+}
+
+// New 创建指定名称的服务管理器
+func New(name string, cmd Commands) (Manager, error) {
+	switch name {
+	case Systemd:
+		return &systemd{cmd: cmd}, nil
+	case OpenRC:
+		return &openrc{cmd: cmd}, nil
+	case SysV:
+		return &sysv{cmd: cmd}, nil
+	}
+	return nil, fmt.Errorf("未知的服务管理器 %q，可选: %s", name, strings.Join(Managers, ", "))
+}
+
+// Detect 检测主机正在使用的服务管理器，无法判断时使用 systemd
+func Detect(cmd Commands) Manager {
+	m, _ := New(detect(func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}), cmd)
+	return m
+}
+
+// detect 按特征文件判断: systemd 运行时存在 /run/systemd/system，OpenRC 提供 openrc-run，
+// 其余带 /etc/init.d 的系统按 SysV 处理
+func detect(exists func(path string) bool) string {
+	switch {
+	case exists("/run/systemd/system"):
+		return Systemd
+	case exists("/sbin/openrc-run") || exists("/run/openrc"):
+		return OpenRC
+	case exists("/etc/init.d"):
+		return SysV
+	}
+	return Systemd
+}
+
+// fileExists 服务文件是否存在
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用当前生成结果覆盖 testdata 下的 golden 文件")
+
+// 与 l2tp-tproxy、l2tp-proxy 相同结构的单元，覆盖一次性服务与需要自动重启的常驻服务
+const (
+	oneshotUnit = `[Unit]
+Description=L2TP 透明代理策略路由
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+# 注释
+ExecStart=/bin/sh -c 'while ip rule del fwmark 1 table 100 2>/dev/null; do :; done; ip rule add fwmark 1 table 100'
+ExecStart=/bin/sh -c 'ip route replace local 0.0.0.0/0 dev lo table 100'
+ExecStop=/bin/sh -c 'ip route flush table 100 2>/dev/null || true'
+
+[Install]
+WantedBy=multi-user.target
+`
+	daemonUnit = `[Unit]
+Description=L2TP 透明代理 (sing-box)
+After=network-online.target l2tp-tproxy.service
+Wants=network-online.target
+
+[Service]
+ExecStart=/usr/bin/sing-box run -c /etc/l2tp/proxy.json
+Restart=on-failure
+RestartSec=5
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+`
+)
+
+func TestInstallGolden(t *testing.T) {
+	for _, name := range []string{OpenRC, SysV} {
+		m, err := New(name, Commands{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for svc, unit := range map[string]string{"l2tp-tproxy": oneshotUnit, "l2tp-proxy": daemonUnit} {
+			t.Run(name+"/"+svc, func(t *testing.T) {
+				f, err := m.Install(svc, []byte(unit))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Path != "/etc/init.d/"+svc || f.Mode != 0755 {
+					t.Errorf("路径 %s 权限 %o", f.Path, f.Mode)
+				}
+				golden := filepath.Join("testdata", name, svc+".golden")
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, f.Content, 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("读取 %s 失败: %v (使用 -update 生成)", golden, err)
+				}
+				if string(want) != string(f.Content) {
+					t.Errorf("与 %s 不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", golden, want, f.Content)
+				}
+			})
+		}
+	}
+}
+
+func TestSystemd(t *testing.T) {
+	var commands []string
+	run := func(name string, args ...string) error {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	}
+	m, _ := New(Systemd, Commands{Run: run, RunQuiet: run, Output: func(string, ...string) (string, error) {
+		return "", errors.New("not found")
+	}})
+
+	f, err := m.Install("l2tp-proxy", []byte(daemonUnit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Path != "/etc/systemd/system/l2tp-proxy.service" || string(f.Content) != daemonUnit {
+		t.Errorf("systemd 单元应原样写入，实际 %s", f.Path)
+	}
+	m.Reload()
+	m.Enable("l2tp-proxy")
+	m.Restart("l2tp-proxy")
+	m.Disable("l2tp-proxy")
+	want := []string{"systemctl daemon-reload", "systemctl enable l2tp-proxy", "systemctl restart l2tp-proxy", "systemctl disable --now l2tp-proxy"}
+	if !slices.Equal(commands, want) {
+		t.Errorf("命令 = %v", commands)
+	}
+	if m.Active("l2tp-proxy") || m.Exists("l2tp-proxy") {
+		t.Error("查询失败时应视为未运行、未安装")
+	}
+}
+
+func TestParseUnitInvalid(t *testing.T) {
+	for _, unit := range []string{
+		"[Service]\nType=oneshot\n",
+		"[Service]\nExecStart=/bin/a\nExecStart=/bin/b\n",
+		"[Service]\nExecStart\n",
+	} {
+		if _, err := ParseUnit([]byte(unit)); err == nil {
+			t.Errorf("应返回错误: %q", unit)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for want, files := range map[string][]string{
+		Systemd: {"/run/systemd/system", "/etc/init.d"},
+		OpenRC:  {"/sbin/openrc-run", "/etc/init.d"},
+		SysV:    {"/etc/init.d"},
+	} {
+		got := detect(func(path string) bool { return slices.Contains(files, path) })
+		if got != want {
+			t.Errorf("%v: 检测为 %s，期望 %s", files, got, want)
+		}
+	}
+	if got := detect(func(string) bool { return false }); got != Systemd {
+		t.Errorf("无法判断时应使用 systemd，实际 %s", got)
+	}
+}
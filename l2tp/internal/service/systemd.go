@@ -0,0 +1,41 @@
+package service
+
+import "l2tp/internal/render"
+
+// systemd 单元原样写入 UnitDir
+type systemd struct {
+	cmd Commands
+}
+
+func (s *systemd) Name() string { return Systemd }
+
+func (s *systemd) Path(name string) string { return UnitDir + "/" + name + ".service" }
+
+func (s *systemd) Install(name string, unit []byte) (render.File, error) {
+	if _, err := ParseUnit(unit); err != nil {
+		return render.File{}, err
+	}
+	return render.File{Path: s.Path(name), Content: unit, Mode: 0644}, nil
+}
+
+func (s *systemd) Reload() error { return s.cmd.Run("systemctl", "daemon-reload") }
+
+func (s *systemd) Enable(name string) error { return s.cmd.Run("systemctl", "enable", name) }
+
+func (s *systemd) Disable(name string) error {
+	return s.cmd.RunQuiet("systemctl", "disable", "--now", name)
+}
+
+func (s *systemd) Restart(name string) error { return s.cmd.Run("systemctl", "restart", name) }
+
+func (s *systemd) Active(name string) bool {
+	_, err := s.cmd.Output("systemctl", "is-active", "--quiet", name)
+	return err == nil
+}
+
+func (s *systemd) Exists(name string) bool {
+	_, err := s.cmd.Output("systemctl", "list-unit-files", name+".service")
+	return err == nil
+}
+
+func (s *systemd) Logs(name string) string { return "journalctl -u " + name }
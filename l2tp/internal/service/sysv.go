@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bytes"
+	"os/exec"
+	"text/template"
+
+	"l2tp/internal/render"
+)
+
+// sysv 将单元转换为 LSB init 脚本。常驻服务在后台运行并记录 pid，SysV 没有进程托管，不会自动重启
+type sysv struct {
+	cmd Commands
+}
+
+func (s *sysv) Name() string { return SysV }
+
+func (s *sysv) Path(name string) string { return "/etc/init.d/" + name }
+
+func (s *sysv) Install(name string, unit []byte) (render.File, error) {
+	u, err := ParseUnit(unit)
+	if err != nil {
+		return render.File{}, err
+	}
+	var buf bytes.Buffer
+	err = sysvTemplate.Execute(&buf, struct {
+		*Unit
+		Name        string
+		Start, Stop string
+		Network     bool
+		Services    []string
+	}{u, name, u.start("\t\t"), u.stop("\t\t"), u.needsNetwork(), u.afterServices()})
+	if err != nil {
+		return render.File{}, err
+	}
+	return render.File{Path: s.Path(name), Content: buf.Bytes(), Mode: 0755}, nil
+}
+
+// Reload SysV 每次执行时读取脚本，不需要重新加载
+func (s *sysv) Reload() error { return nil }
+
+// Enable Debian 系使用 update-rc.d，RHEL 系使用 chkconfig
+func (s *sysv) Enable(name string) error {
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		return s.cmd.Run("update-rc.d", name, "defaults")
+	}
+	return s.cmd.Run("chkconfig", "--add", name)
+}
+
+func (s *sysv) Disable(name string) error {
+	s.cmd.RunQuiet(s.Path(name), "stop")
+	if _, err := exec.LookPath("update-rc.d"); err == nil {
+		return s.cmd.RunQuiet("update-rc.d", "-f", name, "remove")
+	}
+	return s.cmd.RunQuiet("chkconfig", "--del", name)
+}
+
+func (s *sysv) Restart(name string) error { return s.cmd.Run(s.Path(name), "restart") }
+
+func (s *sysv) Active(name string) bool {
+	_, err := s.cmd.Output(s.Path(name), "status")
+	return err == nil
+}
+
+func (s *sysv) Exists(name string) bool { return fileExists(s.Path(name)) }
+
+func (s *sysv) Logs(name string) string { return "/var/log/" + name + ".log" }
+
+var sysvTemplate = template.Must(template.New("sysv").Parse(`#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{.Name}}
+# Required-Start:    $local_fs{{if .Network}} $network{{end}}{{range .Services}} {{.}}{{end}}
+# Required-Stop:     $local_fs{{if .Network}} $network{{end}}
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{.Description}}
+### END INIT INFO
+# 由 l2tp 根据 systemd 单元生成，重新安装时覆盖
+
+PIDFILE=/run/{{.Name}}.pid
+LOGFILE=/var/log/{{.Name}}.log
+
+start() {
+	status >/dev/null && return 0
+{{- if .Oneshot}}
+	{{.Start}} &&
+		touch "$PIDFILE"
+{{- else}}
+	nohup {{index .ExecStart 0}} >>"$LOGFILE" 2>&1 &
+	echo $! >"$PIDFILE"
+{{- end}}
+}
+
+stop() {
+{{- if .Oneshot}}
+	{{.Stop}}
+{{- else}}
+	[ -f "$PIDFILE" ] && kill "$(cat "$PIDFILE")" 2>/dev/null
+{{- end}}
+	rm -f "$PIDFILE"
+}
+
+status() {
+{{- if .Oneshot}}
+	[ -f "$PIDFILE" ]
+{{- else}}
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null
+{{- end}}
+}
+
+case "$1" in
+start) start ;;
+stop) stop ;;
+restart)
+	stop
+	start
+	;;
+status)
+	if status; then
+		echo "{{.Name}} is running"
+	else
+		echo "{{.Name}} is stopped"
+		exit 3
+	fi
+	;;
+*)
+	echo "Usage: $0 {start|stop|restart|status}"
+	exit 1
+	;;
+esac
+`))
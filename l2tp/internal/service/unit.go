@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Unit 本工具生成的 systemd 单元中用到的字段，其余字段在转换时忽略
+type Unit struct {
+	Description string
+	After       []string
+	Before      []string
+	// Oneshot Type=oneshot，执行完 ExecStart 即视为已启动
+	Oneshot   bool
+	ExecStart []string
+	ExecStop  []string
+	// Restart 进程异常退出后自动重启
+	Restart    bool
+	RestartSec string
+}
+
+// ParseUnit 解析 systemd 单元
+func ParseUnit(data []byte) (*Unit, error) {
+	u := &Unit{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("无法解析单元中的行: %s", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "Description":
+			u.Description = value
+		case "After":
+			u.After = append(u.After, strings.Fields(value)...)
+		case "Before":
+			u.Before = append(u.Before, strings.Fields(value)...)
+		case "Type":
+			u.Oneshot = value == "oneshot"
+		case "ExecStart":
+			u.ExecStart = append(u.ExecStart, strings.TrimPrefix(value, "-"))
+		case "ExecStop":
+			u.ExecStop = append(u.ExecStop, strings.TrimPrefix(value, "-"))
+		case "Restart":
+			u.Restart = value != "no"
+		case "RestartSec":
+			u.RestartSec = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(u.ExecStart) == 0 {
+		return nil, fmt.Errorf("单元缺少 ExecStart")
+	}
+	if !u.Oneshot && len(u.ExecStart) > 1 {
+		return nil, fmt.Errorf("常驻服务只能有一个 ExecStart")
+	}
+	return u, nil
+}
+
+// needsNetwork 是否在网络就绪后启动
+func (u *Unit) needsNetwork() bool {
+	for _, dep := range u.After {
+		if dep == "network.target" || dep == "network-online.target" {
+			return true
+		}
+	}
+	return false
+}
+
+// beforeNetwork 是否需要在网络就绪前启动，例如开机加载防火墙规则
+func (u *Unit) beforeNetwork() bool {
+	for _, dep := range u.Before {
+		if dep == "network.target" || dep == "network-online.target" {
+			return true
+		}
+	}
+	return false
+}
+
+// afterServices After 中依赖的其他服务
+func (u *Unit) afterServices() []string {
+	var services []string
+	for _, dep := range u.After {
+		if name, ok := strings.CutSuffix(dep, ".service"); ok {
+			services = append(services, name)
+		}
+	}
+	return services
+}
+
+// command 将常驻服务的 ExecStart 拆分为程序与参数
+func (u *Unit) command() (string, string) {
+	command, args, _ := strings.Cut(u.ExecStart[0], " ")
+	return command, strings.TrimSpace(args)
+}
+
+// start 一次性服务依次执行 ExecStart，任一命令失败即停止，indent 为续行的缩进
+func (u *Unit) start(indent string) string {
+	return strings.Join(u.ExecStart, " &&\n"+indent)
+}
+
+// stop 一次性服务依次执行 ExecStop，没有时为空操作
+func (u *Unit) stop(indent string) string {
+	if len(u.ExecStop) == 0 {
+		return "true"
+	}
+	return strings.Join(u.ExecStop, "\n"+indent)
+}
@@ -0,0 +1,260 @@
+// Package session 汇总在线客户端：PPP 会话来自 pppd 的 pid 文件、/proc 与 ip addr，
+// 流量来自 /proc/net/dev，IKEv2 会话来自 ipsec statusall
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 协议
+const (
+	L2TP  = "l2tp"
+	PPTP  = "pptp"
+	IKEv2 = "ikev2"
+)
+
+// Session 一个在线客户端
+type Session struct {
+	// Interface PPP 接口名，IKEv2 会话为空
+	Interface string `json:"interface,omitempty"`
+	User      string `json:"user"`
+	Protocol  string `json:"protocol"`
+	// IP 分配给客户端的内网地址，Remote 为客户端的公网地址
+	IP     string    `json:"ip"`
+	Remote string    `json:"remote,omitempty"`
+	Since  time.Time `json:"since"`
+	RX     uint64    `json:"rx_bytes"`
+	TX     uint64    `json:"tx_bytes"`
+	// PID 会话的 pppd 进程，IKEv2 会话为 0
+	PID int `json:"pid,omitempty"`
+	// SA IKEv2 会话的 IKE_SA 名称，例如 IKEv2-EAP[3]，用于 ipsec down
+	SA string `json:"sa,omitempty"`
+}
+
+// Traffic 接口的收发字节数
+type Traffic struct {
+	RX, TX uint64
+}
+
+// ParseNetDev 解析 /proc/net/dev，前两行为表头
+func ParseNetDev(data []byte) map[string]Traffic {
+	traffic := make(map[string]Traffic)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		name, stats, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(stats)
+		if len(fields) < 9 {
+			continue
+		}
+		rx, err1 := strconv.ParseUint(fields[0], 10, 64)
+		tx, err2 := strconv.ParseUint(fields[8], 10, 64)
+		if err1 == nil && err2 == nil {
+			traffic[strings.TrimSpace(name)] = Traffic{RX: rx, TX: tx}
+		}
+	}
+	return traffic
+}
+
+// ParsePeers 解析 ip -o addr show 的输出，返回点对点接口对端 (即客户端) 的地址。
+// 同一接口有 IPv4 与 IPv6 时优先 IPv4
+func ParsePeers(out string) map[string]string {
+	peers := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		// 5: ppp0    inet 10.10.10.1 peer 10.10.10.11/32 scope global ppp0
+		fields := strings.Fields(line)
+		if len(fields) < 6 || fields[4] != "peer" {
+			continue
+		}
+		name, peer := strings.TrimSuffix(fields[1], ":"), fields[5]
+		peer, _, _ = strings.Cut(peer, "/")
+		if _, ok := peers[name]; !ok || fields[2] == "inet" {
+			peers[name] = peer
+		}
+	}
+	return peers
+}
+
+// ParseCmdline 解析 pppd 的 /proc/<pid>/cmdline，按选项文件判断协议，
+// ipparam 为 xl2tpd 与 pptpd 传入的客户端公网地址
+func ParseCmdline(data []byte) (protocol, remote string) {
+	args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+	for i, arg := range args {
+		switch {
+		case strings.Contains(arg, "options.xl2tpd") || arg == "l2tpd":
+			protocol = L2TP
+		case strings.Contains(arg, "pptpd-options") || arg == "pptpd":
+			protocol = PPTP
+		case arg == "ipparam" && i+1 < len(args):
+			remote = args[i+1]
+		}
+	}
+	return protocol, remote
+}
+
+// ParseStartTime 由 /proc/<pid>/stat 的第 22 个字段 (开机后的时钟周期数) 与开机时间计算进程启动时间，
+// Linux 的 USER_HZ 固定为 100
+func ParseStartTime(stat []byte, boot time.Time) (time.Time, error) {
+	// 进程名可能含空格，从最后一个 ) 之后开始计数，第 3 个字段为状态
+	s := string(stat)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 20 {
+		return time.Time{}, fmt.Errorf("无法解析进程状态")
+	}
+	ticks, err := strconv.ParseInt(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / 100), nil
+}
+
+// ParseBootTime 从 /proc/stat 的 btime 读取开机时间
+func ParseBootTime(data []byte) (time.Time, error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("/proc/stat 中没有 btime")
+}
+
+var authLog = regexp.MustCompile(`pppd\[(\d+)\]: (?:MS-)?(?:CHAP|PAP|EAP) peer authentication succeeded for "?([^"\s]+)"?`)
+
+// ParsePPPLog 从 pppd 日志中找出每个进程认证成功的用户名
+func ParsePPPLog(logs string) map[int]string {
+	users := make(map[int]string)
+	for _, m := range authLog.FindAllStringSubmatch(logs, -1) {
+		if pid, err := strconv.Atoi(m[1]); err == nil {
+			users[pid] = m[2]
+		}
+	}
+	return users
+}
+
+var (
+	ikeSA   = regexp.MustCompile(`^\s*(IKEv2-\w+)\[(\d+)\]: ESTABLISHED (.+?) ago, .*\.\.\.([^\[\s]+)\[([^\]]*)\]`)
+	eapID   = regexp.MustCompile(`^\s*(IKEv2-\w+)\[(\d+)\]: Remote EAP identity: (.+)$`)
+	childSA = regexp.MustCompile(`^\s*(IKEv2-\w+)\{(\d+)\}:.*?(\d+) bytes_i.*?(\d+) bytes_o`)
+	childTS = regexp.MustCompile(`^\s*(IKEv2-\w+)\{(\d+)\}:\s+\S+ === ([^/\s]+)/`)
+)
+
+// ParseIPsec 解析 ipsec statusall 中的 IKEv2 会话。L2TP/IPsec 的 SA 只承载 L2TP，
+// 对应的客户端已经作为 PPP 会话列出，这里跳过。now 用于换算 "5 minutes ago"
+func ParseIPsec(out string, now time.Time) []Session {
+	var sessions []*Session
+	byIKE := make(map[string]*Session)
+	var lastIKE *Session
+	for _, line := range strings.Split(out, "\n") {
+		if m := ikeSA.FindStringSubmatch(line); m != nil {
+			s := &Session{
+				Protocol: IKEv2,
+				SA:       m[1] + "[" + m[2] + "]",
+				Remote:   m[4],
+				User:     m[5],
+				Since:    now.Add(-parseAgo(m[3])),
+			}
+			sessions = append(sessions, s)
+			byIKE[s.SA] = s
+			lastIKE = s
+			continue
+		}
+		if m := eapID.FindStringSubmatch(line); m != nil {
+			if s := byIKE[m[1]+"["+m[2]+"]"]; s != nil {
+				s.User = m[3]
+			}
+			continue
+		}
+		// CHILD_SA 紧跟在所属的 IKE_SA 之后
+		if lastIKE == nil {
+			continue
+		}
+		if m := childSA.FindStringSubmatch(line); m != nil {
+			rx, _ := strconv.ParseUint(m[3], 10, 64)
+			tx, _ := strconv.ParseUint(m[4], 10, 64)
+			lastIKE.RX += rx
+			lastIKE.TX += tx
+		} else if m := childTS.FindStringSubmatch(line); m != nil && lastIKE.IP == "" {
+			// 本端网段 === 客户端的虚拟 IP，有 IPv6 地址池时取第一条
+			lastIKE.IP = m[3]
+		}
+	}
+	result := make([]Session, len(sessions))
+	for i, s := range sessions {
+		result[i] = *s
+	}
+	return result
+}
+
+// parseAgo 解析 strongSwan 的 "5 minutes"、"2 hours"、"30 seconds"
+func parseAgo(s string) time.Duration {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0
+	}
+	unit := map[string]time.Duration{"second": time.Second, "minute": time.Minute, "hour": time.Hour, "day": 24 * time.Hour}
+	return time.Duration(n) * unit[strings.TrimSuffix(fields[1], "s")]
+}
+
+// Write 以表格输出会话，now 用于计算在线时长
+func Write(w io.Writer, sessions []Session, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "用户\t协议\t接口\t内网 IP\t客户端地址\t在线时长\t接收\t发送")
+	for _, s := range sessions {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			orDash(s.User), s.Protocol, orDash(s.Interface), orDash(s.IP), orDash(s.Remote),
+			formatDuration(now.Sub(s.Since)), formatBytes(s.RX), formatBytes(s.TX))
+	}
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatDuration 输出 1d2h3m、2h3m 或 3m4s
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	days, d := d/(24*time.Hour), d%(24*time.Hour)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh%dm", days, d/time.Hour, d%time.Hour/time.Minute)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%dm", d/time.Hour, d%time.Hour/time.Minute)
+	}
+	return fmt.Sprintf("%dm%ds", d/time.Minute, d%time.Minute/time.Second)
+}
+
+// formatBytes 以 1024 为进制输出
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetDev(t *testing.T) {
+	data := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1200      12    0    0    0     0          0         0     1200      12    0    0    0     0       0          0
+  ppp0: 5242880    4000    0    0    0     0          0         0 1048576     3000    0    0    0     0       0          0
+`
+	traffic := ParseNetDev([]byte(data))
+	if got := traffic["ppp0"]; got.RX != 5242880 || got.TX != 1048576 {
+		t.Errorf("ppp0 = %+v", got)
+	}
+	if len(traffic) != 2 {
+		t.Errorf("接口 = %v", traffic)
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	out := `1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 203.0.113.1/24 brd 203.0.113.255 scope global eth0\       valid_lft forever preferred_lft forever
+5: ppp0    inet6 fd00::1 peer fd00::2/128 scope global \       valid_lft forever preferred_lft forever
+5: ppp0    inet 10.10.10.1 peer 10.10.10.11/32 scope global ppp0\       valid_lft forever preferred_lft forever
+6: ppp1    inet 192.168.30.1 peer 192.168.30.12/32 scope global ppp1\       valid_lft forever preferred_lft forever`
+	peers := ParsePeers(out)
+	if peers["ppp0"] != "10.10.10.11" || peers["ppp1"] != "192.168.30.12" || len(peers) != 2 {
+		t.Errorf("peers = %v", peers)
+	}
+}
+
+func TestParseCmdline(t *testing.T) {
+	for _, c := range []struct {
+		cmdline, protocol, remote string
+	}{
+		{"/usr/sbin/pppd\x00passive\x00nodetach\x00:\x00name\x00l2tpd\x00file\x00/etc/ppp/options.xl2tpd\x00ipparam\x00198.51.100.3\x00/dev/pts/1\x00", L2TP, "198.51.100.3"},
+		{"/usr/sbin/pppd\x00local\x00file\x00/etc/ppp/pptpd-options\x00115200\x00ipparam\x00198.51.100.4\x00plugin\x00/usr/lib/pptpd/pptpd-logwtmp.so\x00", PPTP, "198.51.100.4"},
+		{"/usr/sbin/pppd\x00call\x00provider\x00", "", ""},
+	} {
+		protocol, remote := ParseCmdline([]byte(c.cmdline))
+		if protocol != c.protocol || remote != c.remote {
+			t.Errorf("%q: %s %s", c.cmdline, protocol, remote)
+		}
+	}
+}
+
+func TestParseStartTime(t *testing.T) {
+	boot, err := ParseBootTime([]byte("cpu  1 2 3\nbtime 1700000000\nprocesses 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := "1234 (pppd (x)) S 1 1234 1234 0 -1 4194560 200 0 0 0 1 2 0 0 20 0 1 0 360000 5000000 300 18446744073709551615"
+	start, err := ParseStartTime([]byte(stat), boot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(1700000000+3600, 0); !start.Equal(want) {
+		t.Errorf("启动时间 = %v，期望 %v", start, want)
+	}
+}
+
+func TestParsePPPLog(t *testing.T) {
+	logs := `Oct 18 10:00:01 vpn pppd[1234]: Using interface ppp0
+Oct 18 10:00:02 vpn pppd[1234]: MS-CHAP peer authentication succeeded for alice
+Oct 18 10:05:00 vpn pppd[1300]: CHAP peer authentication succeeded for "bob"
+Oct 18 10:06:00 vpn pppd[1301]: MS-CHAP peer authentication failed for mallory`
+	users := ParsePPPLog(logs)
+	if users[1234] != "alice" || users[1300] != "bob" || len(users) != 2 {
+		t.Errorf("users = %v", users)
+	}
+}
+
+func TestParseIPsec(t *testing.T) {
+	out := `Status of IKE charon daemon (strongSwan 5.9.8, Linux 6.1.0, x86_64):
+Security Associations (2 up, 0 connecting):
+      IKEv2-EAP[2]: ESTABLISHED 3 minutes ago, 203.0.113.1[vpn.example.com]...198.51.100.2[192.168.1.5]
+      IKEv2-EAP[2]: Remote EAP identity: carol
+      IKEv2-EAP[2]: IKEv2 SPIs: 1a2b3c4d5e6f7a8b_i 8b7a6f5e4d3c2b1a_r*, rekeying in 2 hours
+      IKEv2-EAP{1}:  INSTALLED, TUNNEL, reqid 1, ESP in UDP SPIs: c1234567_i c7654321_o
+      IKEv2-EAP{1}:  AES_GCM_16_256, 12345 bytes_i (10 pkts, 2s ago), 6789 bytes_o (8 pkts, 2s ago), rekeying in 40 minutes
+      IKEv2-EAP{1}:   0.0.0.0/0 === 10.10.20.1/32
+      L2TP-PSK[1]: ESTABLISHED 10 minutes ago, 203.0.113.1[203.0.113.1]...198.51.100.3[192.168.0.3]
+      L2TP-PSK{2}:  INSTALLED, TRANSPORT, reqid 2, ESP in UDP SPIs: c2222222_i c3333333_o
+      L2TP-PSK{2}:   203.0.113.1/32[udp/l2f] === 198.51.100.3/32[udp/l2f]
+`
+	now := time.Unix(1700000000, 0)
+	sessions := ParseIPsec(out, now)
+	if len(sessions) != 1 {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	want := Session{Protocol: IKEv2, User: "carol", IP: "10.10.20.1", Remote: "198.51.100.2", Since: now.Add(-3 * time.Minute), RX: 12345, TX: 6789, SA: "IKEv2-EAP[2]"}
+	if sessions[0] != want {
+		t.Errorf("会话 = %+v\n期望 %+v", sessions[0], want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var buf bytes.Buffer
+	Write(&buf, []Session{
+		{Interface: "ppp0", User: "alice", Protocol: L2TP, IP: "10.10.10.11", Remote: "198.51.100.3", Since: now.Add(-26*time.Hour - 5*time.Minute), RX: 5242880, TX: 1536},
+		{Protocol: IKEv2, User: "carol", IP: "10.10.20.1", Since: now.Add(-90 * time.Second), RX: 100},
+	}, now)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("输出:\n%s", buf.String())
+	}
+	for _, want := range []string{"alice", "ppp0", "1d2h5m", "5.0 MiB", "1.5 KiB"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("缺少 %q: %s", want, lines[1])
+		}
+	}
+	for _, want := range []string{"carol", "1m30s", "100 B", "-"} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("缺少 %q: %s", want, lines[2])
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package split
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"l2tp/internal/render"
+)
+
+// DNSServiceName 按域名填充集合的 dnsmasq 服务，配置写入 DNSConfigPath
+const (
+	DNSServiceName = "l2tp-dns"
+	DNSConfigPath  = "/etc/l2tp/dnsmasq.conf"
+	DNSUnitPath    = "/etc/systemd/system/" + DNSServiceName + ".service"
+)
+
+// DNS dnsmasq 参数。客户端的 DNS 请求被重定向到 Port，代理域名通过 ProxyServer 解析，
+// 其余域名通过 Server 解析，解析结果写入 nftables 表 Table 中的 BypassSet 或 ProxySet。
+// dnsmasq 运行在本机，查询不经过透明代理，ProxyServer 须为代理在本机监听的 DNS 入站，例如 127.0.0.1#5336，
+// 否则被污染的结果会写入代理集合
+type DNS struct {
+	Binary      string
+	Port        string
+	Server      string
+	ProxyServer string
+	// Table nft 命令中的 "<family> <name>" 形式
+	Table     string
+	BypassSet string
+	ProxySet  string
+}
+
+// DNSFiles 生成 dnsmasq 配置与服务。dnsmasq 需要 2.87 以上版本并启用 nftset 编译选项
+func DNSFiles(c *Compiled, d DNS) ([]render.File, error) {
+	family, table, ok := strings.Cut(d.Table, " ")
+	if !ok || d.Port == "" || d.Server == "" || d.ProxyServer == "" {
+		return nil, fmt.Errorf("dnsmasq 参数不完整: %+v", d)
+	}
+	host, _, _ := strings.Cut(d.ProxyServer, "#")
+	if addr, err := netip.ParseAddr(host); err != nil || !addr.IsLoopback() {
+		return nil, fmt.Errorf("代理域名应通过本机代理的 DNS 入站解析 (例如 127.0.0.1#5336)，当前为 %q", d.ProxyServer)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `# 由 l2tp 生成，请勿手动修改
+# 只为 PPP 客户端服务，不影响本机的 DNS 设置
+port=%s
+interface=ppp*
+bind-dynamic
+no-resolv
+no-hosts
+cache-size=10000
+server=%s
+`, d.Port, d.Server)
+	if len(c.BypassDomains) > 0 {
+		sb.WriteString("\n# 直连域名\n")
+		for _, domain := range c.BypassDomains {
+			fmt.Fprintf(&sb, "nftset=/%s/4#%s#%s#%s\n", domain, family, table, d.BypassSet)
+		}
+	}
+	if len(c.ProxyDomains) > 0 {
+		sb.WriteString("\n# 代理域名\n")
+		for _, domain := range c.ProxyDomains {
+			fmt.Fprintf(&sb, "server=/%s/%s\n", domain, d.ProxyServer)
+			fmt.Fprintf(&sb, "nftset=/%s/4#%s#%s#%s\n", domain, family, table, d.ProxySet)
+		}
+	}
+
+	unit := fmt.Sprintf(`[Unit]
+Description=L2TP 分流 DNS (dnsmasq)
+After=network-online.target l2tp-tproxy.service
+Wants=network-online.target
+
+[Service]
+ExecStart=%s --keep-in-foreground --pid-file= --conf-file=%s
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, d.Binary, DNSConfigPath)
+
+	return []render.File{
+		{Path: DNSConfigPath, Content: []byte(sb.String()), Mode: 0644},
+		{Path: DNSUnitPath, Content: []byte(unit), Mode: 0644},
+	}, nil
+}
@@ -0,0 +1,254 @@
+// Package split 将透明代理的分流列表 (CIDR、CIDR 文件、GeoIP 国家代码与域名列表) 编译为
+// nftables 集合元素，域名由 dnsmasq 在解析时写入集合
+package split
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// List 一组目的地址，各来源合并后去重
+type List struct {
+	CIDRs     []string
+	CIDRFiles []string
+	// GeoIP 国家代码，例如 CN，从 Lists.GeoIPDB 中查询
+	GeoIP       []string
+	Domains     []string
+	DomainFiles []string
+}
+
+// Empty 是否未配置任何来源
+func (l List) Empty() bool {
+	return len(l.CIDRs)+len(l.CIDRFiles)+len(l.GeoIP)+len(l.Domains)+len(l.DomainFiles) == 0
+}
+
+// Lists 分流列表。Proxy 优先于 Bypass，两者都不匹配的流量经过代理
+type Lists struct {
+	// GeoIPDB MaxMind 格式的国家数据库，例如 GeoLite2-Country.mmdb
+	GeoIPDB string
+	Bypass  List
+	Proxy   List
+}
+
+// Compiled 编译结果，网段只保留 IPv4 (透明代理只处理 IPv4)
+type Compiled struct {
+	Bypass        []string
+	Proxy         []string
+	BypassDomains []string
+	ProxyDomains  []string
+}
+
+// Domains 是否需要 dnsmasq 按域名填充集合
+func (c *Compiled) Domains() bool {
+	return len(c.BypassDomains)+len(c.ProxyDomains) > 0
+}
+
+// Compile 读取全部来源
+func Compile(l Lists) (*Compiled, error) {
+	bypass, bypassDomains, err := l.compile(l.Bypass)
+	if err != nil {
+		return nil, err
+	}
+	proxy, proxyDomains, err := l.compile(l.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	return &Compiled{Bypass: bypass, Proxy: proxy, BypassDomains: bypassDomains, ProxyDomains: proxyDomains}, nil
+}
+
+func (l Lists) compile(list List) (cidrs, domains []string, err error) {
+	for _, s := range list.CIDRs {
+		prefix, err := parsePrefix(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if prefix.Addr().Is4() {
+			cidrs = append(cidrs, prefix.String())
+		}
+	}
+	for _, path := range list.CIDRFiles {
+		found, err := ReadCIDRs(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		cidrs = append(cidrs, found...)
+	}
+	if len(list.GeoIP) > 0 {
+		if l.GeoIPDB == "" {
+			return nil, nil, fmt.Errorf("使用 GeoIP 需要指定数据库文件")
+		}
+		found, err := GeoIP(l.GeoIPDB, list.GeoIP)
+		if err != nil {
+			return nil, nil, err
+		}
+		cidrs = append(cidrs, found...)
+	}
+
+	for _, d := range list.Domains {
+		domain, ok := parseDomain(d)
+		if !ok {
+			return nil, nil, fmt.Errorf("域名 %q 无效", d)
+		}
+		domains = append(domains, domain)
+	}
+	for _, path := range list.DomainFiles {
+		found, err := ReadDomains(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		domains = append(domains, found...)
+	}
+	slices.Sort(domains)
+	return dedup(cidrs), slices.Compact(domains), nil
+}
+
+// ReadCIDRs 读取每行一个网段或地址的文件，# 之后为注释，IPv6 网段被忽略
+func ReadCIDRs(path string) ([]string, error) {
+	var cidrs []string
+	err := readLines(path, func(n int, line string) error {
+		prefix, err := parsePrefix(line)
+		if err != nil {
+			return fmt.Errorf("%s 第 %d 行: %v", path, n, err)
+		}
+		if prefix.Addr().Is4() {
+			cidrs = append(cidrs, prefix.String())
+		}
+		return nil
+	})
+	return cidrs, err
+}
+
+// ReadDomains 读取每行一个域名的文件，同时兼容 dnsmasq-china-list 的 server=/example.com/114.114.114.114
+// 与 v2ray 规则的 domain:example.com、full:example.com 写法，无法识别的行跳过
+func ReadDomains(path string) ([]string, error) {
+	var domains []string
+	err := readLines(path, func(_ int, line string) error {
+		if rest, ok := strings.CutPrefix(line, "server=/"); ok {
+			line, _, _ = strings.Cut(rest, "/")
+		}
+		for _, prefix := range []string{"domain:", "full:"} {
+			line = strings.TrimPrefix(line, prefix)
+		}
+		if domain, ok := parseDomain(line); ok {
+			domains = append(domains, domain)
+		}
+		return nil
+	})
+	return domains, err
+}
+
+// readLines 按行读取，去掉注释与首尾空白后跳过空行
+func readLines(path string, fn func(n int, line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("读取分流列表失败: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// GeoIP 返回数据库中属于 countries 的全部 IPv4 网段
+func GeoIP(db string, countries []string) ([]string, error) {
+	reader, err := maxminddb.Open(db)
+	if err != nil {
+		return nil, fmt.Errorf("打开 GeoIP 数据库 %s 失败: %v", db, err)
+	}
+	defer reader.Close()
+
+	wanted := make(map[string]bool, len(countries))
+	for _, c := range countries {
+		wanted[strings.ToUpper(c)] = true
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	var cidrs []string
+	ipv4 := &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	networks := reader.NetworksWithin(ipv4, maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		record.Country.ISOCode = ""
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, fmt.Errorf("读取 GeoIP 数据库失败: %v", err)
+		}
+		if wanted[record.Country.ISOCode] {
+			cidrs = append(cidrs, network.String())
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return nil, fmt.Errorf("读取 GeoIP 数据库失败: %v", err)
+	}
+	if len(cidrs) == 0 {
+		return nil, fmt.Errorf("GeoIP 数据库中没有 %s 的 IPv4 网段", strings.Join(countries, ", "))
+	}
+	return cidrs, nil
+}
+
+// parsePrefix 解析网段，单个地址视为 /32 或 /128
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("网段 %q 无效", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// parseDomain 校验域名并转换为小写，去掉开头的 . 与结尾的 .
+func parseDomain(s string) (string, bool) {
+	s = strings.Trim(strings.ToLower(strings.TrimSpace(s)), ".")
+	if s == "" || len(s) > 253 || strings.Trim(s, "abcdefghijklmnopqrstuvwxyz0123456789-.") != "" {
+		return "", false
+	}
+	for label := range strings.SplitSeq(s, ".") {
+		if label == "" || len(label) > 63 {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// dedup 去掉重复与被其他网段包含的网段，结果按地址排序
+func dedup(cidrs []string) []string {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		prefixes = append(prefixes, netip.MustParsePrefix(c))
+	}
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	var result []string
+	var last netip.Prefix
+	for _, p := range prefixes {
+		if last.IsValid() && last.Contains(p.Addr()) {
+			continue
+		}
+		last = p
+		result = append(result, p.String())
+	}
+	return result
+}
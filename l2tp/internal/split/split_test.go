@@ -0,0 +1,218 @@
+package split
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "用当前生成结果覆盖 testdata 下的 golden 文件")
+
+// writeMMDB 生成只含 IPv4 国家代码的最小 MaxMind 数据库，记录长度 24 位
+func writeMMDB(t *testing.T, countries map[string]string) string {
+	t.Helper()
+	const empty = -1
+	// 节点的两条记录: >=0 为子节点，empty 为无数据，<=-2 为第 -(v+2) 条数据
+	nodes := [][2]int{{empty, empty}}
+	var data [][]byte
+	for cidr, country := range countries {
+		prefix := netip.MustParsePrefix(cidr)
+		ip := prefix.Addr().As4()
+		n := 0
+		for i := range prefix.Bits() {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == prefix.Bits()-1 {
+				nodes[n][bit] = -(len(data) + 2)
+				break
+			}
+			if nodes[n][bit] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[n][bit] = len(nodes) - 1
+			}
+			n = nodes[n][bit]
+		}
+		// {"country": {"iso_code": country}}
+		record := append([]byte{0xe1, 0x47}, "country"...)
+		record = append(append(record, 0xe1, 0x48), "iso_code"...)
+		record = append(append(record, 0x40|byte(len(country))), country...)
+		data = append(data, record)
+	}
+
+	var section []byte
+	offsets := make([]int, len(data))
+	for i, d := range data {
+		offsets[i] = len(section)
+		section = append(section, d...)
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		for _, v := range n {
+			value := v
+			switch {
+			case v == empty:
+				value = len(nodes)
+			case v < 0:
+				value = len(nodes) + 16 + offsets[-v-2]
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(section)
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	encodeUint := func(typ byte, v uint32) []byte {
+		b := binary.BigEndian.AppendUint32(nil, v)
+		b = bytes.TrimLeft(b, "\x00")
+		return append([]byte{typ<<5 | byte(len(b))}, b...)
+	}
+	meta := map[string][]byte{
+		"node_count":                  encodeUint(6, uint32(len(nodes))),
+		"record_size":                 encodeUint(5, 24),
+		"ip_version":                  encodeUint(5, 4),
+		"binary_format_major_version": encodeUint(5, 2),
+		"binary_format_minor_version": encodeUint(5, 0),
+		"database_type":               append([]byte{0x44}, "Test"...),
+	}
+	buf.WriteByte(0xe0 | byte(len(meta)))
+	for key, value := range meta {
+		buf.WriteByte(0x40 | byte(len(key)))
+		buf.WriteString(key)
+		buf.Write(value)
+	}
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGeoIP(t *testing.T) {
+	db := writeMMDB(t, map[string]string{
+		"1.0.1.0/24": "CN",
+		"1.0.2.0/23": "CN",
+		"8.8.8.0/24": "US",
+	})
+	got, err := GeoIP(db, []string{"cn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.1.0/24", "1.0.2.0/23"}; !slices.Equal(got, want) {
+		t.Errorf("GeoIP = %v，期望 %v", got, want)
+	}
+	if _, err := GeoIP(db, []string{"JP"}); err == nil {
+		t.Error("国家代码不存在时应返回错误")
+	}
+	if _, err := GeoIP(filepath.Join(t.TempDir(), "missing.mmdb"), []string{"CN"}); err == nil {
+		t.Error("数据库不存在时应返回错误")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	cidrs := writeFile(t, "# 注释\n1.2.3.0/24\n1.2.3.4 # 已包含在上一行\n2001:db8::/32\n\n114.114.114.114\n")
+	domains := writeFile(t, "server=/baidu.com/114.114.114.114\ndomain:QQ.com\nfull:www.taobao.com\nregexp:^ad\\.\n")
+	got, err := Compile(Lists{
+		GeoIPDB: writeMMDB(t, map[string]string{"1.0.1.0/24": "CN"}),
+		Bypass: List{
+			CIDRs:       []string{"10.0.0.0/8", "10.1.0.0/16"},
+			CIDRFiles:   []string{cidrs},
+			GeoIP:       []string{"CN"},
+			DomainFiles: []string{domains},
+		},
+		Proxy: List{Domains: []string{"google.com", ".youtube.com", "google.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Compiled{
+		Bypass:        []string{"1.0.1.0/24", "1.2.3.0/24", "10.0.0.0/8", "114.114.114.114/32"},
+		BypassDomains: []string{"baidu.com", "qq.com", "www.taobao.com"},
+		ProxyDomains:  []string{"google.com", "youtube.com"},
+	}
+	if !slices.Equal(got.Bypass, want.Bypass) || !slices.Equal(got.Proxy, want.Proxy) ||
+		!slices.Equal(got.BypassDomains, want.BypassDomains) || !slices.Equal(got.ProxyDomains, want.ProxyDomains) {
+		t.Errorf("Compile =\n%+v\n期望\n%+v", got, want)
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for name, lists := range map[string]Lists{
+		"网段无效":        {Bypass: List{CIDRs: []string{"10.0.0.0/33"}}},
+		"文件不存在":       {Bypass: List{CIDRFiles: []string{"/nonexistent/cn.txt"}}},
+		"文件内容无效":      {Proxy: List{CIDRFiles: []string{writeFile(t, "1.2.3.0/24\nflush ruleset\n")}}},
+		"GeoIP 缺少数据库": {Bypass: List{GeoIP: []string{"CN"}}},
+		"域名无效":        {Proxy: List{Domains: []string{"google.com/#ip#filter#input"}}},
+	} {
+		if _, err := Compile(lists); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+}
+
+func TestDNSFilesGolden(t *testing.T) {
+	files, err := DNSFiles(&Compiled{
+		BypassDomains: []string{"baidu.com", "qq.com"},
+		ProxyDomains:  []string{"google.com"},
+	}, DNS{
+		Binary: "/usr/sbin/dnsmasq", Port: "5335", Server: "223.5.5.5", ProxyServer: "127.0.0.1#5336",
+		Table: "ip l2tp_tproxy", BypassSet: "bypass", ProxySet: "proxy",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		golden := filepath.Join("testdata", filepath.Base(f.Path)+".golden")
+		if *update {
+			if err := os.WriteFile(golden, f.Content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("读取 %s 失败: %v (使用 -update 生成)", golden, err)
+		}
+		if string(want) != string(f.Content) {
+			t.Errorf("与 %s 不一致\n--- 期望 ---\n%s\n--- 实际 ---\n%s", golden, want, f.Content)
+		}
+	}
+}
+
+func TestDNSProxyServer(t *testing.T) {
+	c := &Compiled{ProxyDomains: []string{"google.com"}}
+	d := DNS{
+		Binary: "/usr/sbin/dnsmasq", Port: "5335", Server: "223.5.5.5", ProxyServer: "127.0.0.1#5336",
+		Table: "ip l2tp_tproxy", BypassSet: "bypass", ProxySet: "proxy",
+	}
+	files, err := DNSFiles(c, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 代理域名必须经本机代理的 DNS 入站解析，不能由 dnsmasq 直接明文查询境外 DNS
+	if conf := string(files[0].Content); !strings.Contains(conf, "server=/google.com/127.0.0.1#5336\n") {
+		t.Errorf("代理域名的 server 行不正确:\n%s", conf)
+	}
+
+	for _, server := range []string{"1.1.1.1", "8.8.8.8#53", "localhost"} {
+		d.ProxyServer = server
+		if _, err := DNSFiles(c, d); err == nil {
+			t.Errorf("ProxyServer %q 不是本机地址，应返回错误", server)
+		}
+	}
+}
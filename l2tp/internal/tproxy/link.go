@@ -0,0 +1,325 @@
+package tproxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 出站协议
+const (
+	SOCKS       = "socks"
+	HTTP        = "http"
+	Shadowsocks = "shadowsocks"
+	VMess       = "vmess"
+	VLESS       = "vless"
+	Trojan      = "trojan"
+	Hysteria2   = "hysteria2"
+	AnyTLS      = "anytls"
+)
+
+// Outbound 上游代理，由分享链接解析得到
+type Outbound struct {
+	Type   string
+	Name   string
+	Server string
+	Port   int
+	// Username 与 Password 用于 socks、http；Password 同时用作 shadowsocks、trojan、hysteria2、anytls 的密码
+	Username string
+	Password string
+	// UUID、AlterID、Security 用于 vmess 与 vless，Security 为 vmess 的加密方式
+	UUID     string
+	AlterID  int
+	Security string
+	Flow     string
+	// Method shadowsocks 加密方式
+	Method string
+	// Obfs 与 ObfsPassword 为 hysteria2 的混淆参数
+	Obfs         string
+	ObfsPassword string
+	TLS          *TLS
+	Transport    *Transport
+}
+
+// TLS 出站 TLS 参数，RealityPublicKey 非空时使用 REALITY
+type TLS struct {
+	ServerName       string
+	Insecure         bool
+	ALPN             []string
+	Fingerprint      string
+	RealityPublicKey string
+	RealityShortID   string
+}
+
+// Transport 传输层: ws、grpc、http、httpupgrade，tcp 时为 nil
+type Transport struct {
+	Type        string
+	Host        string
+	Path        string
+	ServiceName string
+}
+
+// ParseLink 解析分享链接: socks5://、http(s)://、ss://、vmess://、vless://、trojan://、hysteria2:// (hy2://)、anytls://。
+// 不带协议的 host:port 视为无认证的 SOCKS5
+func ParseLink(link string) (*Outbound, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		if _, _, err := net.SplitHostPort(link); err != nil {
+			return nil, fmt.Errorf("无法识别的上游代理 %q，应为分享链接或 host:port", link)
+		}
+		link, scheme = "socks5://"+link, "socks5"
+	}
+
+	var (
+		o   *Outbound
+		err error
+	)
+	switch strings.ToLower(scheme) {
+	case "socks", "socks5", "socks5h":
+		o, err = parseSOCKS(link)
+	case "http", "https":
+		o, err = parseHTTP(link)
+	case "ss":
+		o, err = parseShadowsocks(link)
+	case "vmess":
+		o, err = parseVMess(link)
+	case "vless", "trojan", "hysteria2", "hy2", "anytls":
+		o, err = parseStandard(link)
+	default:
+		return nil, fmt.Errorf("不支持的代理协议 %q", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if o.Server == "" || o.Port <= 0 || o.Port > 65535 {
+		return nil, fmt.Errorf("分享链接缺少服务器地址或端口")
+	}
+	return o, nil
+}
+
+// parseURL 解析标准 URL 形式的链接，并取出服务器与端口
+func parseURL(link string) (*url.URL, *Outbound, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, fmt.Errorf("分享链接格式错误: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return nil, nil, fmt.Errorf("分享链接端口无效: %q", u.Port())
+	}
+	return u, &Outbound{Name: u.Fragment, Server: u.Hostname(), Port: port}, nil
+}
+
+func parseSOCKS(link string) (*Outbound, error) {
+	u, o, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	o.Type = SOCKS
+	o.Username, o.Password = userInfo(u)
+	return o, nil
+}
+
+func parseHTTP(link string) (*Outbound, error) {
+	u, o, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	o.Type = HTTP
+	o.Username, o.Password = userInfo(u)
+	if u.Scheme == "https" {
+		o.TLS = &TLS{ServerName: u.Hostname()}
+	}
+	return o, nil
+}
+
+// userInfo 读取用户名密码，v2rayN 导出的 socks 链接将 user:pass 整体 base64 编码
+func userInfo(u *url.URL) (string, string) {
+	if u.User == nil {
+		return "", ""
+	}
+	if pass, ok := u.User.Password(); ok {
+		return u.User.Username(), pass
+	}
+	if decoded, err := decodeBase64(u.User.Username()); err == nil {
+		if user, pass, ok := strings.Cut(decoded, ":"); ok {
+			return user, pass
+		}
+	}
+	return u.User.Username(), ""
+}
+
+// parseShadowsocks 支持 SIP002 (ss://base64(method:password)@host:port) 与旧格式 (ss://base64(method:password@host:port))
+func parseShadowsocks(link string) (*Outbound, error) {
+	body := strings.TrimPrefix(link, "ss://")
+	fragment := ""
+	if i := strings.Index(body, "#"); i >= 0 {
+		body, fragment = body[:i], body[i+1:]
+	}
+	if !strings.Contains(body, "@") {
+		decoded, err := decodeBase64(strings.SplitN(body, "?", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("shadowsocks 链接格式错误")
+		}
+		body = decoded
+	}
+	u, o, err := parseURL("ss://" + body)
+	if err != nil {
+		return nil, err
+	}
+	o.Type = Shadowsocks
+	o.Name, _ = url.PathUnescape(fragment)
+	if pass, ok := u.User.Password(); ok {
+		// SIP022 (2022-blake3-*) 的密钥不做 base64 编码
+		o.Method, o.Password = u.User.Username(), pass
+	} else if decoded, err := decodeBase64(u.User.Username()); err == nil {
+		o.Method, o.Password, _ = strings.Cut(decoded, ":")
+	}
+	if o.Method == "" || o.Password == "" {
+		return nil, fmt.Errorf("shadowsocks 链接缺少加密方式或密码")
+	}
+	return o, nil
+}
+
+// parseVMess 解析 v2rayN 格式: vmess://base64(JSON)
+func parseVMess(link string) (*Outbound, error) {
+	decoded, err := decodeBase64(strings.TrimPrefix(link, "vmess://"))
+	if err != nil {
+		return nil, fmt.Errorf("vmess 链接格式错误")
+	}
+	var v map[string]any
+	if err := json.Unmarshal([]byte(decoded), &v); err != nil {
+		return nil, fmt.Errorf("vmess 链接格式错误: %v", err)
+	}
+	str := func(key string) string {
+		switch value := v[key].(type) {
+		case string:
+			return value
+		case float64:
+			return strconv.Itoa(int(value))
+		}
+		return ""
+	}
+	port, _ := strconv.Atoi(str("port"))
+	alterID, _ := strconv.Atoi(str("aid"))
+	o := &Outbound{
+		Type:     VMess,
+		Name:     str("ps"),
+		Server:   str("add"),
+		Port:     port,
+		UUID:     str("id"),
+		AlterID:  alterID,
+		Security: str("scy"),
+	}
+	if o.Security == "" {
+		o.Security = "auto"
+	}
+	if str("tls") == "tls" {
+		o.TLS = &TLS{ServerName: str("sni"), Fingerprint: str("fp"), ALPN: splitList(str("alpn"))}
+		if o.TLS.ServerName == "" {
+			o.TLS.ServerName = str("host")
+		}
+	}
+	o.Transport = transport(str("net"), str("host"), str("path"), str("path"))
+	if o.UUID == "" {
+		return nil, fmt.Errorf("vmess 链接缺少 id")
+	}
+	return o, nil
+}
+
+// parseStandard 解析 vless、trojan、hysteria2、anytls 共用的 scheme://secret@host:port?params 格式
+func parseStandard(link string) (*Outbound, error) {
+	u, o, err := parseURL(link)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	secret := ""
+	if u.User != nil {
+		secret = u.User.Username()
+		if pass, ok := u.User.Password(); ok {
+			// hysteria2 允许 user:pass 形式的认证
+			secret += ":" + pass
+		}
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("%s 链接缺少认证信息", u.Scheme)
+	}
+
+	security := q.Get("security")
+	switch u.Scheme {
+	case "vless":
+		o.Type, o.UUID, o.Flow = VLESS, secret, q.Get("flow")
+	case "trojan":
+		o.Type, o.Password = Trojan, secret
+		if security == "" {
+			security = "tls"
+		}
+	case "hysteria2", "hy2":
+		o.Type, o.Password = Hysteria2, secret
+		o.Obfs, o.ObfsPassword = q.Get("obfs"), q.Get("obfs-password")
+		security = "tls"
+	case "anytls":
+		o.Type, o.Password = AnyTLS, secret
+		security = "tls"
+	}
+
+	if security == "tls" || security == "reality" {
+		o.TLS = &TLS{
+			ServerName:  q.Get("sni"),
+			Insecure:    q.Get("allowInsecure") == "1" || q.Get("insecure") == "1",
+			ALPN:        splitList(q.Get("alpn")),
+			Fingerprint: q.Get("fp"),
+		}
+		if security == "reality" {
+			o.TLS.RealityPublicKey, o.TLS.RealityShortID = q.Get("pbk"), q.Get("sid")
+			if o.TLS.RealityPublicKey == "" {
+				return nil, fmt.Errorf("reality 链接缺少 pbk")
+			}
+		}
+		if o.TLS.ServerName == "" && net.ParseIP(o.Server) == nil {
+			o.TLS.ServerName = o.Server
+		}
+	}
+	o.Transport = transport(q.Get("type"), q.Get("host"), q.Get("path"), q.Get("serviceName"))
+	return o, nil
+}
+
+// transport 按链接中的传输类型生成传输层参数，tcp 与未知类型返回 nil
+func transport(network, host, path, serviceName string) *Transport {
+	switch network {
+	case "ws", "httpupgrade":
+		return &Transport{Type: network, Host: host, Path: path}
+	case "grpc":
+		return &Transport{Type: network, ServiceName: serviceName}
+	case "h2", "http":
+		return &Transport{Type: "http", Host: host, Path: path}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// decodeBase64 兼容标准与 URL 安全编码，以及是否带填充
+func decodeBase64(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		s = unescaped
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return string(data), nil
+		}
+	}
+	return "", fmt.Errorf("base64 解码失败")
+}
@@ -0,0 +1,195 @@
+package tproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Versions 内核未安装时下载的固定版本，可通过 proxy.version 指定其他版本
+var Versions = map[string]string{
+	SingBox: "1.12.0",
+	Xray:    "25.8.3",
+}
+
+// InstallDir 下载的内核的安装目录，不与发行版软件包的文件冲突
+const InstallDir = "/usr/local/bin"
+
+// maxBinarySize 发布包中可执行文件的大小上限
+const maxBinarySize = 256 << 20
+
+var releaseRepos = map[string]string{
+	SingBox: "SagerNet/sing-box",
+	Xray:    "XTLS/Xray-core",
+}
+
+// releaseArchs GOARCH 到各内核发布包中架构名的映射
+var releaseArchs = map[string]map[string]string{
+	SingBox: {"amd64": "amd64", "arm64": "arm64", "386": "386"},
+	Xray:    {"amd64": "64", "arm64": "arm64-v8a", "386": "32"},
+}
+
+var (
+	versionPattern = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+(-[0-9A-Za-z.]+)?$`)
+	sha256Pattern  = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+)
+
+// ValidVersion 检查发布版本号，例如 1.12.0 或 v25.8.3
+func ValidVersion(version string) bool {
+	return versionPattern.MatchString(version)
+}
+
+// ValidSHA256 检查十六进制的 SHA-256
+func ValidSHA256(sum string) bool {
+	return sha256Pattern.MatchString(sum)
+}
+
+// Release 一个内核的 GitHub 发布包
+type Release struct {
+	Engine  string
+	Version string
+	// Asset 发布包文件名，URL 为其下载地址
+	Asset string
+	URL   string
+	// API 发布信息，其中包含各发布包的 SHA-256
+	API string
+	// Member 发布包中可执行文件的路径
+	Member string
+}
+
+// NewRelease 返回指定内核、版本与架构的发布包，version 为空时使用 Versions 中的固定版本
+func NewRelease(engine, version, goarch string) (*Release, error) {
+	repo, ok := releaseRepos[engine]
+	if !ok {
+		return nil, fmt.Errorf("未知的代理内核 %q，可选: %s, %s", engine, SingBox, Xray)
+	}
+	if version == "" {
+		version = Versions[engine]
+	}
+	if !ValidVersion(version) {
+		return nil, fmt.Errorf("%s 版本号无效: %q", engine, version)
+	}
+	version = strings.TrimPrefix(version, "v")
+	arch, ok := releaseArchs[engine][goarch]
+	if !ok {
+		return nil, fmt.Errorf("%s 没有 %s 架构的发布包", engine, goarch)
+	}
+
+	r := &Release{
+		Engine:  engine,
+		Version: version,
+		API:     fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/v%s", repo, version),
+	}
+	switch engine {
+	case SingBox:
+		dir := fmt.Sprintf("sing-box-%s-linux-%s", version, arch)
+		r.Asset = dir + ".tar.gz"
+		r.Member = dir + "/sing-box"
+	case Xray:
+		r.Asset = "Xray-linux-" + arch + ".zip"
+		r.Member = "xray"
+	}
+	r.URL = fmt.Sprintf("https://github.com/%s/releases/download/v%s/%s", repo, version, r.Asset)
+	return r, nil
+}
+
+// Path 安装后的可执行文件路径
+func (r *Release) Path() string {
+	return path.Join(InstallDir, path.Base(r.Member))
+}
+
+// Digest 从 GitHub 发布信息中取出发布包的 SHA-256，较早的发布没有记录摘要
+func (r *Release) Digest(info []byte) (string, error) {
+	var release struct {
+		Assets []struct {
+			Name   string `json:"name"`
+			Digest string `json:"digest"`
+		} `json:"assets"`
+	}
+	if err := json.Unmarshal(info, &release); err != nil {
+		return "", fmt.Errorf("解析 %s v%s 的发布信息失败: %v", r.Engine, r.Version, err)
+	}
+	for _, a := range release.Assets {
+		if a.Name != r.Asset {
+			continue
+		}
+		sum, ok := strings.CutPrefix(a.Digest, "sha256:")
+		if !ok || !ValidSHA256(sum) {
+			return "", fmt.Errorf("发布信息中没有 %s 的 SHA-256，请在 proxy.sha256 中指定", r.Asset)
+		}
+		return strings.ToLower(sum), nil
+	}
+	return "", fmt.Errorf("%s v%s 的发布信息中没有 %s", r.Engine, r.Version, r.Asset)
+}
+
+// Extract 校验发布包的 SHA-256 后取出其中的可执行文件
+func (r *Release) Extract(archive []byte, sum string) ([]byte, error) {
+	got := sha256.Sum256(archive)
+	if !strings.EqualFold(hex.EncodeToString(got[:]), sum) {
+		return nil, fmt.Errorf("%s 的 SHA-256 为 %x，与期望的 %s 不一致", r.Asset, got, sum)
+	}
+	if strings.HasSuffix(r.Asset, ".zip") {
+		return r.unzip(archive)
+	}
+	return r.untar(archive)
+}
+
+func (r *Release) untar(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("解压 %s 失败: %v", r.Asset, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解压 %s 失败: %v", r.Asset, err)
+		}
+		if h.Typeflag == tar.TypeReg && h.Name == r.Member {
+			return readBinary(tr, r.Member)
+		}
+	}
+	return nil, fmt.Errorf("%s 中没有 %s", r.Asset, r.Member)
+}
+
+func (r *Release) unzip(archive []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return nil, fmt.Errorf("解压 %s 失败: %v", r.Asset, err)
+	}
+	for _, f := range zr.File {
+		if f.Name != r.Member || f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("解压 %s 失败: %v", r.Asset, err)
+		}
+		defer rc.Close()
+		return readBinary(rc, r.Member)
+	}
+	return nil, fmt.Errorf("%s 中没有 %s", r.Asset, r.Member)
+}
+
+func readBinary(r io.Reader, name string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBinarySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %v", name, err)
+	}
+	if len(data) > maxBinarySize {
+		return nil, fmt.Errorf("%s 超过 %d MiB", name, maxBinarySize>>20)
+	}
+	return data, nil
+}
@@ -0,0 +1,125 @@
+package tproxy
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestNewRelease(t *testing.T) {
+	tests := []struct {
+		engine, version, arch string
+		want                  Release
+		path                  string
+	}{
+		{SingBox, "", "amd64", Release{
+			Engine: SingBox, Version: "1.12.0",
+			Asset:  "sing-box-1.12.0-linux-amd64.tar.gz",
+			URL:    "https://github.com/SagerNet/sing-box/releases/download/v1.12.0/sing-box-1.12.0-linux-amd64.tar.gz",
+			API:    "https://api.github.com/repos/SagerNet/sing-box/releases/tags/v1.12.0",
+			Member: "sing-box-1.12.0-linux-amd64/sing-box",
+		}, "/usr/local/bin/sing-box"},
+		{Xray, "v25.8.3", "arm64", Release{
+			Engine: Xray, Version: "25.8.3",
+			Asset:  "Xray-linux-arm64-v8a.zip",
+			URL:    "https://github.com/XTLS/Xray-core/releases/download/v25.8.3/Xray-linux-arm64-v8a.zip",
+			API:    "https://api.github.com/repos/XTLS/Xray-core/releases/tags/v25.8.3",
+			Member: "xray",
+		}, "/usr/local/bin/xray"},
+	}
+	for _, tt := range tests {
+		r, err := NewRelease(tt.engine, tt.version, tt.arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *r != tt.want {
+			t.Errorf("NewRelease(%s, %q, %s) = %+v，期望 %+v", tt.engine, tt.version, tt.arch, *r, tt.want)
+		}
+		if r.Path() != tt.path {
+			t.Errorf("安装路径 = %s，期望 %s", r.Path(), tt.path)
+		}
+	}
+
+	for _, args := range [][3]string{
+		{"clash", "", "amd64"},
+		{SingBox, "latest", "amd64"},
+		{SingBox, "1.12.0/../../x", "amd64"},
+		{Xray, "", "mips"},
+	} {
+		if _, err := NewRelease(args[0], args[1], args[2]); err == nil {
+			t.Errorf("NewRelease%q 应返回错误", args)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	r, _ := NewRelease(Xray, "", "amd64")
+	sum := strings.Repeat("ab", 32)
+	info := `{"tag_name":"v25.8.3","assets":[
+		{"name":"Xray-linux-32.zip","digest":"sha256:` + strings.Repeat("00", 32) + `"},
+		{"name":"Xray-linux-64.zip","digest":"sha256:` + strings.ToUpper(sum) + `"}]}`
+	got, err := r.Digest([]byte(info))
+	if err != nil || got != sum {
+		t.Errorf("Digest = %q, %v，期望 %q", got, err, sum)
+	}
+
+	for _, info := range []string{
+		`{"assets":[{"name":"Xray-linux-64.zip","digest":null}]}`,
+		`{"assets":[{"name":"Xray-linux-arm64-v8a.zip","digest":"sha256:` + sum + `"}]}`,
+		`not json`,
+	} {
+		if _, err := r.Digest([]byte(info)); err == nil {
+			t.Errorf("发布信息 %s 应返回错误", info)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	binary := []byte("\x7fELF binary")
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	for _, name := range []string{"sing-box-1.12.0-linux-amd64/LICENSE", "sing-box-1.12.0-linux-amd64/sing-box"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(binary)), Typeflag: tar.TypeReg})
+		tw.Write(binary)
+	}
+	tw.Close()
+	gz.Close()
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, name := range []string{"geoip.dat", "xray"} {
+		w, _ := zw.Create(name)
+		w.Write(binary)
+	}
+	zw.Close()
+
+	singBox, _ := NewRelease(SingBox, "", "amd64")
+	xray, _ := NewRelease(Xray, "", "amd64")
+	for _, tt := range []struct {
+		r       *Release
+		archive []byte
+	}{{singBox, tgz.Bytes()}, {xray, zipped.Bytes()}} {
+		sum := sha256.Sum256(tt.archive)
+		got, err := tt.r.Extract(tt.archive, hex.EncodeToString(sum[:]))
+		if err != nil || !bytes.Equal(got, binary) {
+			t.Errorf("%s: Extract = %q, %v", tt.r.Asset, got, err)
+		}
+		if _, err := tt.r.Extract(tt.archive, strings.Repeat("00", 32)); err == nil || !strings.Contains(err.Error(), "SHA-256") {
+			t.Errorf("%s: 摘要不一致时应拒绝，err = %v", tt.r.Asset, err)
+		}
+	}
+
+	// 版本不一致时发布包中没有对应的可执行文件
+	other, _ := NewRelease(SingBox, "1.11.0", "amd64")
+	sum := sha256.Sum256(tgz.Bytes())
+	if _, err := other.Extract(tgz.Bytes(), hex.EncodeToString(sum[:])); err == nil {
+		t.Error("发布包中没有可执行文件时应返回错误")
+	}
+}
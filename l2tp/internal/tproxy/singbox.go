@@ -0,0 +1,115 @@
+package tproxy
+
+import "fmt"
+
+// singBoxConfig 生成 sing-box 1.12+ 配置: DNS 请求被劫持后经代理查询，私有网段直连，其余流量走上游。
+// 本机 DNS 入站收到的查询同样由 DNS 模块经代理通过 DoH 解析
+func singBoxConfig(c Config) (object, error) {
+	proxy, err := singBoxOutbound(c.Outbound)
+	if err != nil {
+		return nil, err
+	}
+	inbounds := []object{
+		{"type": "tproxy", "tag": tagInbound, "listen": "0.0.0.0", "listen_port": c.Port},
+	}
+	var rules []object
+	if c.DNSPort > 0 {
+		inbounds = append(inbounds, object{"type": "direct", "tag": tagDNS, "listen": "127.0.0.1", "listen_port": c.DNSPort})
+		rules = append(rules, object{"inbound": tagDNS, "action": "hijack-dns"})
+	}
+	rules = append(rules,
+		object{"inbound": tagInbound, "action": "sniff"},
+		object{"protocol": "dns", "action": "hijack-dns"},
+		object{"ip_cidr": c.Private, "action": "route", "outbound": tagDirect},
+	)
+	return object{
+		"log": object{"level": "warn", "timestamp": true},
+		"dns": object{
+			"servers": []object{
+				{"type": "https", "tag": "dns-remote", "server": c.DNS, "detour": tagProxy},
+				{"type": "udp", "tag": "dns-direct", "server": c.DirectDNS},
+			},
+			"final": "dns-remote",
+		},
+		"inbounds":  inbounds,
+		"outbounds": []object{proxy, {"type": "direct", "tag": tagDirect}},
+		"route": object{
+			"rules":                   rules,
+			"final":                   tagProxy,
+			"default_domain_resolver": "dns-direct",
+		},
+	}, nil
+}
+
+func singBoxOutbound(o *Outbound) (object, error) {
+	out := object{"type": o.Type, "tag": tagProxy, "server": o.Server, "server_port": o.Port}
+	switch o.Type {
+	case SOCKS, HTTP:
+		if o.Type == SOCKS {
+			out["version"] = "5"
+		}
+		if o.Username != "" {
+			out["username"], out["password"] = o.Username, o.Password
+		}
+	case Shadowsocks:
+		out["method"], out["password"] = o.Method, o.Password
+	case VMess:
+		out["uuid"], out["security"], out["alter_id"] = o.UUID, o.Security, o.AlterID
+	case VLESS:
+		out["uuid"] = o.UUID
+		if o.Flow != "" {
+			out["flow"] = o.Flow
+		}
+	case Trojan, AnyTLS:
+		out["password"] = o.Password
+	case Hysteria2:
+		out["password"] = o.Password
+		if o.Obfs != "" {
+			out["obfs"] = object{"type": o.Obfs, "password": o.ObfsPassword}
+		}
+	default:
+		return nil, fmt.Errorf("sing-box 不支持出站协议 %q", o.Type)
+	}
+	if t := o.TLS; t != nil {
+		tls := object{"enabled": true, "server_name": t.ServerName}
+		if t.Insecure {
+			tls["insecure"] = true
+		}
+		if len(t.ALPN) > 0 {
+			tls["alpn"] = t.ALPN
+		}
+		fingerprint := t.Fingerprint
+		if t.RealityPublicKey != "" {
+			tls["reality"] = object{"enabled": true, "public_key": t.RealityPublicKey, "short_id": t.RealityShortID}
+			// REALITY 依赖 uTLS
+			if fingerprint == "" {
+				fingerprint = "chrome"
+			}
+		}
+		if fingerprint != "" {
+			tls["utls"] = object{"enabled": true, "fingerprint": fingerprint}
+		}
+		out["tls"] = tls
+	}
+	if t := o.Transport; t != nil {
+		transport := object{"type": t.Type}
+		switch t.Type {
+		case "ws":
+			transport["path"] = t.Path
+			if t.Host != "" {
+				transport["headers"] = object{"Host": t.Host}
+			}
+		case "httpupgrade":
+			transport["path"], transport["host"] = t.Path, t.Host
+		case "http":
+			transport["path"] = t.Path
+			if t.Host != "" {
+				transport["host"] = []string{t.Host}
+			}
+		case "grpc":
+			transport["service_name"] = t.ServiceName
+		}
+		out["transport"] = transport
+	}
+	return out, nil
+}
@@ -0,0 +1,150 @@
+// Package tproxy 生成 -out 透明代理使用的 sing-box 或 Xray 配置与 systemd 服务，
+// 入站监听 TPROXY 端口并开启嗅探，出站为分享链接或 SOCKS 指定的上游代理
+package tproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"text/template"
+
+	"l2tp/internal/render"
+)
+
+// 代理内核
+const (
+	SingBox = "sing-box"
+	Xray    = "xray"
+)
+
+// Engines 全部代理内核
+var Engines = []string{SingBox, Xray}
+
+// ServiceName 透明代理服务名，配置写入 ConfigPath
+const (
+	ServiceName = "l2tp-proxy"
+	ConfigPath  = "/etc/l2tp/proxy.json"
+	UnitPath    = "/etc/systemd/system/" + ServiceName + ".service"
+)
+
+// DefaultBinary 各内核官方安装脚本的安装位置
+var DefaultBinary = map[string]string{
+	SingBox: "/usr/bin/sing-box",
+	Xray:    "/usr/local/bin/xray",
+}
+
+// 出站与入站的标签
+const (
+	tagInbound = "tproxy-in"
+	tagDNS     = "dns-in"
+	tagProxy   = "proxy"
+	tagDirect  = "direct"
+)
+
+// Config 生成参数
+type Config struct {
+	Engine string
+	// Binary 内核可执行文件，为空时使用 DefaultBinary
+	Binary string
+	// Port TPROXY 监听端口，与防火墙规则的 tproxy 端口一致
+	Port int
+	// Private 直连的本地与保留网段。按国家、域名的分流在防火墙中完成，不需要的流量不会到达代理
+	Private []string
+	// DNS 经代理查询的上游 DNS，DirectDNS 用于解析代理服务器域名
+	DNS       string
+	DirectDNS string
+	// DNSPort 大于 0 时在 127.0.0.1 上监听该端口，供分流 dnsmasq 经上游代理解析代理域名
+	DNSPort  int
+	Outbound *Outbound
+}
+
+// object JSON 对象，键按字母顺序输出，生成结果稳定
+type object = map[string]any
+
+// Generate 生成代理配置与服务，配置中含上游密码，权限为 0600
+func Generate(c Config) ([]render.File, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	var (
+		conf object
+		err  error
+	)
+	switch c.Engine {
+	case SingBox:
+		conf, err = singBoxConfig(c)
+	case Xray:
+		conf, err = xrayConfig(c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	unit, err := serviceUnit(c)
+	if err != nil {
+		return nil, err
+	}
+	return []render.File{
+		{Path: ConfigPath, Content: append(data, '\n'), Mode: 0600},
+		{Path: UnitPath, Content: unit, Mode: 0644},
+	}, nil
+}
+
+func (c Config) validate() error {
+	if _, ok := DefaultBinary[c.Engine]; !ok {
+		return fmt.Errorf("未知的代理内核 %q，可选: %s, %s", c.Engine, SingBox, Xray)
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("透明代理端口无效: %d", c.Port)
+	}
+	if c.DNSPort < 0 || c.DNSPort > 65535 || c.DNSPort == c.Port {
+		return fmt.Errorf("DNS 入站端口无效: %d", c.DNSPort)
+	}
+	if c.Outbound == nil {
+		return fmt.Errorf("缺少上游代理")
+	}
+	for _, cidr := range c.Private {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("直连网段 %q 无效", cidr)
+		}
+	}
+	for _, addr := range []string{c.DNS, c.DirectDNS} {
+		if _, err := netip.ParseAddr(addr); err != nil {
+			return fmt.Errorf("DNS %q 应为 IP 地址", addr)
+		}
+	}
+	return nil
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=L2TP 透明代理 ({{.Engine}})
+After=network-online.target l2tp-tproxy.service
+Wants=network-online.target
+
+[Service]
+ExecStart={{.Binary}} run {{.Flag}} ` + ConfigPath + `
+Restart=on-failure
+RestartSec=5
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func serviceUnit(c Config) ([]byte, error) {
+	binary := c.Binary
+	if binary == "" {
+		binary = DefaultBinary[c.Engine]
+	}
+	flag := "-c"
+	if c.Engine == Xray {
+		flag = "-config"
+	}
+	var buf bytes.Buffer
+	err := unitTemplate.Execute(&buf, struct{ Engine, Binary, Flag string }{c.Engine, binary, flag})
+	return buf.Bytes(), err
+}
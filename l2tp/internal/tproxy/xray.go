@@ -0,0 +1,115 @@
+package tproxy
+
+import "fmt"
+
+// xrayConfig 生成 Xray 配置: dokodemo-door 以 tproxy 模式接收流量并嗅探域名，
+// 私有网段走 freedom，其余流量走第一个出站即上游代理。本机 DNS 入站将查询经上游代理转发到 DNS
+func xrayConfig(c Config) (object, error) {
+	proxy, err := xrayOutbound(c.Outbound)
+	if err != nil {
+		return nil, err
+	}
+	inbounds := []object{{
+		"tag":      tagInbound,
+		"port":     c.Port,
+		"protocol": "dokodemo-door",
+		"settings": object{"network": "tcp,udp", "followRedirect": true},
+		"sniffing": object{"enabled": true, "destOverride": []string{"http", "tls", "quic"}, "routeOnly": true},
+		"streamSettings": object{
+			"sockopt": object{"tproxy": "tproxy"},
+		},
+	}}
+	rules := []object{
+		{"type": "field", "ip": c.Private, "outboundTag": tagDirect},
+	}
+	if c.DNSPort > 0 {
+		inbounds = append(inbounds, object{
+			"tag":      tagDNS,
+			"listen":   "127.0.0.1",
+			"port":     c.DNSPort,
+			"protocol": "dokodemo-door",
+			"settings": object{"address": c.DNS, "port": 53, "network": "tcp,udp"},
+		})
+		rules = append([]object{{"type": "field", "inboundTag": []string{tagDNS}, "outboundTag": tagProxy}}, rules...)
+	}
+	return object{
+		"log":       object{"loglevel": "warning"},
+		"inbounds":  inbounds,
+		"outbounds": []object{proxy, {"tag": tagDirect, "protocol": "freedom"}},
+		"routing": object{
+			"domainStrategy": "AsIs",
+			"rules":          rules,
+		},
+	}, nil
+}
+
+func xrayOutbound(o *Outbound) (object, error) {
+	server := object{"address": o.Server, "port": o.Port}
+	var settings object
+	switch o.Type {
+	case SOCKS, HTTP:
+		if o.Username != "" {
+			server["users"] = []object{{"user": o.Username, "pass": o.Password}}
+		}
+		settings = object{"servers": []object{server}}
+	case Shadowsocks:
+		server["method"], server["password"] = o.Method, o.Password
+		settings = object{"servers": []object{server}}
+	case Trojan:
+		server["password"] = o.Password
+		settings = object{"servers": []object{server}}
+	case VMess:
+		server["users"] = []object{{"id": o.UUID, "alterId": o.AlterID, "security": o.Security}}
+		settings = object{"vnext": []object{server}}
+	case VLESS:
+		user := object{"id": o.UUID, "encryption": "none"}
+		if o.Flow != "" {
+			user["flow"] = o.Flow
+		}
+		server["users"] = []object{user}
+		settings = object{"vnext": []object{server}}
+	default:
+		return nil, fmt.Errorf("Xray 不支持出站协议 %q，请使用 sing-box", o.Type)
+	}
+
+	stream := object{"network": "tcp"}
+	if t := o.Transport; t != nil {
+		switch t.Type {
+		case "ws":
+			stream["wsSettings"] = object{"path": t.Path, "host": t.Host}
+		case "httpupgrade":
+			stream["httpupgradeSettings"] = object{"path": t.Path, "host": t.Host}
+		case "grpc":
+			stream["grpcSettings"] = object{"serviceName": t.ServiceName}
+		default:
+			return nil, fmt.Errorf("Xray 不支持传输方式 %q，请使用 sing-box", t.Type)
+		}
+		stream["network"] = t.Type
+	}
+	if t := o.TLS; t != nil {
+		if t.RealityPublicKey != "" {
+			fingerprint := t.Fingerprint
+			if fingerprint == "" {
+				fingerprint = "chrome"
+			}
+			stream["security"] = "reality"
+			stream["realitySettings"] = object{
+				"serverName":  t.ServerName,
+				"fingerprint": fingerprint,
+				"publicKey":   t.RealityPublicKey,
+				"shortId":     t.RealityShortID,
+			}
+		} else {
+			tls := object{"serverName": t.ServerName, "allowInsecure": t.Insecure}
+			if len(t.ALPN) > 0 {
+				tls["alpn"] = t.ALPN
+			}
+			if t.Fingerprint != "" {
+				tls["fingerprint"] = t.Fingerprint
+			}
+			stream["security"] = "tls"
+			stream["tlsSettings"] = tls
+		}
+	}
+	return object{"tag": tagProxy, "protocol": o.Type, "settings": settings, "streamSettings": stream}, nil
+}
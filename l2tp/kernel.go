@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"l2tp/internal/doctor"
+	"l2tp/internal/grub"
+	"l2tp/internal/osinfo"
+)
+
+const (
+	// kernelSwapPath 记录尚未确认的内核切换，finalize 或 revert 后删除
+	kernelSwapPath  = "/var/lib/l2tp/kernel-swap.json"
+	grubDefaultPath = "/etc/default/grub"
+	grubConfigPath  = "/boot/grub/grub.cfg"
+	grubEnvPath     = "/boot/grub/grubenv"
+	// minBootSpace 新内核与 initramfs 所需的 /boot 剩余空间
+	minBootSpace = 150 << 20
+)
+
+// grubSettings 合并到 /etc/default/grub 的键，其余配置 (串口控制台、云平台内核参数等) 保持不变。
+// GRUB_DEFAULT=saved 是 grub-reboot 与 grub-set-default 生效的前提
+var grubSettings = map[string]string{
+	"GRUB_DEFAULT":           "saved",
+	"GRUB_DISABLE_OS_PROBER": "true",
+}
+
+// kernelSwap 一次等待确认的内核切换
+type kernelSwap struct {
+	// Kernel 新安装的标准内核版本，Entry 为其 GRUB 启动项
+	Kernel string `json:"kernel"`
+	Entry  string `json:"entry"`
+	// Previous 切换前运行的内核，PreviousEntry 为其启动项，仍是默认启动项
+	Previous      string `json:"previous"`
+	PreviousEntry string `json:"previous_entry"`
+	// CloudPackages finalize 时删除的 Cloud 内核软件包
+	CloudPackages []string `json:"cloud_packages"`
+	// Snapshot 修改 GRUB 配置时的快照，revert 时恢复
+	Snapshot string    `json:"snapshot,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+func kernelUsage() {
+	fmt.Println(`用法: l2tp kernel <命令>
+
+切换到标准内核后，新内核只以一次性方式启动，确认可用之前 Cloud 内核仍是默认启动项。
+
+命令:
+  status                    查看等待确认的内核切换
+  finalize                  已在新内核上启动并确认 PPP 可用: 设为默认启动项并删除 Cloud 内核
+  revert                    放弃切换: 恢复 GRUB 配置，之后重启回到原内核`)
+}
+
+// runKernelCommand 处理 l2tp kernel 子命令
+func runKernelCommand(args []string) error {
+	if len(args) != 1 {
+		kernelUsage()
+		return fmt.Errorf("需要指定一个命令")
+	}
+	swap, err := loadKernelSwap()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "status":
+		running, _ := runCommandOutput("uname", "-r")
+		fmt.Printf("新内核    : %s (%s)\n", swap.Kernel, swap.Entry)
+		fmt.Printf("原内核    : %s (%s)\n", swap.Previous, swap.PreviousEntry)
+		fmt.Printf("当前运行  : %s\n", running)
+		fmt.Printf("开始于    : %s\n", swap.Created.Format("2006-01-02 15:04:05"))
+		if running == swap.Kernel {
+			fmt.Println("已在新内核上启动，确认 VPN 可用后运行 l2tp kernel finalize")
+		} else {
+			fmt.Println("尚未在新内核上启动，重启后 GRUB 将一次性进入新内核")
+		}
+		return nil
+	case "finalize":
+		return finalizeKernelSwap(swap)
+	case "revert":
+		return revertKernelSwap(swap)
+	}
+	kernelUsage()
+	return fmt.Errorf("未知命令: %s", args[0])
+}
+
+func loadKernelSwap() (*kernelSwap, error) {
+	data, err := os.ReadFile(kernelSwapPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("没有等待确认的内核切换")
+	}
+	if err != nil {
+		return nil, err
+	}
+	swap := &kernelSwap{}
+	if err := json.Unmarshal(data, swap); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", kernelSwapPath, err)
+	}
+	return swap, nil
+}
+
+// saveKernelSwap 不计入快照，finalize 或 revert 后删除，存在时不允许卸载
+func saveKernelSwap(swap *kernelSwap) error {
+	if dryRun {
+		return nil
+	}
+	data, err := json.MarshalIndent(swap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(kernelSwapPath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(kernelSwapPath, data, 0600)
+}
+
+// cloudKernelPackages 已安装的 Cloud 内核软件包
+func cloudKernelPackages() []string {
+	dpkgOut, _ := runCommandOutput("bash", "-c", "dpkg -l | awk '/linux-(image|headers)-[0-9].*cloud/ {print $2}'")
+	return strings.Fields(dpkgOut)
+}
+
+// checkBootSpace 检查 /boot 所在分区的剩余空间
+func checkBootSpace() error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs("/boot", &st); err != nil {
+		return fmt.Errorf("无法读取 /boot 剩余空间: %v", err)
+	}
+	free := st.Bavail * uint64(st.Bsize)
+	if free < minBootSpace {
+		return fmt.Errorf("/boot 剩余空间 %d MiB，安装新内核至少需要 %d MiB，请先清理旧内核 (apt autoremove --purge)", free>>20, minBootSpace>>20)
+	}
+	fmt.Printf("%s ✓ /boot 剩余空间 %d MiB\n", Green, free>>20)
+	return nil
+}
+
+// installStandardKernel 安装发行版的标准内核并生成 initramfs，返回新内核版本
+func installStandardKernel(sys *osinfo.System) (string, error) {
+	imagePkg, headersPkg := sys.Package("linux-image"), sys.Package("linux-headers")
+
+	fmt.Printf("正在安装 %s %s ...\n", imagePkg, headersPkg)
+
+	if err := runCommandEnv([]string{"DEBIAN_FRONTEND=noninteractive"}, "apt", "install", "-y", "--reinstall", imagePkg, headersPkg); err != nil {
+		return "", fmt.Errorf("标准内核安装失败")
+	}
+	if dryRun {
+		return "", nil
+	}
+
+	cmdStr := `ls /boot/vmlinuz-* 2>/dev/null | grep -v cloud | sort -V | tail -1 | sed 's|/boot/vmlinuz-||'`
+	stdKernel, _ := runCommandOutput("bash", "-c", cmdStr)
+	if stdKernel == "" {
+		return "", fmt.Errorf("/boot 中没有找到标准内核")
+	}
+
+	// 软件包的安装脚本通常已经生成 initramfs，缺失时重新创建
+	fmt.Printf("更新 initramfs: %s\n", stdKernel)
+	mode := "-u"
+	if !fileExists("/boot/initrd.img-" + stdKernel) {
+		mode = "-c"
+	}
+	if err := runCommand("update-initramfs", mode, "-k", stdKernel); err != nil {
+		return "", fmt.Errorf("生成 %s 的 initramfs 失败: %v", stdKernel, err)
+	}
+
+	fmt.Printf("%s ✓ 标准内核安装完成: %s\n", Green, stdKernel)
+	return stdKernel, nil
+}
+
+// verifyKernel 确认新内核可以启动且提供 PPP: initramfs 存在、包含该内核的模块目录，并且内核带有 ppp_generic
+func verifyKernel(version string) error {
+	initrd := "/boot/initrd.img-" + version
+	info, err := os.Stat(initrd)
+	if err != nil || info.Size() < 1<<20 {
+		return fmt.Errorf("%s 不存在或不完整", initrd)
+	}
+	listing, err := runCommandOutput("lsinitramfs", initrd)
+	if err != nil {
+		return fmt.Errorf("无法读取 %s: %v", initrd, err)
+	}
+	if !strings.Contains(listing, "lib/modules/"+version+"/") {
+		return fmt.Errorf("%s 中没有 %s 的内核模块", initrd, version)
+	}
+
+	modDir := "/lib/modules/" + version
+	builtin, _ := os.ReadFile(filepath.Join(modDir, "modules.builtin"))
+	dep, _ := os.ReadFile(filepath.Join(modDir, "modules.dep"))
+	if doctor.StateOf(nil, builtin, doctor.ModuleFiles(dep), "ppp_generic") == doctor.ModuleMissing {
+		return fmt.Errorf("内核 %s 不包含 ppp_generic 模块", version)
+	}
+	fmt.Printf("%s ✓ initramfs 与 PPP 模块检查通过\n", Green)
+	return nil
+}
+
+// configureGrub 合并 /etc/default/grub 并用 grub-mkconfig 生成新的 grub.cfg，
+// 确认其中有新旧两个内核的启动项且支持 saved_entry 后才替换 /boot/grub/grub.cfg
+func configureGrub(kernel, previous string) (entry, previousEntry string, err error) {
+	original, err := os.ReadFile(grubDefaultPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
+	}
+	if err := writeFile(grubDefaultPath, grub.Merge(original, grubSettings), 0644); err != nil {
+		return "", "", err
+	}
+
+	tmp := grubConfigPath + ".l2tp-new"
+	if err := runCommand("grub-mkconfig", "-o", tmp); err != nil {
+		return "", "", fmt.Errorf("grub-mkconfig 失败: %v", err)
+	}
+	if dryRun {
+		return "<新内核启动项>", "<原内核启动项>", nil
+	}
+	defer os.Remove(tmp)
+	cfg, err := os.ReadFile(tmp)
+	if err != nil {
+		return "", "", err
+	}
+	if !grub.SavedDefault(cfg) {
+		return "", "", fmt.Errorf("生成的 grub.cfg 不支持 saved_entry，请检查 /etc/default/grub.d 中是否覆盖了 GRUB_DEFAULT")
+	}
+	entries := grub.Entries(cfg)
+	newEntry, err := grub.FindKernel(entries, kernel)
+	if err != nil {
+		return "", "", err
+	}
+	oldEntry, err := grub.FindKernel(entries, previous)
+	if err != nil {
+		return "", "", fmt.Errorf("%v，当前内核可能不是由 GRUB 引导的，切换内核不会生效", err)
+	}
+	if err := writeFile(grubConfigPath, cfg, 0444); err != nil {
+		return "", "", err
+	}
+	fmt.Printf("%s ✓ GRUB 配置已更新，新内核启动项: %s\n", Green, newEntry.Title)
+	return newEntry.Path, oldEntry.Path, nil
+}
+
+func performKernelSwap() error {
+	sys, err := detectOS()
+	if err != nil {
+		return err
+	}
+	if sys.Manager() != "apt" {
+		return fmt.Errorf("内核切换功能仅支持 Debian/Ubuntu 及其衍生版 (当前检测为: %s)", sys)
+	}
+	if fileExists(kernelSwapPath) {
+		return fmt.Errorf("已有等待确认的内核切换，运行 l2tp kernel status 查看，l2tp kernel finalize 或 revert 处理后再继续")
+	}
+
+	fmt.Printf("\n%s⚠️  高危操作警告 ⚠️%s\n", Red, Nc)
+	fmt.Println("将安装标准内核并设置下次启动时一次性进入新内核，确认可用后才会删除 Cloud 内核。")
+	fmt.Println("云服务器无法进入新内核时，强制重启即可回到原内核。请务必提前备份重要数据")
+	if !askReboot("确认继续？") {
+		return fmt.Errorf("操作已取消")
+	}
+
+	// 确保基础工具存在
+	runCommand("apt-get", "update", "-qq")
+	runCommand("apt-get", "install", "-y", "-qq", "curl", "ca-certificates")
+
+	changeMirrors()
+
+	fmt.Printf("%s [1/4] 检查 /boot 空间\n", Yellow)
+	if err := checkBootSpace(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s [2/4] 安装标准内核\n", Yellow)
+	kernel, err := installStandardKernel(sys)
+	if err != nil {
+		return err
+	}
+	previous, _ := runCommandOutput("uname", "-r")
+	if !dryRun {
+		if kernel == previous {
+			return fmt.Errorf("当前已运行标准内核 %s，但仍缺少 PPP 模块，请安装 linux-modules-extra 或运行 l2tp doctor 检查", kernel)
+		}
+		if err := verifyKernel(kernel); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s [3/4] 配置 GRUB\n", Yellow)
+	swap := &kernelSwap{Kernel: kernel, Previous: previous, Created: time.Now()}
+	swap.CloudPackages = cloudKernelPackages()
+	if err := withTransaction(func() error {
+		if currentTxn != nil {
+			swap.Snapshot = currentTxn.snap.ID
+		}
+		swap.Entry, swap.PreviousEntry, err = configureGrub(kernel, previous)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	// 默认启动项仍为原内核，新内核只启动一次；新内核无法启动时重启即回到原内核
+	fmt.Printf("%s [4/4] 设置一次性启动\n", Yellow)
+	if err := runCommand("grub-set-default", swap.PreviousEntry); err != nil {
+		return fmt.Errorf("grub-set-default 失败: %v", err)
+	}
+	if err := runCommand("grub-reboot", swap.Entry); err != nil {
+		return fmt.Errorf("grub-reboot 失败: %v", err)
+	}
+	if err := saveKernelSwap(swap); err != nil {
+		return fmt.Errorf("保存切换状态失败: %v", err)
+	}
+
+	finishDryRun()
+
+	fmt.Printf("\n%s标准内核 %s 已就绪，下次重启将一次性进入新内核。%s\n", Green, kernel, Nc)
+	fmt.Println("重启后:")
+	fmt.Println("  l2tp kernel finalize   确认新内核可用，设为默认并删除 Cloud 内核，然后重新运行 l2tp 安装 VPN")
+	fmt.Println("  l2tp kernel revert     放弃切换，恢复 GRUB 配置")
+	if askReboot("立即重启？") {
+		runCommand("reboot")
+		os.Exit(0)
+	}
+	fmt.Printf("%s 需要重启进入新内核后才能继续，请手动重启。%s\n", Tip, Nc)
+	os.Exit(1)
+	return nil
+}
+
+// finalizeKernelSwap 在新内核上确认 PPP 可用后将其设为默认启动项，再删除 Cloud 内核
+func finalizeKernelSwap(swap *kernelSwap) error {
+	running, _ := runCommandOutput("uname", "-r")
+	if running != swap.Kernel {
+		return fmt.Errorf("当前运行的内核为 %s，尚未进入新内核 %s；重启后再运行，或使用 l2tp kernel revert 放弃切换", running, swap.Kernel)
+	}
+	p := probePPP(doctor.PPPModules, []string{"ppp_generic"}, true)
+	switch fix, detail := p.Recommend(); fix {
+	case doctor.FixNone:
+	case doctor.FixDevice:
+		if err := runCommand("mknod", "-m", "600", "/dev/ppp", "c", "108", "0"); err != nil {
+			return fmt.Errorf("创建 /dev/ppp 失败: %v", err)
+		}
+	default:
+		return fmt.Errorf("新内核上 PPP 仍不可用: %s，建议使用 l2tp kernel revert 回到原内核", detail)
+	}
+
+	if err := runCommand("grub-set-default", swap.Entry); err != nil {
+		return fmt.Errorf("grub-set-default 失败: %v", err)
+	}
+	removeCloudKernels(swap.CloudPackages)
+	if err := runCommand("update-grub"); err != nil {
+		return fmt.Errorf("update-grub 失败: %v", err)
+	}
+	if !dryRun {
+		cfg, err := os.ReadFile(grubConfigPath)
+		if err != nil {
+			return err
+		}
+		if _, err := grub.FindKernel(grub.Entries(cfg), swap.Kernel); err != nil {
+			return fmt.Errorf("删除 Cloud 内核后 %v，请检查 GRUB 配置后再重启", err)
+		}
+	}
+	if err := removeFile(kernelSwapPath); err != nil {
+		return err
+	}
+	finishDryRun()
+	fmt.Printf("%s 内核切换完成，默认启动 %s，重新运行 l2tp 安装 VPN\n", Green, swap.Kernel)
+	return nil
+}
+
+// revertKernelSwap 恢复切换前的 GRUB 配置并清除一次性启动与默认项，标准内核软件包保留
+func revertKernelSwap(swap *kernelSwap) error {
+	if swap.Snapshot != "" {
+		if err := rollbackSnapshot(swap.Snapshot); err != nil {
+			return err
+		}
+	}
+	runCommandQuiet("grub-editenv", grubEnvPath, "unset", "next_entry", "saved_entry")
+	if err := removeFile(kernelSwapPath); err != nil {
+		return err
+	}
+	finishDryRun()
+	running, _ := runCommandOutput("uname", "-r")
+	if running == swap.Kernel {
+		fmt.Printf("%s GRUB 配置已恢复，重启后回到 %s\n", Green, swap.Previous)
+	} else {
+		fmt.Printf("%s GRUB 配置已恢复，默认启动 %s\n", Green, swap.Previous)
+	}
+	fmt.Printf("标准内核软件包仍保留，回到原内核后可手动删除: apt purge linux-image-%s\n", swap.Kernel)
+	return nil
+}
+
+func removeCloudKernels(pkgs []string) {
+	fmt.Printf("%s 卸载所有 Cloud 内核\n", Yellow)
+	if len(pkgs) == 0 {
+		fmt.Printf("%s 未找到 Cloud 内核包\n", Yellow)
+		return
+	}
+
+	fmt.Println("正在卸载以下包:", pkgs)
+
+	// unhold
+	args := append([]string{"unhold"}, pkgs...)
+	runCommandQuiet("apt-mark", args...)
+
+	// purge
+	purgeArgs := append([]string{"purge", "-y"}, pkgs...)
+	runCommandEnv([]string{"DEBIAN_FRONTEND=noninteractive"}, "apt", purgeArgs...)
+
+	runCommandQuiet("apt", "autoremove", "-y", "--purge")
+	fmt.Printf("%s ✓ Cloud 内核清理流程结束\n", Green)
+}
@@ -6,15 +6,23 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"l2tp/internal/credential"
+	"l2tp/internal/osinfo"
+	"l2tp/internal/publicip"
+	"l2tp/internal/render"
 )
 
 const (
@@ -47,10 +55,23 @@ func printColor(color, text string) {
 
 // runCommand 执行 Shell 命令
 func runCommand(name string, args ...string) error {
+	return runCommandEnv(nil, name, args...)
+}
+
+// runCommandEnv 附加环境变量执行命令，dry-run 模式下只记录不执行
+func runCommandEnv(env []string, name string, args ...string) error {
+	if dryRun {
+		currentPlan.addCommand(name, args...)
+		return nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, name, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	
@@ -63,7 +84,16 @@ func runCommand(name string, args ...string) error {
 	return nil
 }
 
-// runCommandOutput 执行命令并获取输出
+// runCommandQuiet 执行命令并丢弃输出，用于尽力而为的清理操作
+func runCommandQuiet(name string, args ...string) error {
+	if dryRun {
+		currentPlan.addCommand(name, args...)
+		return nil
+	}
+	return exec.Command(name, args...).Run()
+}
+
+// runCommandOutput 执行只读查询命令并获取输出，dry-run 模式下同样执行
 func runCommandOutput(name string, args ...string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
@@ -87,6 +117,40 @@ func fileExists(filename string) bool {
 	return !info.IsDir()
 }
 
+// writeFile 写入文件，必要时创建上级目录；dry-run 模式下只记录与现有内容的差异，
+// 存在事务时先备份原文件
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if dryRun {
+		currentPlan.addFile(path, data)
+		return nil
+	}
+	if err := currentTxn.track(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建目录 %s 失败: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", path, err)
+	}
+	return nil
+}
+
+// removeFile 删除文件，文件不存在时不报错
+func removeFile(path string) error {
+	if dryRun {
+		currentPlan.addCommand("rm", "-f", path)
+		return nil
+	}
+	if err := currentTxn.track(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func dirExists(dirname string) bool {
 	info, err := os.Stat(dirname)
 	if os.IsNotExist(err) {
@@ -110,6 +174,10 @@ func readInput(prompt string, defaultValue string) string {
 }
 
 func askYesNo(prompt string) bool {
+	if assumeYes {
+		fmt.Printf("%s [y/N]: y\n", prompt)
+		return true
+	}
 	for {
 		fmt.Printf("%s [y/N]: ", prompt)
 		input, _ := reader.ReadString('\n')
@@ -123,66 +191,17 @@ func askYesNo(prompt string) bool {
 	}
 }
 
-// 随机字符串生成
-func randString(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
+// askReboot 切换内核与重启的确认，-yes 不会自动确认，需要同时指定 -reboot
+func askReboot(prompt string) bool {
+	if allowReboot {
+		fmt.Printf("%s [y/N]: y\n", prompt)
+		return true
 	}
-	return string(b)
-}
-
-// updateConfigFile 更新或追加配置
-func updateConfigFile(filePath string, configs map[string]string, separator string) error {
-	content, err := os.ReadFile(filePath)
-	if err != nil && !os.IsNotExist(err) {
-		return err
-	}
-
-	lines := strings.Split(string(content), "\n")
-	newLines := make([]string, 0, len(lines)+len(configs))
-	processedKeys := make(map[string]bool)
-
-	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		updated := false
-		for key, value := range configs {
-			if strings.HasPrefix(trimmedLine, "#") {
-				continue
-			}
-
-			// 匹配 key
-			cleanLine := strings.ReplaceAll(trimmedLine, " ", "")
-			cleanKey := strings.ReplaceAll(key, " ", "")
-
-			if strings.HasPrefix(cleanLine, cleanKey+strings.TrimSpace(separator)) {
-				newLines = append(newLines, key+separator+value)
-				processedKeys[key] = true
-				updated = true
-				break
-			}
-		}
-		if !updated {
-			newLines = append(newLines, line)
-		}
-	}
-
-	// 追加新配置
-	for key, value := range configs {
-		if !processedKeys[key] {
-			newLines = append(newLines, key+separator+value)
-		}
-	}
-
-	// 移除末尾可能的空行并重新组合
-	output := strings.Join(newLines, "\n")
-	// 确保文件末尾有换行
-	if !strings.HasSuffix(output, "\n") {
-		output += "\n"
+	if assumeYes {
+		fmt.Printf("%s [y/N]: n (未指定 -reboot)\n", prompt)
+		return false
 	}
-
-	return os.WriteFile(filePath, []byte(output), 0644)
+	return askYesNo(prompt)
 }
 
 func checkExpiration() error {
@@ -287,559 +306,316 @@ func changeMirrors() {
 		cmdStr = `bash <(curl -sSL https://raw.githubusercontent.com/SuperManito/LinuxMirrors/main/ChangeMirrors.sh) --use-official-source true --protocol http --use-intranet-source false --install-epel true --backup true --upgrade-software false --clean-cache false --ignore-backup-tips --pure-mode`
 	}
 
-	cmd := exec.Command("bash", "-c", cmdStr)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := runCommand("bash", "-c", cmdStr); err != nil {
 		fmt.Printf("%s 警告：软件源切换失败，继续使用当前源\n", Yellow)
 	} else {
-		exec.Command("apt", "update", "-qq").Run()
+		runCommandQuiet("apt", "update", "-qq")
 	}
 }
 
-func checkCloudKernel() (bool, []string) {
-	out, _ := runCommandOutput("uname", "-r")
-	isCloud := strings.Contains(out, "cloud")
-
-	dpkgOut, _ := runCommandOutput("bash", "-c", "dpkg -l | awk '/linux-(image|headers)-[0-9].*cloud/ {print $2}'")
-	pkgs := strings.Fields(dpkgOut)
-
-	return isCloud, pkgs
+// detectOS 读取 os-release 并识别发行版，衍生版按 ID_LIKE 中的上游发行版处理
+func detectOS() (*osinfo.System, error) {
+	release, err := osinfo.Read("/")
+	if err != nil {
+		return nil, err
+	}
+	return osinfo.Detect(release)
 }
 
-func installStandardKernel() error {
-	fmt.Printf("%s [1/5] 安装标准内核\n", Yellow)
-
-	imagePkg := "linux-image-amd64"
-	headersPkg := "linux-headers-amd64"
+func installDependencies(sys *osinfo.System, cfg *Config) error {
+	fmt.Printf("%s 正在检查并安装依赖 (%s)...%s\n", Tip, sys, Nc)
 
-	if releaseOut, _ := os.ReadFile("/etc/os-release"); strings.Contains(string(releaseOut), "Ubuntu") {
-		imagePkg = "linux-image-generic"
-		headersPkg = "linux-headers-generic"
+	manager := sys.Manager()
+	pm := packageManagers[manager]
+	apps := []string{"curl", "nftables", "ppp"}
+	apps = append(apps, vpnPackages(cfg)...)
+	if cfg.IKEv2.Enabled {
+		apps = append(apps, "strongswan-eap")
 	}
-
-	fmt.Printf("正在安装 %s %s ...\n", imagePkg, headersPkg)
-
-	cmd := exec.Command("apt", "install", "-y", "--reinstall", imagePkg, headersPkg)
-	cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("标准内核安装失败")
-	}
-
-	// 更新 initramfs
-	cmdStr := `ls /boot/vmlinuz-* 2>/dev/null | grep -v cloud | sort -V | tail -1 | sed 's|/boot/vmlinuz-||'`
-	stdKernel, _ := runCommandOutput("bash", "-c", cmdStr)
-	if stdKernel != "" {
-		fmt.Printf("更新 initramfs: %s\n", stdKernel)
-		runCommand("update-initramfs", "-u", "-k", stdKernel)
+	if cfg.IPv6.Enabled || cfg.Routing.domains() {
+		// 只需要 dnsmasq 程序发送 RA 与按域名分流
+		apps = append(apps, "dnsmasq")
 	}
+	apps = sys.Packages(apps...)
 
-	fmt.Printf("%s ✓ 标准内核安装完成: %s\n", Green, stdKernel)
-	return nil
-}
-
-func removeCloudKernels(pkgs []string) {
-	fmt.Printf("%s [2/5] 卸载所有 Cloud 内核\n", Yellow)
-	if len(pkgs) == 0 {
-		fmt.Printf("%s 未找到 Cloud 内核包\n", Yellow)
-		return
+	// 执行更新
+	if err := runCommand(pm.Update[0], pm.Update[1:]...); err != nil {
+		fmt.Printf("%s 警告: 系统更新失败，尝试继续安装...\n", Tip)
 	}
 
-	fmt.Println("正在卸载以下包:", pkgs)
+	// 安装前记录缺少的软件包，卸载时只删除这些软件包
+	repos := sys.Repos()
+	missing := pm.missing(append(repoPackages(repos), apps...))
 
-	// unhold
-	args := append([]string{"unhold"}, pkgs...)
-	exec.Command("apt-mark", args...).Run()
-
-	// purge
-	purgeArgs := append([]string{"purge", "-y"}, pkgs...)
-	cmd := exec.Command("apt", purgeArgs...)
-	cmd.Env = append(os.Environ(), "DEBIAN_FRONTEND=noninteractive")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
-
-	exec.Command("apt", "autoremove", "-y", "--purge").Run()
-	fmt.Printf("%s ✓ Cloud 内核清理流程结束\n", Green)
-}
-
-func updateGrub() {
-	fmt.Printf("%s [3/5] 配置 GRUB\n", Yellow)
-
-	grubConfig := `GRUB_DEFAULT=0
-GRUB_TIMEOUT=5
-GRUB_DISTRIBUTOR=$(lsb_release -i -s 2> /dev/null || echo Debian)
-GRUB_CMDLINE_LINUX_DEFAULT="quiet"
-GRUB_CMDLINE_LINUX=""
-GRUB_DISABLE_OS_PROBER=true
-`
-	// 备份：仅当目录为空时备份
-	backupDir := "/root/grub_backup"
-	os.MkdirAll(backupDir, 0755)
-	
-	files, _ := os.ReadDir(backupDir)
-	if len(files) == 0 {
-		runCommand("cp", "/etc/default/grub", fmt.Sprintf("%s/grub.default.bak", backupDir))
+	// RHEL 系的 xl2tpd、pptpd 与 strongswan 位于 EPEL，先安装软件源包
+	if len(repos) > 0 {
+		fmt.Printf("%s 正在启用软件源: %s\n", Tip, strings.Join(repos, " "))
+		if err := runCommand(pm.Install[0], append(pm.Install[1:], repos...)...); err != nil {
+			return fmt.Errorf("启用软件源失败: %v", err)
+		}
 	}
 
-	distributor := "Debian"
-	if out, err := runCommandOutput("lsb_release", "-i", "-s"); err == nil {
-		distributor = out
+	fmt.Printf("%s 正在安装依赖...\n", Tip)
+	if err := runCommand(pm.Install[0], append(pm.Install[1:], apps...)...); err != nil {
+		return fmt.Errorf("依赖安装失败: %v", err)
 	}
-
-	finalGrubConfig := strings.Replace(grubConfig, "$(lsb_release -i -s 2> /dev/null || echo Debian)", distributor, 1)
-
-	os.WriteFile("/etc/default/grub", []byte(finalGrubConfig), 0644)
-
-	fmt.Println("重新生成 GRUB 配置...")
-	runCommand("update-grub")
-	runCommand("grub-set-default", "0")
-
-	if dirExists("/sys/firmware/efi") {
-		fmt.Println("更新 UEFI 引导...")
-		runCommand("grub-install", "--target=x86_64-efi", "--efi-directory=/boot/efi", "--bootloader-id=debian", "--recheck")
+	if err := recordPackages(sys.ID, manager, missing); err != nil {
+		fmt.Printf("%s 写入安装清单失败，卸载时将按当前系统删除软件包: %v\n", Tip, err)
 	}
-
-	fmt.Printf("%s ✓ GRUB 更新完成\n", Green)
+	return nil
 }
 
-func performKernelSwap() {
-	osInfo := getOSInfo()
-	if osInfo.ID != "debian" && osInfo.ID != "ubuntu" && osInfo.ID != "kali" {
-		fmt.Printf("%s 错误: 内核切换功能仅支持 Debian/Ubuntu 系统 (当前检测为: %s)\n", Error, osInfo.ID)
-		return
+// repoPackages 返回软件源包的包名，以 URL 安装的 rpm 取文件名中的包名，例如 epel-release
+func repoPackages(repos []string) []string {
+	var packages []string
+	for _, repo := range repos {
+		if name, ok := strings.CutSuffix(path.Base(repo), ".noarch.rpm"); ok {
+			repo, _, _ = strings.Cut(name, "-latest")
+		}
+		packages = append(packages, repo)
 	}
+	return packages
+}
 
-	fmt.Printf("\n%s⚠️  高危操作警告 ⚠️%s\n", Red, Nc)
-	fmt.Println("更换内核有可能会失败导致系统无法启动，请务必提前备份重要数据")
-	if !askYesNo("确认继续？") {
-		fmt.Println("操作已取消")
-		return
+// getPublicIP 获取服务器公网地址，双栈主机优先返回 IPv4。依次使用 -public-ip 指定的地址、
+// 多个回显服务一致返回的地址与默认路由的源地址，全部失败时返回错误，不会使用回环地址
+func getPublicIP(cfg *Config) (string, error) {
+	for _, family := range []publicip.Family{publicip.IPv4, publicip.IPv6} {
+		if ip := cfg.publicIP(family); ip != "" {
+			return ip, nil
+		}
 	}
-
-	// 确保基础工具存在
-	runCommand("apt-get", "update", "-qq")
-	runCommand("apt-get", "install", "-y", "-qq", "curl", "ca-certificates")
-
-	changeMirrors()
-	if err := installStandardKernel(); err != nil {
-		fmt.Println(Error, err)
-		return
+	ip, err4 := discoverPublicIP(publicip.IPv4)
+	if err4 == nil {
+		return ip, nil
 	}
-
-	_, pkgs := checkCloudKernel()
-	removeCloudKernels(pkgs)
-
-	updateGrub()
-
-	fmt.Printf("\n%s内核切换操作完成！需要重启生效。%s\n", Green, Nc)
-	if askYesNo("立即重启？") {
-		runCommand("reboot")
-	} else {
-		fmt.Println("请稍后手动重启。")
+	ip, err6 := discoverPublicIP(publicip.IPv6)
+	if err6 == nil {
+		return ip, nil
 	}
-	os.Exit(0)
-}
-
-// OSInfo 系统信息
-type OSInfo struct {
-	ID        string
-	VersionID string
-}
-
-func getOSInfo() OSInfo {
-	content, err := os.ReadFile("/etc/os-release")
-	if err != nil {
-		// 尝试 /usr/lib/os-release
-		content, _ = os.ReadFile("/usr/lib/os-release")
-	}
-
-	info := OSInfo{}
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "ID=") {
-			info.ID = strings.Trim(strings.TrimPrefix(line, "ID="), "\"")
+	for _, family := range []publicip.Family{publicip.IPv4, publicip.IPv6} {
+		addr, err := publicip.RouteAddress(family)
+		if err != nil {
+			continue
 		}
-		if strings.HasPrefix(line, "VERSION_ID=") {
-			info.VersionID = strings.Trim(strings.TrimPrefix(line, "VERSION_ID="), "\"")
+		fmt.Printf("%s 警告: 无法通过外部服务确认公网 IP，使用默认路由的源地址 %s\n", Tip, addr)
+		if !publicip.Public(addr) {
+			fmt.Printf("%s 警告: %s 是私有地址，服务器位于 NAT 之后时请使用 -public-ip 指定公网地址\n", Tip, addr)
 		}
+		return addr.String(), nil
 	}
-	return info
+	return "", fmt.Errorf("无法获取公网 IP，请使用 -public-ip 或配置文件中的 public_ip 指定\n  %v\n  %v", err4, err6)
 }
 
-func installDependencies(osInfo OSInfo) {
-	fmt.Printf("%s 正在检查并安装依赖...%s\n", Tip, Nc)
-
-	var updateCmd, installCmd string
-	apps := []string{"curl", "xl2tpd", "strongswan", "pptpd", "nftables"}
-
-	switch osInfo.ID {
-	case "debian", "ubuntu", "kali":
-		updateCmd = "apt update -y -q"
-		installCmd = "apt install -y -q"
-	case "alpine":
-		updateCmd = "apk update -f -q"
-		installCmd = "apk add -f -q"
-	case "centos", "almalinux", "rocky", "oracle", "fedora":
-		updateCmd = "dnf update -y -q"
-		installCmd = "dnf install -y -q"
-		if osInfo.ID == "centos" {
-			updateCmd = "yum update -y -q"
-			installCmd = "yum install -y -q"
-		}
-	default:
-		fmt.Printf("%s 不支持的操作系统: %s\n", Error, osInfo.ID)
-		os.Exit(1)
+// getPublicIPv6 获取公网 IPv6，主机没有 IPv6 出口时返回空。默认路由的源地址为 ULA 时不使用
+func getPublicIPv6(cfg *Config) string {
+	if ip := cfg.publicIP(publicip.IPv6); ip != "" {
+		return ip
 	}
-
-	// 执行更新
-	if err := runCommand("bash", "-c", updateCmd); err != nil {
-		fmt.Printf("%s 警告: 系统更新失败，尝试继续安装...\n", Tip)
+	if ip, err := discoverPublicIP(publicip.IPv6); err == nil {
+		return ip
 	}
-
-	// 安装软件包
-	fullInstallCmd := fmt.Sprintf("%s %s ppp", installCmd, strings.Join(apps, " "))
-
-	fmt.Printf("%s 正在安装依赖...\n", Tip)
-	if err := runCommand("bash", "-c", fullInstallCmd); err != nil {
-		fmt.Printf("%s 错误: 依赖安装失败，脚本退出。\n", Error)
-		os.Exit(1)
+	if addr, err := publicip.RouteAddress(publicip.IPv6); err == nil && publicip.Public(addr) {
+		return addr.String()
 	}
+	return ""
 }
 
-// getPublicIP 并发获取公网IP
-func getPublicIP() string {
-	apis := []string{
-		"http://api64.ipify.org",
-		"http://4.ipw.cn",
-		"http://ip.sb",
-		"http://checkip.amazonaws.com",
-		"http://icanhazip.com",
-		"http://ipinfo.io/ip",
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// discoverPublicIP 通过回显服务探测指定协议族的公网地址
+func discoverPublicIP(family publicip.Family) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
 	defer cancel()
+	return publicip.Discover(ctx, family, publicip.Sources, publicip.HTTPFetcher)
+}
 
-	resultChan := make(chan string, 1)
-	var wg sync.WaitGroup
-
-	for _, url := range apis {
-		wg.Add(1)
-		go func(apiURL string) {
-			defer wg.Done()
-			
-			req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
-			if err != nil {
-				return
-			}
-
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err == nil {
-				defer resp.Body.Close()
-				body, _ := io.ReadAll(resp.Body)
-				ip := strings.TrimSpace(string(body))
-				
-				if ip != "" && !strings.Contains(ip, "<") {
-					select {
-					case resultChan <- ip:
-						cancel()
-					default:
-					}
-				}
-			}
-		}(url)
-	}
-
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
-
-	select {
-	case ip := <-resultChan:
-		if ip != "" {
-			return ip
+// defaultInterface 返回默认路由所在的网卡，ipv6 为 true 时查询 IPv6 默认路由，查询失败时回落到 IPv4
+func defaultInterface(ipv6 bool) string {
+	if ipv6 {
+		out, err := runCommandOutput("bash", "-c", "ip -6 route get 2001:4860:4860::8888 | grep -o 'dev [^ ]*' | awk '{print $2; exit}'")
+		if err == nil && out != "" {
+			return out
 		}
-	case <-ctx.Done():
 	}
-
-	return "127.0.0.1"
+	out, err := runCommandOutput("bash", "-c", "ip route get 8.8.8.8 | awk '{print $5; exit}'")
+	if err == nil && out != "" {
+		return out
+	}
+	return "eth0"
 }
 
-func setupSysctl() {
-	configs := map[string]string{
-		"net.ipv4.ip_forward":                  "1",
-		"net.ipv4.conf.all.send_redirects":     "0",
-		"net.ipv4.conf.default.send_redirects": "0",
-		"net.ipv4.conf.all.accept_redirects":   "0",
-		"net.ipv4.conf.default.accept_redirects": "0",
+func installVPN(cfg *Config) error {
+	publicIP, err := getPublicIP(cfg)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println(Tip, "正在配置 Sysctl 参数...")
-	if err := updateConfigFile("/etc/sysctl.conf", configs, " = "); err != nil {
-		fmt.Printf("%s 警告: 更新 sysctl.conf 失败: %v\n", Tip, err)
+	passwordPolicy, err := cfg.passwordPolicy()
+	if err != nil {
+		return err
 	}
-
-	runCommand("sysctl", "-p")
-}
-
-func setupNftables(l2tpPort, pptpPort, l2tpLocIP, pptpLocIP string) {
-	// 备份
-	if fileExists("/etc/nftables.conf") && !fileExists("/etc/nftables.conf.bak") {
-		runCommand("cp", "/etc/nftables.conf", "/etc/nftables.conf.bak")
+	pskPolicy, err := cfg.pskPolicy()
+	if err != nil {
+		return err
 	}
 
-	interfaceName := "eth0"
-	// 获取默认网卡
-	out, err := runCommandOutput("bash", "-c", "ip route get 8.8.8.8 | awk '{print $5; exit}'")
-	if err == nil && out != "" {
-		interfaceName = out
-	}
-
-	config := fmt.Sprintf(`#!/usr/sbin/nft -f
-
-flush ruleset
-
-table inet filter {
-    chain input {
-        type filter hook input priority 0;
-        ct state established,related accept
-        ip protocol icmp accept
-        iif lo accept
-        udp dport {500,4500,%s,%s} accept
-        accept
-    }
-    chain forward {
-        type filter hook forward priority 0;
-        ct state established,related accept
-        ip saddr %s.0/24 accept
-        ip saddr %s.0/24 accept
-        accept
-    }
-    chain output {
-        type filter hook output priority 0;
-        accept
-    }
-}
-
-table ip nat {
-    chain prerouting {
-        type nat hook prerouting priority 0;
-        accept
-    }
-    chain postrouting {
-        type nat hook postrouting priority 100;
-        oif "%s" masquerade
-    }
-    chain output {
-        type nat hook output priority 0;
-        accept
-    }
-}
-`, l2tpPort, pptpPort, l2tpLocIP, pptpLocIP, interfaceName)
-
-	os.WriteFile("/etc/nftables.conf", []byte(config), 0755)
-	runCommand("systemctl", "daemon-reload")
-	runCommand("systemctl", "enable", "nftables")
-	runCommand("systemctl", "restart", "nftables")
-}
-
-func installVPN() string {
-	publicIP := getPublicIP()
-
 	fmt.Println()
 	// L2TP 配置
-	fmt.Println(Tip, "请输入 L2TP IP范围:")
-	l2tpLocIP := readInput("(默认范围: 10.10.10)", "10.10.10")
-
-	fmt.Println(Tip, "请输入 L2TP 端口:")
-	l2tpPort := readInput("(默认端口: 1701)", "1701")
-
-	l2tpUser := randString(5)
-	fmt.Printf("%s 请输入 L2TP 用户名:\n", Tip)
-	l2tpUser = readInput(fmt.Sprintf("(默认用户名: %s)", l2tpUser), l2tpUser)
-
-	l2tpPass := randString(7)
-	fmt.Printf("%s 请输入 %s 的密码:\n", Tip, l2tpUser)
-	l2tpPass = readInput(fmt.Sprintf("(默认密码: %s)", l2tpPass), l2tpPass)
+	if cfg.l2tpEnabled() {
+		l2tpLocIP := ask(cfg.L2TP.IPRange, "请输入 L2TP IP范围:", "(默认范围: 10.10.10)", "10.10.10")
+		l2tpPort := ask(cfg.L2TP.Port, "请输入 L2TP 端口:", "(默认端口: 1701)", "1701")
 
-	l2tpPSK := randString(20)
-	fmt.Printf("%s 请输入 L2TP PSK 密钥:\n", Tip)
-	l2tpPSK = readInput(fmt.Sprintf("(默认PSK: %s)", l2tpPSK), l2tpPSK)
+		l2tpUser, err := credential.UserPolicy.Generate()
+		if err != nil {
+			return err
+		}
+		l2tpUser = ask(cfg.L2TP.User, "请输入 L2TP 用户名:", fmt.Sprintf("(默认用户名: %s)", l2tpUser), l2tpUser)
+		l2tpPass, err := askSecret(cfg.L2TP.Password, fmt.Sprintf("请输入 %s 的密码:", l2tpUser), passwordPolicy)
+		if err != nil {
+			return err
+		}
+		l2tpPSK, err := askSecret(cfg.L2TP.PSK, "请输入 L2TP PSK 密钥:", pskPolicy)
+		if err != nil {
+			return err
+		}
+		// 回写最终取值，供后续步骤使用
+		cfg.L2TP = L2TPConfig{IPRange: l2tpLocIP, Port: l2tpPort, User: l2tpUser, Password: l2tpPass, PSK: l2tpPSK}
+	} else {
+		cfg.L2TP = L2TPConfig{}
+	}
 
 	// PPTP 配置
-	fmt.Println(Tip, "请输入 PPTP IP范围:")
-	pptpLocIP := readInput("(默认范围: 192.168.30)", "192.168.30")
-
-	fmt.Println(Tip, "请输入 PPTP 端口:")
-	pptpPort := readInput("(默认端口: 1723)", "1723")
+	if cfg.pptpEnabled() {
+		pptpLocIP := ask(cfg.PPTP.IPRange, "请输入 PPTP IP范围:", "(默认范围: 192.168.30)", "192.168.30")
+		pptpPort := ask(cfg.PPTP.Port, "请输入 PPTP 端口:", "(默认端口: 1723)", "1723")
 
-	pptpUser := randString(5)
-	fmt.Printf("%s 请输入 PPTP 用户名:\n", Tip)
-	pptpUser = readInput(fmt.Sprintf("(默认用户名: %s)", pptpUser), pptpUser)
+		pptpUser, err := credential.UserPolicy.Generate()
+		if err != nil {
+			return err
+		}
+		pptpUser = ask(cfg.PPTP.User, "请输入 PPTP 用户名:", fmt.Sprintf("(默认用户名: %s)", pptpUser), pptpUser)
+		pptpPass, err := askSecret(cfg.PPTP.Password, fmt.Sprintf("请输入 %s 的密码:", pptpUser), passwordPolicy)
+		if err != nil {
+			return err
+		}
+		cfg.PPTP = PPTPConfig{IPRange: pptpLocIP, Port: pptpPort, User: pptpUser, Password: pptpPass}
+	} else {
+		cfg.PPTP = PPTPConfig{}
+	}
 
-	pptpPass := randString(7)
-	fmt.Printf("%s 请输入 %s 的密码:\n", Tip, pptpUser)
-	pptpPass = readInput(fmt.Sprintf("(默认密码: %s)", pptpPass), pptpPass)
+	// 双栈主机分别获取 IPv4 与 IPv6 公网地址，L2TP/IPSec 按协议族声明 leftid
+	publicIPv6 := ""
+	if cfg.IPv6.Enabled {
+		cfg.IPv6.applyDefaults()
+		if ip := net.ParseIP(publicIP); ip != nil && ip.To4() != nil {
+			publicIPv6 = getPublicIPv6(cfg)
+		}
+	}
+	if cfg.IKEv2.Enabled {
+		cfg.IKEv2.applyDefaults()
+		if cfg.IKEv2.ServerID == "" {
+			cfg.IKEv2.ServerID = publicIP
+		}
+		if (cfg.IKEv2.ServerCert == "" && cfg.IKEv2.ServerKey == "") || cfg.IKEv2.CACert != "" {
+			if err := ensureServerCert(&cfg.IKEv2, publicIPv6); err != nil {
+				return err
+			}
+		} else if !fileExists(cfg.IKEv2.ServerCert) || !fileExists(cfg.IKEv2.ServerKey) {
+			return fmt.Errorf("找不到 IKEv2 服务端证书或私钥: %s, %s", cfg.IKEv2.ServerCert, cfg.IKEv2.ServerKey)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
 
 	// 展示配置信息
+	l2tp, pptp := cfg.L2TP, cfg.PPTP
 	fmt.Println()
-	fmt.Printf("%s L2TP服务器本地IP: %s%s.1%s\n", Info, Green, l2tpLocIP, Nc)
-	fmt.Printf("%s L2TP客户端IP范围: %s%s.11-%s.255%s\n", Info, Green, l2tpLocIP, l2tpLocIP, Nc)
-	fmt.Printf("%s L2TP端口    : %s%s%s\n", Info, Green, l2tpPort, Nc)
-	fmt.Printf("%s L2TP用户名  : %s%s%s\n", Info, Green, l2tpUser, Nc)
-	fmt.Printf("%s L2TP密码    : %s%s%s\n", Info, Green, l2tpPass, Nc)
-	fmt.Printf("%s L2TPPSK密钥 : %s%s%s\n", Info, Green, l2tpPSK, Nc)
-	fmt.Println()
-	fmt.Printf("%s PPTP服务器本地IP: %s%s.1%s\n", Info, Green, pptpLocIP, Nc)
-	fmt.Printf("%s PPTP客户端IP范围: %s%s.11-%s.255%s\n", Info, Green, pptpLocIP, pptpLocIP, Nc)
-	fmt.Printf("%s PPTP端口    : %s%s%s\n", Info, Green, pptpPort, Nc)
-	fmt.Printf("%s PPTP用户名  : %s%s%s\n", Info, Green, pptpUser, Nc)
-	fmt.Printf("%s PPTP密码    : %s%s%s\n", Info, Green, pptpPass, Nc)
-	fmt.Println()
+	if cfg.l2tpEnabled() {
+		fmt.Printf("%s L2TP服务器本地IP: %s%s.1%s\n", Info, Green, l2tp.IPRange, Nc)
+		fmt.Printf("%s L2TP客户端IP范围: %s%s.11-%s.255%s\n", Info, Green, l2tp.IPRange, l2tp.IPRange, Nc)
+		fmt.Printf("%s L2TP端口    : %s%s%s\n", Info, Green, l2tp.Port, Nc)
+		fmt.Printf("%s L2TP用户名  : %s%s%s\n", Info, Green, l2tp.User, Nc)
+		fmt.Printf("%s L2TP密码    : %s%s%s\n", Info, Green, l2tp.Password, Nc)
+		fmt.Printf("%s L2TPPSK密钥 : %s%s%s\n", Info, Green, l2tp.PSK, Nc)
+		fmt.Println()
+	}
+	if cfg.pptpEnabled() {
+		fmt.Printf("%s PPTP服务器本地IP: %s%s.1%s\n", Info, Green, pptp.IPRange, Nc)
+		fmt.Printf("%s PPTP客户端IP范围: %s%s.11-%s.255%s\n", Info, Green, pptp.IPRange, pptp.IPRange, Nc)
+		fmt.Printf("%s PPTP端口    : %s%s%s\n", Info, Green, pptp.Port, Nc)
+		fmt.Printf("%s PPTP用户名  : %s%s%s\n", Info, Green, pptp.User, Nc)
+		fmt.Printf("%s PPTP密码    : %s%s%s\n", Info, Green, pptp.Password, Nc)
+		fmt.Println()
+	}
+	if cfg.IKEv2.Enabled {
+		fmt.Printf("%s IKEv2认证方式: %s%s%s\n", Info, Green, cfg.IKEv2.Auth, Nc)
+		fmt.Printf("%s IKEv2客户端IP范围: %s%s.0/24%s\n", Info, Green, cfg.IKEv2.IPRange, Nc)
+		fmt.Printf("%s IKEv2服务端标识: %s%s%s\n", Info, Green, cfg.IKEv2.ServerID, Nc)
+		fmt.Println()
+	}
+	if cfg.IPv6.Enabled {
+		fmt.Printf("%s IPv6模式   : %s%s%s\n", Info, Green, cfg.IPv6.Mode, Nc)
+		fmt.Printf("%s IPv6客户端前缀: %s%s%s\n", Info, Green, cfg.IPv6.Prefix, Nc)
+		if publicIPv6 != "" {
+			fmt.Printf("%s 公网IPv6   : %s%s%s\n", Info, Green, publicIPv6, Nc)
+		}
+		fmt.Println()
+	}
 
 	fmt.Println("正在生成配置文件...")
 
-	// /etc/ipsec.conf
-	ipsecConf := fmt.Sprintf(`config setup
-    charondebug="ike 2, knl 2, cfg 2"
-    uniqueids=no
-
-conn %%default
-    keyexchange=ikev1
-    authby=secret
-    ike=aes256-sha1-modp1024,aes128-sha1-modp1024,3des-sha1-modp1024!
-    esp=aes256-sha1,aes128-sha1,3des-sha1!
-    keyingtries=3
-    ikelifetime=8h
-    lifetime=1h
-    dpdaction=clear
-    dpddelay=30s
-    dpdtimeout=120s
-    rekey=no
-    forceencaps=yes
-    fragmentation=yes
-
-conn L2TP-PSK
-    left=%%any
-    leftid=%s
-    leftfirewall=yes
-    leftprotoport=17/%s
-    right=%%any
-    rightprotoport=17/%%any
-    type=transport
-    auto=add
-    also=%%default
-`, publicIP, l2tpPort)
-	os.WriteFile("/etc/ipsec.conf", []byte(ipsecConf), 0644)
-
-	// /etc/ipsec.secrets
-	ipsecSecrets := fmt.Sprintf(`%%any %%any : PSK "%s"
-`, l2tpPSK)
-	os.WriteFile("/etc/ipsec.secrets", []byte(ipsecSecrets), 0600)
-
-	// /etc/xl2tpd/xl2tpd.conf
-	xl2tpdConf := fmt.Sprintf(`[global]
-port = %s
-
-[lns default]
-ip range = %s.11-%s.255
-local ip = %s.1
-require chap = yes
-refuse pap = yes
-require authentication = yes
-name = l2tpd
-ppp debug = yes
-pppoptfile = /etc/ppp/options.xl2tpd
-length bit = yes
-`, l2tpPort, l2tpLocIP, l2tpLocIP, l2tpLocIP)
-	os.MkdirAll("/etc/xl2tpd", 0755)
-	os.WriteFile("/etc/xl2tpd/xl2tpd.conf", []byte(xl2tpdConf), 0644)
-
-	// /etc/ppp/options.xl2tpd
-	pppOptXl2tpd := `ipcp-accept-local
-ipcp-accept-remote
-require-mschap-v2
-noccp
-auth
-hide-password
-idle 1800
-mtu 1410
-mru 1410
-nodefaultroute
-debug
-proxyarp
-connect-delay 5000
-`
-	os.MkdirAll("/etc/ppp", 0755)
-	os.WriteFile("/etc/ppp/options.xl2tpd", []byte(pppOptXl2tpd), 0644)
-
-	// /etc/pptpd.conf
-	pptpdConf := fmt.Sprintf(`option /etc/ppp/pptpd-options
-debug
-localip %s.1
-remoteip %s.11-255
-`, pptpLocIP, pptpLocIP)
-	os.WriteFile("/etc/pptpd.conf", []byte(pptpdConf), 0644)
-
-	// /etc/ppp/pptpd-options
-	pptpdOptions := `name pptpd
-refuse-pap
-refuse-chap
-refuse-mschap
-require-mschap-v2
-require-mppe-128
-proxyarp
-lock
-nobsdcomp
-novj
-novjccomp
-nologfd
-`
-	os.WriteFile("/etc/ppp/pptpd-options", []byte(pptpdOptions), 0644)
-
-	// /etc/ppp/chap-secrets
-	chapSecrets := "# Secrets for authentication using CHAP\n# client    server    secret    IP addresses\n"
-
-	// 1. 添加主用户 (静态 IP .10)
-	chapSecrets += fmt.Sprintf("%s    l2tpd    %s    %s.10\n", l2tpUser, l2tpPass, l2tpLocIP)
-	chapSecrets += fmt.Sprintf("%s    pptpd    %s    %s.10\n", pptpUser, pptpPass, pptpLocIP)
-
-	// 2. 批量生成用户 (IP 11-255)
-	for i := 11; i <= 255; i++ {
-		chapSecrets += fmt.Sprintf("%s%d    l2tpd    %s%d    %s.%d\n", l2tpUser, i, l2tpPass, i, l2tpLocIP, i)
-		chapSecrets += fmt.Sprintf("%s%d    pptpd    %s%d    %s.%d\n", pptpUser, i, pptpPass, i, pptpLocIP, i)
-	}
-
-	os.WriteFile("/etc/ppp/chap-secrets", []byte(chapSecrets), 0600)
+	settings := render.Settings{
+		PublicIP:   publicIP,
+		PublicIPv6: publicIPv6,
+		L2TP:       render.L2TP{Enabled: cfg.l2tpEnabled(), IPRange: l2tp.IPRange, Port: l2tp.Port, PSK: l2tp.PSK},
+		PPTP:       render.PPTP{Enabled: cfg.pptpEnabled(), IPRange: pptp.IPRange},
+		IKEv2:      ikev2Settings(cfg, publicIP),
+		IPv6:       ipv6Settings(cfg),
+	}
+	settings.Users, err = chapUsers(cfg)
+	if err != nil {
+		return err
+	}
+	files, err := render.Render(settings)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := writeServiceFile(f.Path, f.Content, f.Mode); err != nil {
+			return err
+		}
+	}
+	if err := saveInstalledConfig(cfg); err != nil {
+		return err
+	}
 
 	// 设置系统和防火墙
-	setupSysctl()
-	setupNftables(l2tpPort, pptpPort, l2tpLocIP, pptpLocIP)
+	if err := setupSysctl(cfg); err != nil {
+		return err
+	}
+	if err := setupFirewall(cfg); err != nil {
+		return err
+	}
 
 	// 启动服务
 	fmt.Println("正在启动服务...")
-	services := []string{"ipsec", "xl2tpd", "pptpd"}
-	
-	// 检查 ipsec 服务名
-	if _, err := runCommandOutput("systemctl", "list-unit-files", "strongswan.service"); err == nil {
-		if _, err := runCommandOutput("systemctl", "list-unit-files", "ipsec.service"); err != nil {
-			services[0] = "strongswan"
-		}
-	}
-	runCommand("systemctl", "daemon-reload")
-	
-	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1\n"), 0644); err != nil {
-		fmt.Printf("%s 警告: 无法写入 ip_forward: %v\n", Tip, err)
+	services, unused := vpnServices(cfg)
+	if err := serviceManager().Reload(); err != nil {
+		return fmt.Errorf("重新加载服务配置失败: %v", err)
 	}
 
+	// 之前安装过、本次未选择的协议停止运行
+	for _, svc := range unused {
+		stopService(svc)
+	}
 	for _, svc := range services {
-		runCommand("systemctl", "enable", svc)
-		runCommand("systemctl", "restart", svc)
+		if err := startService(svc); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		return nil
 	}
 
 	fmt.Println()
@@ -848,98 +624,255 @@ nologfd
 	fmt.Printf("%s===============================================%s\n", Green, Nc)
 	fmt.Printf("请保留好以下信息:\n")
 	fmt.Printf("服务器IP: %s\n", publicIP)
-	fmt.Printf("L2TP PSK: %s\n", l2tpPSK)
-	fmt.Printf("L2TP 主账号: %s / 密码: %s\n", l2tpUser, l2tpPass)
-	fmt.Printf("PPTP 主账号: %s / 密码: %s\n", pptpUser, pptpPass)
-	fmt.Printf("\n%s 已自动生成批量账号，详情请查看 /etc/ppp/chap-secrets 文件%s\n", Tip, Nc)
-	return l2tpLocIP
+	if cfg.l2tpEnabled() {
+		fmt.Printf("L2TP PSK: %s\n", l2tp.PSK)
+		fmt.Printf("L2TP 主账号: %s / 密码: %s\n", l2tp.User, l2tp.Password)
+	}
+	if cfg.pptpEnabled() {
+		fmt.Printf("PPTP 主账号: %s / 密码: %s\n", pptp.User, pptp.Password)
+	}
+	if publicIPv6 != "" {
+		fmt.Printf("服务器IPv6: %s\n", publicIPv6)
+	}
+	if cfg.IKEv2.Enabled {
+		fmt.Printf("IKEv2 服务端标识 (Remote ID): %s\n", cfg.IKEv2.ServerID)
+		if cfg.IKEv2.Auth == "eap" {
+			fmt.Printf("IKEv2 账号: %s / 密码: %s\n", l2tp.User, l2tp.Password)
+		}
+	}
+	if cfg.BulkUsers > 0 {
+		fmt.Printf("\n%s 已自动生成 %d 个批量账号，详情请查看 /etc/ppp/chap-secrets 文件%s\n", Tip, cfg.BulkUsers, Nc)
+	}
+	fmt.Printf("%s 使用 l2tp export <用户名> 生成 iOS/macOS、Windows、Linux 客户端配置%s\n", Tip, Nc)
+	return nil
 }
 
-func configureSingboxFirewall(l2tpLocIP string, port string) {
-	fmt.Printf("%s 配置透明代理分流规则 (端口: %s)...\n", Tip, port)
-
-	// 1. 配置策略路由
-	runCommand("/bin/ip", "rule", "add", "fwmark", "1", "table", "100")
-	runCommand("/bin/ip", "route", "add", "local", "0.0.0.0/0", "dev", "lo", "table", "100")
-
-	// 2. 新建 SINGBOX 链
-	runCommand("iptables", "-t", "mangle", "-N", "SINGBOX")
+// ikev2Settings 将 IKEv2 配置转换为渲染参数，EAP 认证使用 L2TP 主账号
+func ikev2Settings(cfg *Config, publicIP string) render.IKEv2 {
+	k := cfg.IKEv2
+	if !k.Enabled {
+		return render.IKEv2{}
+	}
+	settings := render.IKEv2{
+		Enabled:    true,
+		Auth:       k.Auth,
+		IPRange:    k.IPRange,
+		ServerID:   k.ServerID,
+		ServerCert: k.ServerCert,
+		ServerKey:  k.ServerKey,
+		CACert:     k.CACert,
+		KeyType:    strings.ToUpper(k.KeyType),
+		DNS:        k.DNS,
+	}
+	if k.Auth == "eap" {
+		settings.Users = []render.EAPUser{{Name: cfg.L2TP.User, Secret: cfg.L2TP.Password}}
+	}
+	return settings
+}
 
-	// 3. 绕过局域网和私有地址
-	privateIPs := []string{
-		"0.0.0.0/8", "10.0.0.0/8", "127.0.0.0/8", "169.254.0.0/16",
-		"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+// ipv6Settings 将 IPv6 前缀划分给各协议，IKEv2 使用其 /56 中的第一个 /112 作为地址池
+func ipv6Settings(cfg *Config) render.IPv6 {
+	v := cfg.IPv6
+	if !v.Enabled {
+		return render.IPv6{}
 	}
-	for _, ip := range privateIPs {
-		runCommand("iptables", "-t", "mangle", "-A", "SINGBOX", "-d", ip, "-j", "RETURN")
+	settings := render.IPv6{Enabled: true, DNS: v.DNS}
+	if cfg.l2tpEnabled() {
+		settings.L2TPPrefix = v.subnet(ipv6SubnetL2TP).String()
 	}
-
-	// 4. 配置拦截规则
-	l2tpSubnet := fmt.Sprintf("%s.0/24", l2tpLocIP)
-	runCommand("iptables", "-t", "mangle", "-A", "SINGBOX", "-s", l2tpSubnet, "-p", "tcp", "-j", "TPROXY", "--on-port", port, "--tproxy-mark", "1")
-	runCommand("iptables", "-t", "mangle", "-A", "SINGBOX", "-s", l2tpSubnet, "-p", "udp", "-j", "TPROXY", "--on-port", port, "--tproxy-mark", "1")
-
-	// 5. 应用到 PREROUTING 链
-	runCommand("iptables", "-t", "mangle", "-A", "PREROUTING", "-j", "SINGBOX")
-
-	// 6. 禁止公网访问透明代理端口
-	runCommand("iptables", "-I", "INPUT", "-p", "tcp", "--dport", port, "-j", "DROP")
-	runCommand("iptables", "-I", "INPUT", "-p", "udp", "--dport", port, "-j", "DROP")
-
-	fmt.Printf("%s 透明代理分流规则配置完成\n", Green)
+	if cfg.pptpEnabled() {
+		settings.PPTPPrefix = v.subnet(ipv6SubnetPPTP).String()
+	}
+	if cfg.IKEv2.Enabled {
+		settings.IKEv2Pool = netip.PrefixFrom(v.subnet(ipv6SubnetIKEv2).Addr(), 112).String()
+	}
+	return settings
 }
 
-func uninstallService(port string) {
-	fmt.Printf("%s 正在卸载服务...\n", Tip)
-
-	// 停止服务
-	exec.Command("bash", "-c", "systemctl stop xl2tpd strongswan-starter strongswan pptpd 2>/dev/null || true").Run()
-
-	// 禁用服务
-	exec.Command("bash", "-c", "systemctl disable xl2tpd strongswan-starter strongswan pptpd 2>/dev/null || true").Run()
+// chapUsers 生成已启用协议的 chap-secrets 账号：主账号使用 .10，bulk_users 个批量账号从 .11 起依次分配，
+// 每个批量账号的密码独立随机生成；其余 IP 留给 l2tp user add
+func chapUsers(cfg *Config) ([]render.User, error) {
+	type account struct{ server, user, password, ipRange string }
+	var accounts []account
+	if cfg.l2tpEnabled() {
+		accounts = append(accounts, account{"l2tpd", cfg.L2TP.User, cfg.L2TP.Password, cfg.L2TP.IPRange})
+	}
+	if cfg.pptpEnabled() {
+		accounts = append(accounts, account{"pptpd", cfg.PPTP.User, cfg.PPTP.Password, cfg.PPTP.IPRange})
+	}
 
-	// 卸载软件
-	cmd := exec.Command("apt", "purge", "-y", "xl2tpd", "strongswan", "pptpd")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
+	policy, err := cfg.passwordPolicy()
+	if err != nil {
+		return nil, err
+	}
+	var users []render.User
+	for _, a := range accounts {
+		users = append(users, render.User{Name: a.user, Server: a.server, Secret: a.password, IP: a.ipRange + ".10"})
+	}
+	for i := firstUserHost + 1; i <= firstUserHost+cfg.BulkUsers; i++ {
+		for _, a := range accounts {
+			secret, err := policy.Generate()
+			if err != nil {
+				return nil, err
+			}
+			users = append(users, render.User{Name: fmt.Sprintf("%s%d", a.user, i), Server: a.server, Secret: secret, IP: fmt.Sprintf("%s.%d", a.ipRange, i)})
+		}
+	}
+	return users, nil
+}
 
-	// 清理防火墙规则
-	exec.Command("iptables", "-t", "mangle", "-D", "PREROUTING", "-j", "SINGBOX").Run()
-	exec.Command("iptables", "-t", "mangle", "-F", "SINGBOX").Run()
-	exec.Command("iptables", "-t", "mangle", "-X", "SINGBOX").Run()
+// vpnPackages 已启用协议需要的软件包，使用通用包名，安装与卸载时按发行版转换
+func vpnPackages(cfg *Config) []string {
+	var packages []string
+	if cfg.l2tpEnabled() {
+		packages = append(packages, "xl2tpd", "strongswan")
+	}
+	if cfg.pptpEnabled() {
+		packages = append(packages, "pptpd")
+	}
+	return packages
+}
 
-	// 清理路由表
-	exec.Command("/bin/ip", "route", "del", "local", "0.0.0.0/0", "dev", "lo", "table", "100").Run()
-	exec.Command("/bin/ip", "rule", "del", "fwmark", "1", "table", "100").Run()
+// vpnServices 返回已启用协议需要运行的服务，以及未启用协议对应的服务
+func vpnServices(cfg *Config) (services, unused []string) {
+	l2tp := []string{ipsecServiceName(), "xl2tpd"}
+	if cfg.l2tpEnabled() {
+		services = append(services, l2tp...)
+	} else {
+		unused = append(unused, l2tp...)
+	}
+	if cfg.pptpEnabled() {
+		services = append(services, "pptpd")
+	} else {
+		unused = append(unused, "pptpd")
+	}
+	if cfg.IPv6.Enabled {
+		services = append(services, render.RAServiceName)
+	} else {
+		unused = append(unused, render.RAServiceName)
+	}
+	return services, unused
+}
 
-	// 放行端口
-	exec.Command("iptables", "-D", "INPUT", "-p", "tcp", "--dport", port, "-j", "DROP").Run()
-	exec.Command("iptables", "-D", "INPUT", "-p", "udp", "--dport", port, "-j", "DROP").Run()
+// ipsecServiceName 检查 strongSwan 的服务名，不同发行版为 ipsec 或 strongswan
+func ipsecServiceName() string {
+	if m := serviceManager(); m.Exists("strongswan") && !m.Exists("ipsec") {
+		return "strongswan"
+	}
+	return "ipsec"
+}
 
-	fmt.Printf("%s 卸载完成\n", Green)
+// subcommands 子命令，形如 l2tp user add alice
+var subcommands = map[string]func(args []string) error{
+	"user":   runUserCommand,
+	"cert":   runCertCommand,
+	"export": runExportCommand,
+	"doctor": runDoctorCommand,
+	"status": runStatusCommand,
+	"kick":   runKickCommand,
+	"kernel": runKernelCommand,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			if os.Geteuid() != 0 {
+				fmt.Printf("%s 错误: 必须使用 root 权限运行此脚本\n", Error)
+				os.Exit(1)
+			}
+			if err := cmd(os.Args[2:]); err != nil {
+				if err != errChecksFailed {
+					fmt.Printf("%s %v\n", Error, err)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	outFlag := flag.Bool("out", false, "安装完成后自动配置分流规则")
 	rmFlag := flag.Bool("rm", false, "卸载服务并清理规则")
+	keepConfigFlag := flag.Bool("keep-config", false, "与 -rm 一起使用，保留 VPN 配置、账号与证书")
+	configFlag := flag.String("config", "", "从 YAML/JSON 配置文件读取安装参数，不再交互输入")
+	yesFlag := flag.Bool("yes", false, "配置相关的确认提示自动选择“是” (不包括切换内核与重启)")
+	rebootFlag := flag.Bool("reboot", false, "需要时自动切换到标准内核并重启")
+	dryRunFlag := flag.Bool("dry-run", false, "只显示将写入的文件差异和将执行的命令，不做任何修改")
+	protocolFlag := flag.String("protocol", "", "安装的协议: l2tp、pptp 或 both (默认 both)")
+	ipv6Flag := flag.Bool("ipv6", false, "同时为客户端分配 IPv6 (双栈)")
+	ikev2Flag := flag.String("ikev2", "", "同时启用 IKEv2，指定客户端认证方式: eap 或 cert")
+	upstreamFlag := flag.String("upstream", "", "-out 透明代理的上游: 分享链接或 SOCKS 地址 host:port")
+	publicIPFlag := flag.String("public-ip", "", "服务器公网 IP，跳过自动探测；双栈时用逗号分隔 IPv4 与 IPv6")
+	bulkUsersFlag := flag.Int("bulk-users", 0, "每个协议额外生成的批量账号数 (默认 0，之后可用 l2tp user add 添加)")
+	rollbackFlag := flag.Bool("rollback", false, "恢复到指定快照之前的状态: -rollback [快照ID]，省略 ID 时使用最近一次")
 	flag.Parse()
 
+	assumeYes = *yesFlag
+	allowReboot = *rebootFlag
+	dryRun = *dryRunFlag
+	cfg := &Config{}
+	if *configFlag != "" {
+		loaded, err := loadConfig(*configFlag)
+		if err != nil {
+			fmt.Printf("%s %v\n", Error, err)
+			os.Exit(1)
+		}
+		cfg = loaded
+		nonInteractive = true
+	}
+	if *protocolFlag != "" {
+		cfg.Protocol = *protocolFlag
+	}
+	if *ikev2Flag != "" {
+		cfg.IKEv2.Enabled = true
+		cfg.IKEv2.Auth = *ikev2Flag
+	}
+	if *ipv6Flag {
+		cfg.IPv6.Enabled = true
+	}
+	if *upstreamFlag != "" {
+		cfg.Proxy.Upstream = *upstreamFlag
+	}
+	if *publicIPFlag != "" {
+		cfg.PublicIP = *publicIPFlag
+	}
+	bulkUsersSet := isFlagSet(flag.CommandLine, "bulk-users")
+	if bulkUsersSet {
+		cfg.BulkUsers = *bulkUsersFlag
+	}
+	if *protocolFlag != "" || *ikev2Flag != "" || *ipv6Flag || *upstreamFlag != "" || *publicIPFlag != "" || bulkUsersSet {
+		if err := cfg.validate(); err != nil {
+			fmt.Printf("%s %v\n", Error, err)
+			os.Exit(1)
+		}
+	}
+
 	// 1. 检查 Root
 	if os.Geteuid() != 0 {
 		fmt.Printf("%s 错误: 必须使用 root 权限运行此脚本\n", Error)
 		os.Exit(1)
 	}
 
+	if *rollbackFlag {
+		if err := rollbackSnapshot(flag.Arg(0)); err != nil {
+			fmt.Printf("%s %v\n", Error, err)
+			os.Exit(1)
+		}
+		finishDryRun()
+		return
+	}
+
 	if *rmFlag {
-		fmt.Println(Tip, "请输入配置时使用的透明代理分流端口:")
-		port := readInput("(默认: 12345)", "12345")
-		uninstallService(port)
+		port := ask(cfg.ProxyPort, "请输入配置时使用的透明代理分流端口:", "(默认: 12345)", "12345")
+		if err := uninstallService(port, *keepConfigFlag); err != nil {
+			fmt.Printf("%s %v\n", Error, err)
+			os.Exit(1)
+		}
+		finishDryRun()
 		return
 	}
 
 	// 清屏
-	if runtime.GOOS == "linux" {
+	if runtime.GOOS == "linux" && !nonInteractive && !dryRun {
 		fmt.Print("\033[H\033[2J")
 	}
 
@@ -960,34 +893,80 @@ func main() {
 		os.Exit(1)
 	}
 
-	// 4. 检查 PPP 支持与内核切换逻辑
-	if !fileExists("/dev/ppp") {
-		fmt.Printf("%s 警告: 未检测到 /dev/ppp 设备，当前内核可能不支持 PPP。\n", Error)
-		uname, _ := runCommandOutput("uname", "-r")
-		fmt.Printf("%s 当前内核版本: %s\n", Tip, uname)
+	// 4. 选择协议，PPTP (MPPE) 已不安全，仅在兼容旧客户端时启用
+	cfg.Protocol = ask(cfg.Protocol, "请选择安装的协议 (l2tp / pptp / both):", "(默认: both)", protocolBoth)
+	if err := cfg.validate(); err != nil {
+		fmt.Printf("%s %v\n", Error, err)
+		os.Exit(1)
+	}
 
-		if askYesNo("是否尝试切换到标准内核 (将卸载Cloud内核并重置GRUB)?") {
-			performKernelSwap()
-		} else {
-			fmt.Printf("%s 用户取消操作，无法继续安装 VPN。\n", Error)
-			os.Exit(1)
+	// 5. 检查 PPP 支持，优先加载模块或补建设备节点，模块缺失时才切换内核
+	if swap, err := loadKernelSwap(); err == nil {
+		fmt.Printf("%s 内核切换 (%s -> %s) 尚未确认，运行 l2tp kernel finalize 或 l2tp kernel revert\n", Tip, swap.Previous, swap.Kernel)
+	}
+	if err := ensurePPP(cfg); err != nil {
+		fmt.Printf("%s %v\n", Error, err)
+		os.Exit(1)
+	}
+
+	// 6. 安装 VPN
+	sys, err := detectOS()
+	if err != nil {
+		fmt.Printf("%s %v\n", Error, err)
+		os.Exit(1)
+	}
+	if err := installDependencies(sys, cfg); err != nil {
+		fmt.Printf("%s %v\n", Error, err)
+		os.Exit(1)
+	}
+
+	txn, err := beginTransaction()
+	if err != nil {
+		fmt.Printf("%s %v\n", Error, err)
+		os.Exit(1)
+	}
+	currentTxn = txn
+
+	if err := installVPN(cfg); err != nil {
+		abort(err)
+	}
+
+	if *outFlag {
+		port := ask(cfg.ProxyPort, "请输入透明代理分流端口:", "(默认: 12345)", "12345")
+		if err := setupTProxy(cfg, port); err != nil {
+			abort(err)
 		}
-	} else {
-		isCloud, _ := checkCloudKernel()
-		if isCloud {
-			fmt.Printf("%s 提示: 检测到当前运行在 Cloud 内核上，但 /dev/ppp 存在，可以继续。\n", Tip)
-			fmt.Printf("如果安装后无法连接，建议重新运行脚本并选择切换内核。\n")
+		if err := setupRouting(cfg); err != nil {
+			abort(err)
+		}
+		if err := setupProxy(cfg); err != nil {
+			abort(err)
+		}
+		if err := saveInstalledConfig(cfg); err != nil {
+			abort(err)
 		}
 	}
 
-	// 5. 安装 VPN
-	osInfo := getOSInfo()
-	installDependencies(osInfo)
-	l2tpLocIP := installVPN()
+	if err := currentTxn.commit(); err != nil {
+		fmt.Printf("%s %v\n", Error, err)
+	}
+	if !dryRun {
+		// 服务刚启动，自检结果只作提示，不影响安装
+		fmt.Printf("\n%s 安装后自检:\n", Tip)
+		report := diagnose()
+		report.WriteText(os.Stdout, true)
+		if !report.OK() {
+			fmt.Printf("%s 按提示修复后运行 l2tp doctor 重新检查\n", Tip)
+		}
+	}
+	finishDryRun()
+}
 
-	if *outFlag {
-		fmt.Println(Tip, "请输入透明代理分流端口:")
-		port := readInput("(默认: 12345)", "12345")
-		configureSingboxFirewall(l2tpLocIP, port)
+// abort 安装失败时自动回滚本次修改的文件并以非零状态退出
+func abort(err error) {
+	fmt.Printf("%s %v\n", Error, err)
+	if rbErr := currentTxn.rollback(); rbErr != nil {
+		fmt.Printf("%s 自动回滚失败: %v\n", Error, rbErr)
 	}
+	os.Exit(1)
 }
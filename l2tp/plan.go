@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"l2tp/internal/diff"
+)
+
+// dryRun 为 true 时只记录将要写入的文件与执行的命令，不做任何修改
+var dryRun bool
+
+// plannedFile 计划写入的文件
+type plannedFile struct {
+	path string
+	diff string
+}
+
+// plan 记录 dry-run 期间收集到的全部变更
+type plan struct {
+	files    []plannedFile
+	commands []string
+}
+
+var currentPlan = &plan{}
+
+// addFile 计算目标文件与磁盘现有内容的差异并记录
+func (p *plan) addFile(path string, data []byte) {
+	old, _ := os.ReadFile(path)
+	p.files = append(p.files, plannedFile{
+		path: path,
+		diff: diff.Unified(path, path+" (计划)", string(old), string(data)),
+	})
+}
+
+func (p *plan) addCommand(name string, args ...string) {
+	p.commands = append(p.commands, shellJoin(append([]string{name}, args...)))
+}
+
+// print 输出完整计划
+func (p *plan) print() {
+	fmt.Println()
+	fmt.Printf("%s===============================================%s\n", Yellow, Nc)
+	fmt.Printf("%s执行计划 (dry-run，未做任何修改)%s\n", Yellow, Nc)
+	fmt.Printf("%s===============================================%s\n", Yellow, Nc)
+
+	fmt.Printf("\n%s 将写入 %d 个文件:\n", Tip, len(p.files))
+	for _, f := range p.files {
+		if f.diff == "" {
+			fmt.Printf("  %s (无变化)\n", f.path)
+			continue
+		}
+		fmt.Printf("  %s\n", f.path)
+	}
+	for _, f := range p.files {
+		if f.diff == "" {
+			continue
+		}
+		fmt.Println()
+		for _, line := range strings.SplitAfter(f.diff, "\n") {
+			switch {
+			case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+				fmt.Print(line)
+			case strings.HasPrefix(line, "+"):
+				fmt.Print(Green + strings.TrimSuffix(line, "\n") + Nc + "\n")
+			case strings.HasPrefix(line, "-"):
+				fmt.Print(Red + strings.TrimSuffix(line, "\n") + Nc + "\n")
+			case strings.HasPrefix(line, "@@"):
+				fmt.Print(Blue + strings.TrimSuffix(line, "\n") + Nc + "\n")
+			default:
+				fmt.Print(line)
+			}
+		}
+	}
+
+	fmt.Printf("\n%s 将执行 %d 条命令:\n", Tip, len(p.commands))
+	for i, c := range p.commands {
+		fmt.Printf("  %3d. %s\n", i+1, c)
+	}
+}
+
+// finishDryRun 输出计划并退出，仅在 dry-run 模式下生效
+func finishDryRun() {
+	if !dryRun {
+		return
+	}
+	currentPlan.print()
+	os.Exit(0)
+}
+
+// shellJoin 将命令拼接为可直接复制执行的形式
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		if a != "" && !strings.ContainsAny(a, " \t\n'\"$`\\|&;<>()*?!{}[]#~") {
+			quoted[i] = a
+			continue
+		}
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"l2tp/internal/doctor"
+)
+
+// pppRequired 缺失时无法提供 VPN 的模块: 所有协议都依赖 ppp_generic，PPTP 的加密依赖 ppp_mppe。
+// l2tp_ppp 与 af_key 缺失时 xl2tpd 与 strongSwan 仍可使用用户态实现与 netlink 接口
+func pppRequired(cfg *Config) []string {
+	required := []string{"ppp_generic"}
+	if cfg.pptpEnabled() {
+		required = append(required, "ppp_mppe")
+	}
+	return required
+}
+
+// probePPP 检查当前内核对 modules 的支持，load 为 true 时先用 modprobe 加载存在但未加载的模块
+func probePPP(modules, required []string, load bool) *doctor.PPPProbe {
+	release, _ := runCommandOutput("uname", "-r")
+	modDir := filepath.Join("/lib/modules", release)
+	builtin, _ := os.ReadFile(filepath.Join(modDir, "modules.builtin"))
+	dep, _ := os.ReadFile(filepath.Join(modDir, "modules.dep"))
+	files := doctor.ModuleFiles(dep)
+
+	procModules, _ := os.ReadFile("/proc/modules")
+	if load {
+		loaded := false
+		for _, name := range modules {
+			if doctor.StateOf(procModules, builtin, files, name) == doctor.ModuleAvailable {
+				runCommandQuiet("modprobe", name)
+				loaded = true
+			}
+		}
+		if loaded {
+			procModules, _ = os.ReadFile("/proc/modules")
+		}
+	}
+
+	p := &doctor.PPPProbe{
+		Release:   release,
+		Container: inContainer(),
+		Device:    fileExists("/dev/ppp"),
+		Modules:   make(map[string]doctor.ModuleState),
+		Required:  required,
+	}
+	for _, name := range modules {
+		p.Modules[name] = doctor.StateOf(procModules, builtin, files, name)
+	}
+	return p
+}
+
+// inContainer 是否运行在容器中，容器共享宿主机内核，无法加载模块或更换内核
+func inContainer() bool {
+	if fileExists("/.dockerenv") || fileExists("/run/.containerenv") {
+		return true
+	}
+	_, err := runCommandOutput("systemd-detect-virt", "--container")
+	return err == nil
+}
+
+// ensurePPP 安装前确认 PPP 可用: 加载缺少的模块、补建 /dev/ppp，只有必需的模块在当前内核中不存在时才提示更换内核
+func ensurePPP(cfg *Config) error {
+	p := probePPP(doctor.PPPModules, pppRequired(cfg), true)
+	fix, detail := p.Recommend()
+	switch fix {
+	case doctor.FixNone:
+	case doctor.FixDevice:
+		fmt.Printf("%s %s\n", Tip, detail)
+		if err := runCommand("mknod", "-m", "600", "/dev/ppp", "c", "108", "0"); err != nil {
+			return fmt.Errorf("创建 /dev/ppp 失败: %v", err)
+		}
+	case doctor.FixKernel:
+		fmt.Printf("%s 警告: %s，需要更换内核才能使用 PPP。\n", Error, detail)
+		if !askReboot("是否尝试切换到标准内核 (确认新内核可用后才会卸载Cloud内核)?") {
+			if assumeYes {
+				return fmt.Errorf("当前内核不支持 PPP，需要切换内核并重启，请添加 -reboot 参数重新运行")
+			}
+			return fmt.Errorf("用户取消操作，无法继续安装 VPN")
+		}
+		return performKernelSwap()
+	default:
+		return fmt.Errorf("PPP 不可用: %s", detail)
+	}
+
+	// 可选模块缺失时只提示，不影响安装
+	for _, name := range doctor.PPPModules {
+		if p.Modules[name] == doctor.ModuleMissing && !slices.Contains(p.Required, name) {
+			fmt.Printf("%s 提示: 当前内核 %s 不提供 %s，将使用用户态实现\n", Tip, p.Release, name)
+		}
+	}
+	fmt.Printf("%s ✓ PPP 支持检查通过 (内核 %s)\n", Green, p.Release)
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"l2tp/internal/firewall"
+	"l2tp/internal/tproxy"
+)
+
+// 透明代理的 DNS: 客户端的查询经上游代理发往 proxyDNS，代理服务器域名通过 proxy.direct_dns 直接解析，
+// 未配置时使用 proxyDirectDNS。代理在 127.0.0.1:proxyDNSPort 上提供 DNS 入站，分流 dnsmasq 经此解析代理域名
+const (
+	proxyDNS       = "1.1.1.1"
+	proxyDirectDNS = "223.5.5.5"
+	proxyDNSPort   = "5336"
+)
+
+// 下载发布信息与发布包的大小上限
+const (
+	maxReleaseInfo  = 4 << 20
+	maxReleaseAsset = 128 << 20
+)
+
+// setupProxy 按上游代理生成 sing-box 或 Xray 配置并以 l2tp-proxy 服务运行，监听 TPROXY 端口。
+// 未指定上游时只保留分流规则，由用户自行部署代理
+func setupProxy(cfg *Config) error {
+	cfg.Proxy.Upstream = ask(cfg.Proxy.Upstream, "请输入上游代理 (分享链接或 SOCKS 地址 host:port，留空则自行部署代理):", "(默认: 不生成)", "")
+	if cfg.Proxy.Upstream == "" {
+		fmt.Printf("%s 未指定上游代理，请自行在端口 %s 部署支持 TPROXY 的代理\n", Tip, cfg.ProxyPort)
+		if cfg.Routing.domains() {
+			fmt.Printf("%s 代理域名经 127.0.0.1:%s 解析，请在代理中提供该 DNS 入站并经上游转发，否则代理域名无法解析\n", Tip, proxyDNSPort)
+		}
+		return nil
+	}
+	outbound, err := tproxy.ParseLink(cfg.Proxy.Upstream)
+	if err != nil {
+		return err
+	}
+	cfg.Proxy.Engine = ask(cfg.Proxy.Engine, "请选择代理内核 (sing-box / xray):", "(默认: sing-box)", tproxy.SingBox)
+	port, err := strconv.Atoi(cfg.ProxyPort)
+	if err != nil {
+		return fmt.Errorf("透明代理端口无效: %q", cfg.ProxyPort)
+	}
+	dnsPort, _ := strconv.Atoi(proxyDNSPort)
+	// 先生成配置，链接与内核不匹配时不会安装内核
+	var release *tproxy.Release
+	binary := proxyBinary(cfg.Proxy.Engine)
+	if binary == "" {
+		if release, err = tproxy.NewRelease(cfg.Proxy.Engine, cfg.Proxy.Version, runtime.GOARCH); err != nil {
+			return err
+		}
+		binary = release.Path()
+	}
+	files, err := tproxy.Generate(tproxy.Config{
+		Engine:    cfg.Proxy.Engine,
+		Binary:    binary,
+		Port:      port,
+		Private:   firewall.Bypass,
+		DNS:       proxyDNS,
+		DirectDNS: cfg.Proxy.directDNS(),
+		DNSPort:   dnsPort,
+		Outbound:  outbound,
+	})
+	if err != nil {
+		return err
+	}
+
+	if release != nil {
+		if err := installProxy(release, cfg.Proxy.SHA256); err != nil {
+			return fmt.Errorf("安装 %s 失败: %v", cfg.Proxy.Engine, err)
+		}
+	}
+	for _, f := range files {
+		if err := writeServiceFile(f.Path, f.Content, f.Mode); err != nil {
+			return err
+		}
+	}
+	if err := startService(tproxy.ServiceName); err != nil {
+		return err
+	}
+	name := outbound.Server
+	if outbound.Name != "" {
+		name = outbound.Name
+	}
+	fmt.Printf("%s 透明代理已启动: %s -> %s (%s)\n", Green, cfg.Proxy.Engine, name, outbound.Type)
+	return nil
+}
+
+// installProxy 下载固定版本的发布包，按 sum 或 GitHub 发布信息中的 SHA-256 校验后安装可执行文件，
+// 不执行远程脚本
+func installProxy(release *tproxy.Release, sum string) error {
+	fmt.Printf("%s 安装 %s v%s 到 %s...\n", Tip, release.Engine, release.Version, release.Path())
+	if dryRun {
+		currentPlan.addCommand("curl", "-fsSLO", release.URL)
+		currentPlan.addCommand("install", "-m", "0755", release.Member, release.Path())
+		return nil
+	}
+	if sum == "" {
+		info, err := download(release.API, maxReleaseInfo)
+		if err != nil {
+			return err
+		}
+		if sum, err = release.Digest(info); err != nil {
+			return err
+		}
+	}
+	archive, err := download(release.URL, maxReleaseAsset)
+	if err != nil {
+		return err
+	}
+	binary, err := release.Extract(archive, sum)
+	if err != nil {
+		return err
+	}
+	return writeFile(release.Path(), binary, 0755)
+}
+
+// download 读取 url 的响应正文，超过 limit 字节时返回错误
+func download(url string, limit int64) ([]byte, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("下载 %s 失败: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载 %s 失败: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("下载 %s 失败: %v", url, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s 超过 %d MiB", url, limit>>20)
+	}
+	return data, nil
+}
+
+// proxyBinary 返回已安装的内核路径，未安装时返回空
+func proxyBinary(engine string) string {
+	if path, err := exec.LookPath(engine); err == nil {
+		return path
+	}
+	if path := tproxy.DefaultBinary[engine]; fileExists(path) {
+		return path
+	}
+	if path := filepath.Join(tproxy.InstallDir, engine); fileExists(path) {
+		return path
+	}
+	return ""
+}
+
+// removeProxy 停止并删除 l2tp-proxy 服务与配置，保留已安装的内核
+func removeProxy() {
+	if err := removeService(tproxy.ServiceName); err != nil {
+		fmt.Printf("%s 删除 %s 服务失败: %v\n", Tip, tproxy.ServiceName, err)
+	}
+	if err := removeFile(tproxy.ConfigPath); err != nil {
+		fmt.Printf("%s 删除 %s 失败: %v\n", Tip, tproxy.ConfigPath, err)
+	}
+}
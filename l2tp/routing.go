@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"l2tp/internal/nft"
+	"l2tp/internal/split"
+)
+
+// splitDNSPort 分流 dnsmasq 的监听端口，避开 53 与 mDNS 使用的 5353
+const splitDNSPort = "5335"
+
+// setupRouting 配置了域名列表时生成 dnsmasq 配置并启动 l2tp-dns 服务，客户端的 DNS 请求由防火墙重定向到该服务；
+// 未配置时停用旧的服务。网段与 GeoIP 列表已在 setupFirewall 中编译为 nftables 集合
+func setupRouting(cfg *Config) error {
+	if !cfg.Routing.domains() {
+		removeRouting()
+		return nil
+	}
+	compiled, err := split.Compile(cfg.Routing.lists())
+	if err != nil {
+		return err
+	}
+	binary, err := exec.LookPath("dnsmasq")
+	if err != nil {
+		binary = "/usr/sbin/dnsmasq"
+	}
+	// nftset 选项从 dnsmasq 2.87 开始支持，编译时未启用时版本信息中为 no-nftset
+	if out, err := runCommandOutput(binary, "--version"); err == nil && (strings.Contains(out, "no-nftset") || !strings.Contains(out, "nftset")) {
+		return fmt.Errorf("当前 dnsmasq 不支持 nftset (需要 2.87 以上版本)，无法按域名分流")
+	}
+	files, err := split.DNSFiles(compiled, split.DNS{
+		Binary:      binary,
+		Port:        splitDNSPort,
+		Server:      cfg.Proxy.directDNS(),
+		ProxyServer: "127.0.0.1#" + proxyDNSPort,
+		Table:       nft.TProxyTable,
+		BypassSet:   nft.BypassSet,
+		ProxySet:    nft.ProxySet,
+	})
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := writeServiceFile(f.Path, f.Content, f.Mode); err != nil {
+			return err
+		}
+	}
+	// 重新加载防火墙规则后集合已被清空，重启以清空缓存，后续查询重新写入集合
+	if err := startService(split.DNSServiceName); err != nil {
+		return err
+	}
+	fmt.Printf("%s 域名分流已启用: 直连 %d 个域名，代理 %d 个域名\n", Green, len(compiled.BypassDomains), len(compiled.ProxyDomains))
+	return nil
+}
+
+// removeRouting 停止并删除 l2tp-dns 服务与配置
+func removeRouting() {
+	if !fileExists(serviceManager().Path(split.DNSServiceName)) && !fileExists(split.DNSConfigPath) {
+		return
+	}
+	if err := removeService(split.DNSServiceName); err != nil {
+		fmt.Printf("%s 删除 %s 服务失败: %v\n", Tip, split.DNSServiceName, err)
+	}
+	if err := removeFile(split.DNSConfigPath); err != nil {
+		fmt.Printf("%s 删除 %s 失败: %v\n", Tip, split.DNSConfigPath, err)
+	}
+}
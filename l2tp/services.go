@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"l2tp/internal/service"
+)
+
+// serviceManager 主机使用的服务管理器，首次使用时检测，测试中替换
+var serviceManager = sync.OnceValue(func() service.Manager {
+	return service.Detect(service.Commands{Run: runCommand, RunQuiet: runCommandQuiet, Output: runCommandOutput})
+})
+
+// writeServiceFile 写入文件，UnitDir 下的 systemd 单元按主机的服务管理器转换后写入
+func writeServiceFile(path string, data []byte, perm os.FileMode) error {
+	name, ok := strings.CutSuffix(filepath.Base(path), ".service")
+	if !ok || filepath.Dir(path) != service.UnitDir {
+		return writeFile(path, data, perm)
+	}
+	f, err := serviceManager().Install(name, data)
+	if err != nil {
+		return fmt.Errorf("生成 %s 服务失败: %v", name, err)
+	}
+	return writeFile(f.Path, f.Content, f.Mode)
+}
+
+// startService 重新加载服务文件，设置开机启动并重启服务使新配置生效
+func startService(name string) error {
+	m := serviceManager()
+	if err := m.Reload(); err != nil {
+		return fmt.Errorf("重新加载服务配置失败: %v", err)
+	}
+	if err := m.Enable(name); err != nil {
+		return fmt.Errorf("启用服务 %s 失败: %v", name, err)
+	}
+	if err := m.Restart(name); err != nil {
+		return fmt.Errorf("启动服务 %s 失败，查看日志: %s: %v", name, m.Logs(name), err)
+	}
+	return nil
+}
+
+// stopService 停止并禁用服务，服务不存在时不报错
+func stopService(name string) {
+	serviceManager().Disable(name)
+}
+
+// removeService 停止并禁用本工具创建的服务，删除服务文件
+func removeService(name string) error {
+	stopService(name)
+	return removeFile(serviceManager().Path(name))
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"l2tp/internal/firewall"
+)
+
+// stateRoot 每次安装的文件快照保存在该目录下，以时间戳命名
+const stateRoot = "/var/lib/l2tp/state"
+
+// 快照状态
+const (
+	snapshotPending    = "pending"
+	snapshotApplied    = "applied"
+	snapshotRolledBack = "rolled-back"
+)
+
+// fileServices 文件恢复后需要重启的服务，ipsec 会按实际服务名替换
+var fileServices = map[string][]string{
+	"/etc/ipsec.conf":         {"ipsec"},
+	"/etc/ipsec.secrets":      {"ipsec"},
+	"/etc/xl2tpd/xl2tpd.conf": {"xl2tpd"},
+	"/etc/ppp/options.xl2tpd": {"xl2tpd"},
+	"/etc/pptpd.conf":         {"pptpd"},
+	"/etc/ppp/pptpd-options":  {"pptpd"},
+	"/etc/ppp/chap-secrets":   {"xl2tpd", "pptpd"},
+
+	"/etc/ipsec.d/cacerts/l2tp-ca.crt": {"ipsec"},
+	"/etc/ipsec.d/certs/server.crt":    {"ipsec"},
+	"/etc/ipsec.d/private/server.key":  {"ipsec"},
+	"/etc/ipsec.d/crls/l2tp-ca.crl":    {"ipsec"},
+
+	"/etc/l2tp/ipv6-ra.conf":                   {"l2tp-ipv6-ra"},
+	"/etc/systemd/system/l2tp-ipv6-ra.service": {"l2tp-ipv6-ra"},
+
+	"/etc/systemd/system/l2tp-tproxy.service":   {"l2tp-tproxy"},
+	"/etc/l2tp/iptables.sh":                     {"l2tp-iptables"},
+	"/etc/systemd/system/l2tp-iptables.service": {"l2tp-iptables"},
+	"/etc/l2tp/proxy.json":                      {"l2tp-proxy"},
+	"/etc/systemd/system/l2tp-proxy.service":    {"l2tp-proxy"},
+	"/etc/l2tp/dnsmasq.conf":                    {"l2tp-dns"},
+	"/etc/systemd/system/l2tp-dns.service":      {"l2tp-dns"},
+
+	// OpenRC 与 SysV 主机上由单元转换的 init 脚本
+	"/etc/init.d/l2tp-ipv6-ra":  {"l2tp-ipv6-ra"},
+	"/etc/init.d/l2tp-tproxy":   {"l2tp-tproxy"},
+	"/etc/init.d/l2tp-iptables": {"l2tp-iptables"},
+	"/etc/init.d/l2tp-proxy":    {"l2tp-proxy"},
+	"/etc/init.d/l2tp-dns":      {"l2tp-dns"},
+}
+
+// snapshotFile 一个被修改文件的原始状态
+type snapshotFile struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	Mode    os.FileMode `json:"mode,omitempty"`
+	Backup  string      `json:"backup,omitempty"`
+}
+
+// snapshot 一次运行的快照清单，保存为 manifest.json
+type snapshot struct {
+	ID       string         `json:"id"`
+	Created  time.Time      `json:"created"`
+	Status   string         `json:"status"`
+	Files    []snapshotFile `json:"files"`
+	Services []string       `json:"services"`
+}
+
+// transaction 当前运行中的事务，writeFile 在首次写入某个文件前自动备份
+type transaction struct {
+	dir     string
+	snap    snapshot
+	tracked map[string]bool
+}
+
+var currentTxn *transaction
+
+// beginTransaction 创建新的快照目录，dry-run 模式下不创建
+func beginTransaction() (*transaction, error) {
+	if dryRun {
+		return nil, nil
+	}
+
+	id := time.Now().Format("20060102-150405")
+	dir := filepath.Join(stateRoot, id)
+	for i := 1; dirExists(dir); i++ {
+		dir = filepath.Join(stateRoot, fmt.Sprintf("%s-%d", id, i))
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0700); err != nil {
+		return nil, fmt.Errorf("创建快照目录失败: %v", err)
+	}
+
+	t := &transaction{
+		dir:     dir,
+		snap:    snapshot{ID: filepath.Base(dir), Created: time.Now(), Status: snapshotPending},
+		tracked: make(map[string]bool),
+	}
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("%s 本次变更快照: %s\n", Tip, t.snap.ID)
+	return t, nil
+}
+
+// track 记录文件修改前的内容，同一文件只备份一次
+func (t *transaction) track(path string) error {
+	if t == nil || t.tracked[path] {
+		return nil
+	}
+	t.tracked[path] = true
+
+	entry := snapshotFile{Path: path}
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("备份 %s 失败: %v", path, err)
+		}
+		entry.Existed = true
+		entry.Mode = info.Mode().Perm()
+		entry.Backup = fmt.Sprintf("%04d", len(t.snap.Files))
+		if err := os.WriteFile(filepath.Join(t.dir, "files", entry.Backup), content, 0600); err != nil {
+			return fmt.Errorf("备份 %s 失败: %v", path, err)
+		}
+	}
+
+	t.snap.Files = append(t.snap.Files, entry)
+	for _, svc := range fileServices[path] {
+		if !slices.Contains(t.snap.Services, svc) {
+			t.snap.Services = append(t.snap.Services, svc)
+		}
+	}
+	return t.save()
+}
+
+func (t *transaction) save() error {
+	data, err := json.MarshalIndent(t.snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(t.dir, "manifest.json"), data, 0600); err != nil {
+		return fmt.Errorf("保存快照清单失败: %v", err)
+	}
+	return nil
+}
+
+// commit 标记本次变更已成功应用，快照保留供之后手动回滚
+func (t *transaction) commit() error {
+	if t == nil {
+		return nil
+	}
+	t.snap.Status = snapshotApplied
+	return t.save()
+}
+
+// rollback 恢复本次事务修改过的所有文件并重启相关服务
+func (t *transaction) rollback() error {
+	if t == nil {
+		return nil
+	}
+	fmt.Printf("%s 正在回滚本次变更 (快照 %s)...\n", Tip, t.snap.ID)
+	if err := restoreSnapshot(t.dir, &t.snap); err != nil {
+		return err
+	}
+	return t.save()
+}
+
+// withTransaction 在新事务中执行 fn，失败时自动回滚
+func withTransaction(fn func() error) error {
+	txn, err := beginTransaction()
+	if err != nil {
+		return err
+	}
+	currentTxn = txn
+	defer func() { currentTxn = nil }()
+
+	if err := fn(); err != nil {
+		if rbErr := txn.rollback(); rbErr != nil {
+			fmt.Printf("%s 自动回滚失败: %v\n", Error, rbErr)
+		}
+		return err
+	}
+	return txn.commit()
+}
+
+// loadSnapshot 读取快照，id 为空时取最近一次
+func loadSnapshot(id string) (string, *snapshot, error) {
+	ids, err := listSnapshots()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(ids) == 0 {
+		return "", nil, fmt.Errorf("没有可用的快照 (%s)", stateRoot)
+	}
+	if id == "" {
+		id = ids[len(ids)-1]
+	}
+	if !slices.Contains(ids, id) {
+		return "", nil, fmt.Errorf("快照 %s 不存在，可用快照: %s", id, strings.Join(ids, ", "))
+	}
+
+	dir := filepath.Join(stateRoot, id)
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return "", nil, fmt.Errorf("读取快照清单失败: %v", err)
+	}
+	snap := &snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return "", nil, fmt.Errorf("解析快照清单失败: %v", err)
+	}
+	return dir, snap, nil
+}
+
+// listSnapshots 按时间顺序返回全部快照 ID
+func listSnapshots() ([]string, error) {
+	entries, err := os.ReadDir(stateRoot)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() && fileExists(filepath.Join(stateRoot, e.Name(), "manifest.json")) {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// rollbackSnapshot 手动回滚到指定快照之前的状态
+func rollbackSnapshot(id string) error {
+	dir, snap, err := loadSnapshot(id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s 回滚快照 %s (创建于 %s，状态 %s，%d 个文件)\n", Tip, snap.ID, snap.Created.Format("2006-01-02 15:04:05"), snap.Status, len(snap.Files))
+	if err := restoreSnapshot(dir, snap); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0600)
+}
+
+// restartServices 回滚后需要重启的服务: 只重启配置在快照前已存在的服务，
+// 之前不存在的配置已被删除，对应的服务原本就没有安装或运行
+func restartServices(snap *snapshot) []string {
+	var services []string
+	for _, f := range snap.Files {
+		if !f.Existed {
+			continue
+		}
+		for _, svc := range fileServices[f.Path] {
+			if slices.Contains(snap.Services, svc) && !slices.Contains(services, svc) {
+				services = append(services, svc)
+			}
+		}
+	}
+	return services
+}
+
+// restoreSnapshot 按逆序恢复文件，之前不存在的文件直接删除，最后重启受影响的服务
+func restoreSnapshot(dir string, snap *snapshot) error {
+	var failed []string
+	for i := len(snap.Files) - 1; i >= 0; i-- {
+		f := snap.Files[i]
+		if !f.Existed {
+			if err := removeFile(f.Path); err != nil {
+				fmt.Printf("%s 删除 %s 失败: %v\n", Error, f.Path, err)
+				failed = append(failed, f.Path)
+			}
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, "files", f.Backup))
+		if err == nil {
+			err = writeFile(f.Path, content, f.Mode)
+		}
+		if err == nil && !dryRun {
+			// 已存在的文件写入时不会改变权限，这里显式还原；/proc 下的文件无法修改权限，忽略错误
+			os.Chmod(f.Path, f.Mode)
+		}
+		if err != nil {
+			fmt.Printf("%s 恢复 %s 失败: %v\n", Error, f.Path, err)
+			failed = append(failed, f.Path)
+			continue
+		}
+		fmt.Printf("  已恢复 %s\n", f.Path)
+	}
+
+	for _, f := range snap.Files {
+		switch f.Path {
+		case "/etc/sysctl.conf":
+			runCommand("sysctl", "-p")
+		case sysctlDropIn:
+			applySysctl()
+		case firewall.NFTRulesPath:
+			// 不能重启 nftables 服务，发行版配置中的 flush ruleset 会清空其他规则
+			reloadNftables()
+		case firewall.UFWBeforeRules, firewall.UFWBefore6Rules:
+			runCommandQuiet("ufw", "reload")
+		}
+	}
+	for _, svc := range restartServices(snap) {
+		if svc == "ipsec" {
+			svc = ipsecServiceName()
+		}
+		if err := serviceManager().Restart(svc); err != nil {
+			fmt.Printf("%s 警告: 重启 %s 失败: %v\n", Tip, svc, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("以下文件恢复失败: %s", strings.Join(failed, ", "))
+	}
+	snap.Status = snapshotRolledBack
+	fmt.Printf("%s 回滚完成\n", Green)
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"l2tp/internal/chap"
+	"l2tp/internal/session"
+)
+
+func statusUsage() {
+	fmt.Println(`用法: l2tp status [选项]
+
+列出在线的 L2TP、PPTP 与 IKEv2 客户端：用户名、协议、PPP 接口、内网 IP、
+客户端地址、在线时长与收发流量。
+
+选项:
+  -json                     输出 JSON`)
+}
+
+func kickUsage() {
+	fmt.Println(`用法: l2tp kick <用户名>
+
+断开该用户的全部会话：结束对应的 pppd 进程，IKEv2 会话通过 ipsec down 断开。
+账号本身不受影响，需要禁止再次登录时使用 l2tp user disable。`)
+}
+
+// runStatusCommand 处理 l2tp status 子命令
+func runStatusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.Usage = statusUsage
+	jsonOut := fs.Bool("json", false, "输出 JSON")
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		statusUsage()
+		return fmt.Errorf("未知参数: %s", strings.Join(positional, " "))
+	}
+
+	sessions := collectSessions()
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if sessions == nil {
+			sessions = []session.Session{}
+		}
+		return enc.Encode(sessions)
+	}
+	if len(sessions) == 0 {
+		fmt.Println("当前没有在线用户")
+		return nil
+	}
+	session.Write(os.Stdout, sessions, time.Now())
+	fmt.Printf("\n共 %d 个在线会话\n", len(sessions))
+	return nil
+}
+
+// runKickCommand 处理 l2tp kick 子命令
+func runKickCommand(args []string) error {
+	if len(args) != 1 || strings.HasPrefix(args[0], "-") {
+		kickUsage()
+		return fmt.Errorf("需要指定一个用户名")
+	}
+	user := args[0]
+
+	var kicked int
+	for _, s := range collectSessions() {
+		if s.User != user {
+			continue
+		}
+		if s.PID > 0 {
+			// pppd 收到 SIGTERM 后发送 LCP TermReq 并退出，xl2tpd/pptpd 随之关闭隧道
+			if err := syscall.Kill(s.PID, syscall.SIGTERM); err != nil {
+				return fmt.Errorf("结束 %s 的 pppd 进程 %d 失败: %v", s.Interface, s.PID, err)
+			}
+		} else if s.SA != "" {
+			if err := runCommand("ipsec", "down", s.SA); err != nil {
+				return fmt.Errorf("断开 %s 失败: %v", s.SA, err)
+			}
+		} else {
+			continue
+		}
+		fmt.Printf("%s 已断开 %s %s\n", Info, user, valueOr(s.Interface, s.SA))
+		kicked++
+	}
+	if kicked == 0 {
+		return fmt.Errorf("用户 %s 当前不在线", user)
+	}
+	return nil
+}
+
+// collectSessions 汇总 PPP 会话与 IKEv2 会话，按在线时长排序
+func collectSessions() []session.Session {
+	sessions := pppSessions()
+	if out, err := runCommandOutput("ipsec", "statusall"); err == nil {
+		sessions = append(sessions, session.ParseIPsec(out, time.Now())...)
+	}
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Since.Before(sessions[j].Since) })
+	return sessions
+}
+
+// pppSessions 由 pppd 的 pid 文件找出在线的 PPP 接口。pppd 在接口建立后写入
+// /run/pppN.pid，第一行为进程号，断开时删除
+func pppSessions() []session.Session {
+	var pidFiles []string
+	for _, dir := range []string{"/run", "/var/run"} {
+		matches, _ := filepath.Glob(filepath.Join(dir, "ppp[0-9]*.pid"))
+		pidFiles = append(pidFiles, matches...)
+	}
+
+	netDev, _ := os.ReadFile("/proc/net/dev")
+	traffic := session.ParseNetDev(netDev)
+	addrs, _ := runCommandOutput("ip", "-o", "addr", "show")
+	peers := session.ParsePeers(addrs)
+	procStat, _ := os.ReadFile("/proc/stat")
+	boot, bootErr := session.ParseBootTime(procStat)
+	secrets, _ := readChapSecrets()
+
+	var sessions []session.Session
+	var logUsers map[int]string
+	seen := make(map[string]bool)
+	for _, pidFile := range pidFiles {
+		iface := strings.TrimSuffix(filepath.Base(pidFile), ".pid")
+		// /var/run 通常是指向 /run 的链接
+		if seen[iface] {
+			continue
+		}
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			continue
+		}
+		first, _, _ := strings.Cut(string(data), "\n")
+		pid, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+		if err != nil {
+			// 进程已退出，pid 文件尚未清理
+			continue
+		}
+		seen[iface] = true
+
+		s := session.Session{Interface: iface, PID: pid, IP: peers[iface]}
+		s.Protocol, s.Remote = session.ParseCmdline(cmdline)
+		if t, ok := traffic[iface]; ok {
+			s.RX, s.TX = t.RX, t.TX
+		}
+		if stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid)); err == nil && bootErr == nil {
+			s.Since, _ = session.ParseStartTime(stat, boot)
+		}
+
+		// 分配了静态 IP 的账号可直接由 chap-secrets 对应，其余从 pppd 的认证日志中查找
+		s.User = staticIPUser(secrets, s.Protocol, s.IP)
+		if s.User == "" {
+			if logUsers == nil {
+				logUsers = session.ParsePPPLog(pppLogs())
+			}
+			s.User = logUsers[pid]
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// staticIPUser 查找分配了该静态 IP 的账号
+func staticIPUser(secrets *chap.File, protocol, ip string) string {
+	if secrets == nil || ip == "" {
+		return ""
+	}
+	server := map[string]string{session.L2TP: "l2tpd", session.PPTP: "pptpd"}[protocol]
+	for _, e := range secrets.Entries() {
+		if !e.Disabled && e.IP() == ip && (server == "" || e.Server == server) {
+			return e.Client
+		}
+	}
+	return ""
+}
+
+// pppLogs 读取 pppd 日志，优先 journald，其次 syslog 写入的日志文件
+func pppLogs() string {
+	if out, err := runCommandOutput("journalctl", "-t", "pppd", "--no-pager", "-o", "short", "-b"); err == nil && out != "" {
+		return out
+	}
+	var sb strings.Builder
+	for _, path := range []string{"/var/log/messages", "/var/log/syslog", "/var/log/daemon.log"} {
+		if data, err := os.ReadFile(path); err == nil {
+			sb.Write(data)
+		}
+	}
+	return sb.String()
+}
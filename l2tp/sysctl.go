@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sysctlDropIn 本工具独立的 sysctl 配置，不再修改 /etc/sysctl.conf；编号 60 晚于发行版默认配置
+const sysctlDropIn = "/etc/sysctl.d/60-l2tp-vpn.conf"
+
+// sysctlDirs sysctl --system 与 systemd-sysctl 读取的目录，同名文件以靠前目录中的为准
+var sysctlDirs = []string{"/etc/sysctl.d", "/run/sysctl.d", "/usr/local/lib/sysctl.d", "/usr/lib/sysctl.d", "/lib/sysctl.d"}
+
+func setupSysctl(cfg *Config) error {
+	configs := map[string]string{
+		"net.ipv4.ip_forward":                    "1",
+		"net.ipv4.conf.all.send_redirects":       "0",
+		"net.ipv4.conf.default.send_redirects":   "0",
+		"net.ipv4.conf.all.accept_redirects":     "0",
+		"net.ipv4.conf.default.accept_redirects": "0",
+	}
+	if cfg.IPv6.Enabled {
+		configs["net.ipv6.conf.all.forwarding"] = "1"
+		configs["net.ipv6.conf.default.forwarding"] = "1"
+		// 开启转发后内核默认不再接受 RA，云主机的 IPv6 通常依赖 SLAAC，出口网卡需保持接受
+		configs[fmt.Sprintf("net.ipv6.conf.%s.accept_ra", defaultInterface(true))] = "2"
+	}
+
+	fmt.Println(Tip, "正在配置 Sysctl 参数...")
+	// 记录运行时原值，回滚时一并恢复
+	for key := range configs {
+		if err := currentTxn.track(sysctlPath(key)); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(sysctlDropIn, renderSysctl(configs), 0644); err != nil {
+		return fmt.Errorf("写入 %s 失败: %v", sysctlDropIn, err)
+	}
+	if err := applySysctl(); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	return verifySysctl(configs, "/")
+}
+
+// renderSysctl 按键排序生成 drop-in 内容，重复安装时内容不变
+func renderSysctl(configs map[string]string) []byte {
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("# 由 l2tp 生成，卸载时删除，请勿手动修改\n")
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "%s = %s\n", key, configs[key])
+	}
+	return []byte(sb.String())
+}
+
+// applySysctl 按系统顺序重新加载全部 sysctl 配置。BusyBox 的 sysctl 不支持 --system，此时只加载本工具的文件
+func applySysctl() error {
+	if err := runCommandQuiet("sysctl", "--system"); err == nil {
+		return nil
+	}
+	return runCommand("sysctl", "-p", sysctlDropIn)
+}
+
+// verifySysctl 从 /proc/sys 读回运行时的值，确认配置已生效。root 为文件系统根目录，便于测试
+func verifySysctl(configs map[string]string, root string) error {
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		want := configs[key]
+		data, err := os.ReadFile(filepath.Join(root, sysctlPath(key)))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: 无法读取 (%v)", key, err))
+			continue
+		}
+		got := strings.Join(strings.Fields(string(data)), " ")
+		if got == want {
+			continue
+		}
+		problem := fmt.Sprintf("%s = %s，期望 %s", key, got, want)
+		if file := sysctlOverride(root, key, want); file != "" {
+			problem += "，被 " + file + " 覆盖"
+		}
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("sysctl 参数未生效:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// sysctlOverride 返回在本工具的 drop-in 之后加载、并将 key 设为其他值的配置文件。
+// 文件按文件名排序加载，/etc/sysctl.conf 最后加载
+func sysctlOverride(root, key, want string) string {
+	files := make(map[string]string)
+	for i := len(sysctlDirs) - 1; i >= 0; i-- {
+		matches, _ := filepath.Glob(filepath.Join(root, sysctlDirs[i], "*.conf"))
+		for _, path := range matches {
+			files[filepath.Base(path)] = path
+		}
+	}
+	var names []string
+	for name := range files {
+		if name > filepath.Base(sysctlDropIn) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	paths := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		paths = append(paths, files[name])
+	}
+	paths = append(paths, filepath.Join(root, "/etc/sysctl.conf"))
+
+	var override string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if value, ok := parseSysctl(data)[key]; ok {
+			if value == want {
+				override = ""
+			} else {
+				override = strings.TrimPrefix(path, strings.TrimSuffix(root, "/"))
+			}
+		}
+	}
+	return override
+}
+
+// parseSysctl 解析 sysctl 配置文件，键中的 / 与 . 等价，行首的 - 表示忽略错误
+func parseSysctl(data []byte) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(key), "-"), "/", ".")
+		values[key] = strings.Join(strings.Fields(value), " ")
+	}
+	return values
+}
+
+// sysctlPath 返回 sysctl 键对应的 /proc/sys 路径
+func sysctlPath(key string) string {
+	return "/proc/sys/" + strings.ReplaceAll(key, ".", "/")
+}
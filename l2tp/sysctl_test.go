@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderSysctl(t *testing.T) {
+	got := string(renderSysctl(map[string]string{
+		"net.ipv4.ip_forward":              "1",
+		"net.ipv4.conf.all.send_redirects": "0",
+	}))
+	want := "# 由 l2tp 生成，卸载时删除，请勿手动修改\nnet.ipv4.conf.all.send_redirects = 0\nnet.ipv4.ip_forward = 1\n"
+	if got != want {
+		t.Errorf("drop-in 内容不一致\n--- 期望 ---\n%s--- 实际 ---\n%s", want, got)
+	}
+}
+
+func TestVerifySysctl(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(sysctlPath("net.ipv4.ip_forward"), "0\n")
+	write(sysctlPath("net.ipv4.conf.all.send_redirects"), "0\n")
+	write(sysctlPath("net.ipv6.conf.all.forwarding"), "0\n")
+	write(sysctlDropIn, "net.ipv4.ip_forward = 1\nnet.ipv6.conf.all.forwarding = 1\n")
+	// 编号更大的文件关闭了 IPv4 转发；/usr/lib 中的同名文件被 /etc 中的覆盖，不应被报告
+	write("/etc/sysctl.d/99-hardening.conf", "# 加固\nnet/ipv4/ip_forward=0\n")
+	write("/usr/lib/sysctl.d/70-vendor.conf", "net.ipv6.conf.all.forwarding = 0\n")
+	write("/etc/sysctl.d/70-vendor.conf", "net.ipv6.conf.all.forwarding = 1\n")
+
+	configs := map[string]string{
+		"net.ipv4.ip_forward":              "1",
+		"net.ipv4.conf.all.send_redirects": "0",
+		"net.ipv6.conf.all.forwarding":     "1",
+	}
+	err := verifySysctl(configs, root)
+	if err == nil {
+		t.Fatal("参数未生效时应返回错误")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "net.ipv4.ip_forward = 0，期望 1，被 /etc/sysctl.d/99-hardening.conf 覆盖") {
+		t.Errorf("未指出覆盖的文件: %s", msg)
+	}
+	if strings.Contains(msg, "70-vendor.conf") || strings.Contains(msg, "send_redirects") {
+		t.Errorf("报告了无关的文件或参数: %s", msg)
+	}
+
+	write(sysctlPath("net.ipv4.ip_forward"), "1\n")
+	write(sysctlPath("net.ipv6.conf.all.forwarding"), "1\n")
+	if err := verifySysctl(configs, root); err != nil {
+		t.Errorf("参数已生效: %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"l2tp/internal/firewall"
+	"l2tp/internal/render"
+)
+
+// installManifestPath 安装清单，记录本工具新安装的软件包；修改过的文件记录在 stateRoot 下的快照中
+const installManifestPath = "/var/lib/l2tp/install.json"
+
+// installManifest 安装清单，卸载时按清单使用相同的包管理器删除软件包
+type installManifest struct {
+	OS             string `json:"os"`
+	PackageManager string `json:"package_manager"`
+	// Packages 安装前不存在、由本工具安装的软件包，已有的软件包卸载时保留
+	Packages []string `json:"packages"`
+	// FirewalldMasquerade 第一次安装前 firewalld 默认区域是否已开启地址伪装，未记录时卸载也保留
+	FirewalldMasquerade *bool `json:"firewalld_masquerade,omitempty"`
+}
+
+// packageManager 一种包管理器的命令
+type packageManager struct {
+	Update  []string
+	Install []string
+	Remove  []string
+	// Purge 同时删除配置文件，不支持时与 Remove 相同
+	Purge []string
+	// Query 查询软件包是否已安装，installed 判断输出
+	Query     []string
+	installed func(out string) bool
+}
+
+// packageManagers 支持的包管理器，发行版使用的包管理器见 osinfo.Distros；yum 用于卸载旧版本在 CentOS 7 上的安装
+var packageManagers = map[string]packageManager{
+	"apt": {
+		Update:    []string{"apt", "update", "-y", "-q"},
+		Install:   []string{"apt", "install", "-y", "-q"},
+		Remove:    []string{"apt", "remove", "-y", "-q"},
+		Purge:     []string{"apt", "purge", "-y", "-q"},
+		Query:     []string{"dpkg-query", "-W", "-f=${Status}"},
+		installed: func(out string) bool { return strings.Contains(out, "install ok installed") },
+	},
+	"dnf": {
+		Update:  []string{"dnf", "update", "-y", "-q"},
+		Install: []string{"dnf", "install", "-y", "-q"},
+		Remove:  []string{"dnf", "remove", "-y", "-q"},
+		Purge:   []string{"dnf", "remove", "-y", "-q"},
+		Query:   []string{"rpm", "-q"},
+	},
+	"yum": {
+		Update:  []string{"yum", "update", "-y", "-q"},
+		Install: []string{"yum", "install", "-y", "-q"},
+		Remove:  []string{"yum", "remove", "-y", "-q"},
+		Purge:   []string{"yum", "remove", "-y", "-q"},
+		Query:   []string{"rpm", "-q"},
+	},
+	"apk": {
+		Update:  []string{"apk", "update", "-f", "-q"},
+		Install: []string{"apk", "add", "-f", "-q"},
+		Remove:  []string{"apk", "del", "-q"},
+		Purge:   []string{"apk", "del", "--purge", "-q"},
+		Query:   []string{"apk", "info", "-e"},
+	},
+}
+
+// missing 返回尚未安装的软件包
+func (pm packageManager) missing(packages []string) []string {
+	var missing []string
+	for _, pkg := range packages {
+		out, err := runCommandOutput(pm.Query[0], append(pm.Query[1:], pkg)...)
+		if err != nil || (pm.installed != nil && !pm.installed(out)) {
+			missing = append(missing, pkg)
+		}
+	}
+	return missing
+}
+
+// loadInstallManifest 读取安装清单
+func loadInstallManifest() (*installManifest, error) {
+	data, err := os.ReadFile(installManifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("未找到安装清单 %s", installManifestPath)
+	}
+	m := &installManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("解析安装清单失败: %v", err)
+	}
+	return m, nil
+}
+
+// recordPackages 将本次新安装的软件包合并到安装清单，dry-run 模式下不写入
+func recordPackages(osID, manager string, packages []string) error {
+	if dryRun {
+		return nil
+	}
+	m, err := loadInstallManifest()
+	if err != nil {
+		m = &installManifest{}
+	}
+	m.OS, m.PackageManager = osID, manager
+	for _, pkg := range packages {
+		if !slices.Contains(m.Packages, pkg) {
+			m.Packages = append(m.Packages, pkg)
+		}
+	}
+	return saveInstallManifest(m)
+}
+
+// recordMasquerade 第一次下发 firewalld 规则前记录默认区域原有的地址伪装状态，dry-run 模式下不写入。
+// 重复安装时地址伪装已由本工具开启，保留第一次的记录
+func recordMasquerade(fw firewall.Firewall) error {
+	if dryRun || fw.Name() != firewall.Firewalld {
+		return nil
+	}
+	m, err := loadInstallManifest()
+	if err != nil {
+		m = &installManifest{}
+	}
+	if m.FirewalldMasquerade != nil {
+		return nil
+	}
+	enabled := firewall.Masquerading(fw)
+	m.FirewalldMasquerade = &enabled
+	return saveInstallManifest(m)
+}
+
+// keepMasquerade 卸载时是否保留 firewalld 的地址伪装: 只有记录为本工具开启时才关闭
+func (m *installManifest) keepMasquerade() bool {
+	return m.FirewalldMasquerade == nil || *m.FirewalldMasquerade
+}
+
+func saveInstallManifest(m *installManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(installManifestPath), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(installManifestPath, data, 0600)
+}
+
+// originalFile 文件在本工具第一次修改之前的状态
+type originalFile struct {
+	snapshotFile
+	dir string
+}
+
+// loadOriginals 从 root 下的全部快照中找出每个文件最早的备份，即安装前的状态，按首次修改的顺序返回
+func loadOriginals(root string) ([]originalFile, error) {
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+
+	var originals []originalFile
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		dir := filepath.Join(root, id)
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+		if err != nil {
+			continue
+		}
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("解析快照 %s 失败: %v", id, err)
+		}
+		for _, f := range snap.Files {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				originals = append(originals, originalFile{f, dir})
+			}
+		}
+	}
+	return originals, nil
+}
+
+// isVPNConfig 是否为 VPN 配置、账号或证书，--keep-config 时保留这些文件
+func isVPNConfig(path string) bool {
+	if path == installedConfigPath || path == chapSecretsPath {
+		return true
+	}
+	for _, prefix := range []string{"/etc/ipsec.", ipsecDir + "/", "/etc/xl2tpd/", "/etc/ppp/options.xl2tpd", "/etc/ppp/pptpd-options", "/etc/pptpd.conf"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBootConfig 是否为切换内核时修改的引导配置，卸载时不恢复
+func isBootConfig(path string) bool {
+	return path == grubDefaultPath || strings.HasPrefix(path, "/boot/")
+}
+
+// restoreOriginals 将文件恢复到安装前的状态，安装前不存在的文件删除。/proc/sys 下的条目即 sysctl 运行时参数
+func restoreOriginals(originals []originalFile, keepConfig bool) []string {
+	var failed []string
+	for i := len(originals) - 1; i >= 0; i-- {
+		f := originals[i]
+		if keepConfig && isVPNConfig(f.Path) {
+			continue
+		}
+		// 切换内核后 Cloud 内核已删除，恢复切换前的 GRUB 配置会导致无法启动
+		if isBootConfig(f.Path) {
+			continue
+		}
+		var err error
+		if f.Existed {
+			var content []byte
+			content, err = os.ReadFile(filepath.Join(f.dir, "files", f.Backup))
+			if err == nil {
+				err = writeFile(f.Path, content, f.Mode)
+			}
+			if err == nil && !dryRun {
+				// /proc 下的文件无法修改权限，忽略错误
+				os.Chmod(f.Path, f.Mode)
+			}
+		} else {
+			err = removeFile(f.Path)
+		}
+		if err != nil {
+			fmt.Printf("%s 恢复 %s 失败: %v\n", Error, f.Path, err)
+			failed = append(failed, f.Path)
+		}
+	}
+	return failed
+}
+
+// uninstallService 按安装清单与快照卸载: 停止服务，删除防火墙与策略路由，将修改过的文件与 sysctl 参数恢复到安装前，
+// 再用安装时的包管理器删除本工具安装的软件包。keepConfig 为 true 时保留 VPN 配置、账号与证书，软件包只删除不清除配置。
+// 有等待确认的内核切换时不卸载，其回滚依赖快照与 GRUB 配置
+func uninstallService(port string, keepConfig bool) error {
+	if fileExists(kernelSwapPath) {
+		return fmt.Errorf("有等待确认的内核切换，请先运行 l2tp kernel finalize 或 l2tp kernel revert 再卸载")
+	}
+	fmt.Printf("%s 正在卸载服务...\n", Tip)
+
+	cfg, err := loadInstalledConfig()
+	if err != nil {
+		cfg = &Config{}
+	}
+	manifest, err := loadInstallManifest()
+	if err != nil {
+		// 旧版本安装时没有清单，按当前发行版删除 VPN 软件包
+		fmt.Printf("%s %v，按当前系统卸载 VPN 软件包\n", Tip, err)
+		manifest = &installManifest{Packages: vpnPackages(cfg)}
+		if sys, err := detectOS(); err == nil {
+			manifest.OS, manifest.PackageManager = sys.ID, sys.Manager()
+			manifest.Packages = sys.Packages(manifest.Packages...)
+		}
+	}
+	originals, err := loadOriginals(stateRoot)
+	if err != nil {
+		fmt.Printf("%s 读取快照失败，配置文件不会被恢复: %v\n", Error, err)
+	}
+
+	// 停止并禁用服务，包括快照中记录的本工具创建的服务
+	services, _ := vpnServices(cfg)
+	services = append(services, "xl2tpd", "pptpd", "strongswan-starter", "strongswan", "ipsec", render.RAServiceName)
+	for _, f := range originals {
+		services = append(services, fileServices[f.Path]...)
+	}
+	slices.Sort(services)
+	for _, svc := range slices.Compact(services) {
+		stopService(svc)
+	}
+
+	// 只删除本工具的防火墙规则，其他规则保持不变；透明代理规则按输入的端口一并清理
+	cfg.ProxyPort, cfg.TProxy = port, true
+	removeFirewall(cfg, manifest.keepMasquerade())
+	removeTProxy()
+	removeRouting()
+	removeProxy()
+	// sysctl drop-in、IPv6 的 ip-up 脚本与 RA 服务不属于任何软件包，没有快照时也要删除；
+	// 运行时的 sysctl 参数随后由快照恢复
+	for _, path := range []string{sysctlDropIn, "/etc/ppp/ip-up.d/l2tp-vpn-ipv6", "/etc/l2tp/ipv6-ra.conf", serviceManager().Path(render.RAServiceName)} {
+		if err := removeFile(path); err != nil {
+			fmt.Printf("%s 删除 %s 失败: %v\n", Tip, path, err)
+		}
+	}
+
+	failed := restoreOriginals(originals, keepConfig)
+	if err := serviceManager().Reload(); err != nil {
+		fmt.Printf("%s 重新加载服务配置失败: %v\n", Error, err)
+	}
+
+	if pm, ok := packageManagers[manifest.PackageManager]; ok && len(manifest.Packages) > 0 {
+		cmd := pm.Purge
+		if keepConfig {
+			cmd = pm.Remove
+		}
+		if err := runCommand(cmd[0], append(cmd[1:], manifest.Packages...)...); err != nil {
+			fmt.Printf("%s 删除软件包失败: %v\n", Error, err)
+		}
+	} else {
+		fmt.Printf("%s 未知的包管理器 %q，请手动删除软件包: %s\n", Tip, manifest.PackageManager, strings.Join(manifest.Packages, " "))
+	}
+
+	if len(failed) > 0 {
+		fmt.Printf("%s 以下文件未能恢复，快照保留在 %s: %s\n", Error, stateRoot, strings.Join(failed, ", "))
+		return nil
+	}
+	if keepConfig {
+		fmt.Printf("%s 卸载完成，已保留 VPN 配置、账号与证书\n", Green)
+		return nil
+	}
+	// 快照与清单只对本次安装有效，重新安装时重新记录；/var/lib/l2tp 下的其他文件保留
+	if err := removeFile(installManifestPath); err != nil {
+		fmt.Printf("%s 删除 %s 失败: %v\n", Tip, installManifestPath, err)
+	}
+	removeAll(stateRoot)
+	removeEmptyDir(filepath.Dir(installManifestPath))
+	removeAll(filepath.Dir(installedConfigPath))
+	fmt.Printf("%s 卸载完成\n", Green)
+	return nil
+}
+
+// removeEmptyDir 目录为空时删除
+func removeEmptyDir(path string) {
+	if dryRun {
+		currentPlan.addCommand("rmdir", "--ignore-fail-on-non-empty", path)
+		return
+	}
+	if entries, err := os.ReadDir(path); err == nil && len(entries) == 0 {
+		os.Remove(path)
+	}
+}
+
+// removeAll 删除目录，dry-run 模式下只记录命令
+func removeAll(path string) {
+	if dryRun {
+		currentPlan.addCommand("rm", "-rf", path)
+		return
+	}
+	if err := os.RemoveAll(path); err != nil {
+		fmt.Printf("%s 删除 %s 失败: %v\n", Tip, path, err)
+	}
+}
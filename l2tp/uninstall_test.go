@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeSnapshot 在 root 下写入一个已提交的快照，backups 为备份文件名到内容的映射
+func writeSnapshot(t *testing.T, root, id string, files []snapshotFile, backups map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, id)
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range backups {
+		if err := os.WriteFile(filepath.Join(dir, "files", name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := json.Marshal(snapshot{ID: id, Files: files})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadOriginals(t *testing.T) {
+	root, etc := t.TempDir(), t.TempDir()
+	sysctl := filepath.Join(etc, "sysctl.conf")
+	unit := filepath.Join(etc, "l2tp-tproxy.service")
+	secrets := filepath.Join(etc, "chap-secrets")
+
+	// 第一次安装修改了 sysctl.conf 并新建了服务；第二次修改时备份的已是安装后的内容，不能用于卸载
+	writeSnapshot(t, root, "20240101-000000", []snapshotFile{
+		{Path: sysctl, Existed: true, Mode: 0644, Backup: "0000"},
+		{Path: unit},
+	}, map[string]string{"0000": "# 原始配置\n"})
+	writeSnapshot(t, root, "20240102-000000", []snapshotFile{
+		{Path: sysctl, Existed: true, Mode: 0644, Backup: "0000"},
+		{Path: secrets},
+	}, map[string]string{"0000": "net.ipv4.ip_forward = 1\n"})
+
+	originals, err := loadOriginals(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(originals) != 3 {
+		t.Fatalf("originals = %+v", originals)
+	}
+	for path, content := range map[string]string{sysctl: "net.ipv4.ip_forward = 1\n", unit: "[Unit]\n", secrets: "alice * pass *\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if failed := restoreOriginals(originals, false); len(failed) > 0 {
+		t.Fatalf("恢复失败: %v", failed)
+	}
+	if data, _ := os.ReadFile(sysctl); string(data) != "# 原始配置\n" {
+		t.Errorf("sysctl.conf 应恢复为第一个快照中的内容，实际 %q", data)
+	}
+	if fileExists(unit) || fileExists(secrets) {
+		t.Error("安装前不存在的文件应被删除")
+	}
+
+	if originals, err := loadOriginals(filepath.Join(root, "missing")); err != nil || originals != nil {
+		t.Errorf("没有快照时应返回空: %v, %v", originals, err)
+	}
+}
+
+func TestRestoreOriginalsKeepConfig(t *testing.T) {
+	dryRun, currentPlan = true, &plan{}
+	t.Cleanup(func() { dryRun, currentPlan = false, &plan{} })
+
+	unit := "/etc/systemd/system/l2tp-tproxy.service"
+	originals := []originalFile{
+		{snapshotFile: snapshotFile{Path: chapSecretsPath}},
+		{snapshotFile: snapshotFile{Path: ipsecDir + "/private/server.key"}},
+		{snapshotFile: snapshotFile{Path: installedConfigPath}},
+		{snapshotFile: snapshotFile{Path: unit}},
+		{snapshotFile: snapshotFile{Path: grubDefaultPath, Existed: true}},
+		{snapshotFile: snapshotFile{Path: grubConfigPath, Existed: true}},
+	}
+	// 保留配置时不触碰 VPN 配置与证书，系统状态仍然恢复；切换内核修改的引导配置总是保留
+	if failed := restoreOriginals(originals, true); len(failed) > 0 {
+		t.Fatalf("恢复失败: %v", failed)
+	}
+	if !slices.Equal(currentPlan.commands, []string{"rm -f " + unit}) {
+		t.Errorf("命令 = %v", currentPlan.commands)
+	}
+}
+
+func TestKeepMasquerade(t *testing.T) {
+	enabled, disabled := true, false
+	for _, tt := range []struct {
+		recorded *bool
+		want     bool
+	}{
+		{nil, true}, // 旧版本安装时没有记录，保留
+		{&enabled, true},
+		{&disabled, false},
+	} {
+		m := &installManifest{FirewalldMasquerade: tt.recorded}
+		if got := m.keepMasquerade(); got != tt.want {
+			t.Errorf("记录 %v 时 keepMasquerade = %v，期望 %v", tt.recorded, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"l2tp/internal/chap"
+)
+
+const chapSecretsPath = "/etc/ppp/chap-secrets"
+
+// 静态 IP 分配范围，与安装时生成的账号保持一致
+const (
+	firstUserHost = 10
+	lastUserHost  = 255
+)
+
+func userUsage() {
+	fmt.Println(`用法: l2tp user <命令> [选项] <用户名>
+
+命令:
+  list                      列出全部账号
+  add     <用户名>          添加账号，自动分配静态 IP
+  del     <用户名>          删除账号
+  passwd  <用户名>          修改密码
+  disable <用户名>          禁用账号 (保留记录与 IP)
+  enable  <用户名>          重新启用账号
+
+选项:
+  -server l2tpd|pptpd       账号所属服务 (默认 l2tpd)
+  -password <密码>          指定密码，省略时随机生成
+  -ip <IP>                  add 时指定静态 IP`)
+}
+
+// runUserCommand 处理 l2tp user 子命令
+func runUserCommand(args []string) error {
+	if len(args) == 0 {
+		userUsage()
+		return fmt.Errorf("缺少子命令")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	server := fs.String("server", "l2tpd", "账号所属服务: l2tpd 或 pptpd")
+	password := fs.String("password", "", "账号密码，省略时随机生成")
+	ip := fs.String("ip", "", "指定静态 IP")
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if err := chap.ValidServer(*server); err != nil {
+		return err
+	}
+
+	file, err := readChapSecrets()
+	if err != nil {
+		return err
+	}
+
+	if action == "list" {
+		listUsers(file, *server, isFlagSet(fs, "server"))
+		return nil
+	}
+
+	if len(positional) != 1 {
+		userUsage()
+		return fmt.Errorf("需要指定一个用户名")
+	}
+	name := positional[0]
+
+	switch action {
+	case "add":
+		prefix, err := serverIPRange(*server)
+		if err != nil {
+			return err
+		}
+		assigned := *ip
+		if assigned == "" {
+			if assigned, err = allocateIP(file, prefix, *server); err != nil {
+				return err
+			}
+		} else if err := checkIPInRange(assigned, prefix, *server); err != nil {
+			return err
+		}
+		secret, err := userSecret(*password)
+		if err != nil {
+			return err
+		}
+		if err := file.Add(chap.Entry{Client: name, Server: *server, Secret: secret, IPs: []string{assigned}}); err != nil {
+			return err
+		}
+		if err := saveChapSecrets(file); err != nil {
+			return err
+		}
+		fmt.Printf("%s 已添加 %s 账号: %s / 密码: %s / IP: %s\n", Info, *server, name, secret, assigned)
+	case "del":
+		if err := file.Remove(name, *server); err != nil {
+			return err
+		}
+		if err := saveChapSecrets(file); err != nil {
+			return err
+		}
+		fmt.Printf("%s 已删除 %s 账号: %s\n", Info, *server, name)
+	case "passwd":
+		secret, err := userSecret(*password)
+		if err != nil {
+			return err
+		}
+		if err := file.SetSecret(name, *server, secret); err != nil {
+			return err
+		}
+		if err := saveChapSecrets(file); err != nil {
+			return err
+		}
+		fmt.Printf("%s 已修改 %s 账号 %s 的密码: %s\n", Info, *server, name, secret)
+	case "disable", "enable":
+		if err := file.SetDisabled(name, *server, action == "disable"); err != nil {
+			return err
+		}
+		if err := saveChapSecrets(file); err != nil {
+			return err
+		}
+		state := "禁用"
+		if action == "enable" {
+			state = "启用"
+		}
+		fmt.Printf("%s 已%s %s 账号: %s\n", Info, state, *server, name)
+	default:
+		userUsage()
+		return fmt.Errorf("未知的子命令: %s", action)
+	}
+	return nil
+}
+
+// userSecret 校验指定的密码，未指定时按安装时的密码规则随机生成
+func userSecret(password string) (string, error) {
+	cfg, err := loadInstalledConfig()
+	if err != nil {
+		cfg = &Config{}
+	}
+	policy, err := cfg.passwordPolicy()
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return policy.Generate()
+	}
+	if err := policy.Check(password); err != nil {
+		return "", fmt.Errorf("密码%v", err)
+	}
+	return password, nil
+}
+
+// parseInterspersed 允许选项出现在用户名之后，例如 user add alice -server pptpd
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func listUsers(file *chap.File, server string, filter bool) {
+	fmt.Printf("%-20s %-8s %-16s %s\n", "用户名", "服务", "IP", "状态")
+	count := 0
+	for _, e := range file.Entries() {
+		if filter && e.Server != server {
+			continue
+		}
+		state := "启用"
+		if e.Disabled {
+			state = "禁用"
+		}
+		ip := e.IP()
+		if ip == "" {
+			ip = "*"
+		}
+		fmt.Printf("%-20s %-8s %-16s %s\n", e.Client, e.Server, ip, state)
+		count++
+	}
+	fmt.Printf("\n共 %d 个账号\n", count)
+}
+
+func readChapSecrets() (*chap.File, error) {
+	data, err := os.ReadFile(chapSecretsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取 %s 失败: %v", chapSecretsPath, err)
+	}
+	file, err := chap.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", chapSecretsPath, err)
+	}
+	return file, nil
+}
+
+// saveChapSecrets 写回 chap-secrets，pppd 每次认证都会重新读取，无需重启服务
+func saveChapSecrets(file *chap.File) error {
+	return withTransaction(func() error {
+		return writeFile(chapSecretsPath, file.Bytes(), 0600)
+	})
+}
+
+// serverIPRange 返回服务对应的 /24 网段前缀
+func serverIPRange(server string) (string, error) {
+	cfg, err := loadInstalledConfig()
+	if err != nil {
+		return "", err
+	}
+	if (server == "l2tpd" && !cfg.l2tpEnabled()) || (server == "pptpd" && !cfg.pptpEnabled()) {
+		return "", fmt.Errorf("安装时未启用 %s (protocol: %s)", server, cfg.Protocol)
+	}
+	prefix := cfg.L2TP.IPRange
+	if server == "pptpd" {
+		prefix = cfg.PPTP.IPRange
+	}
+	if prefix == "" {
+		return "", fmt.Errorf("%s 中缺少 %s 的 IP 范围", installedConfigPath, server)
+	}
+	return prefix, nil
+}
+
+// allocateIP 在服务网段 prefix 中分配第一个未被占用的静态 IP
+func allocateIP(file *chap.File, prefix, server string) (string, error) {
+	used := file.UsedIPs()
+	for host := firstUserHost; host <= lastUserHost; host++ {
+		ip := fmt.Sprintf("%s.%d", prefix, host)
+		if !used[ip] {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("%s 网段 %s.0/24 已无可用 IP，可先删除不再使用的账号或使用 -ip 指定已释放的 IP", server, prefix)
+}
+
+// checkIPInRange 检查手动指定的 IP 是否位于服务的客户端网段 prefix 内
+func checkIPInRange(ip, prefix, server string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.To4() == nil || !strings.HasPrefix(ip, prefix+".") {
+		return fmt.Errorf("IP %s 不在 %s 的网段 %s.0/24 内", ip, server, prefix)
+	}
+	host := int(parsed.To4()[3])
+	if host < firstUserHost || host > lastUserHost {
+		return fmt.Errorf("IP %s 超出可分配范围 %s.%d-%s.%d", ip, prefix, firstUserHost, prefix, lastUserHost)
+	}
+	return nil
+}
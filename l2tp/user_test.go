@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"l2tp/internal/chap"
+	"l2tp/internal/render"
+)
+
+// installedChapSecrets 按安装流程渲染 chap-secrets 并解析，与安装后磁盘上的文件一致
+func installedChapSecrets(t *testing.T, cfg *Config) *chap.File {
+	t.Helper()
+	cfg.L2TP.User, cfg.L2TP.Password = "vpnuser", "Xk7pQ2mWz9Lr"
+	cfg.PPTP.User, cfg.PPTP.Password = "pptpuser", "Rt5nVb8QwE2k"
+	users, err := chapUsers(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := render.Render(render.Settings{
+		PublicIP: "203.0.113.10",
+		L2TP:     render.L2TP{Enabled: true, IPRange: cfg.L2TP.IPRange, Port: cfg.L2TP.Port, PSK: "9fKq2LmX7pWz4TnB8vRc3HdY"},
+		PPTP:     render.PPTP{Enabled: true, IPRange: cfg.PPTP.IPRange},
+		Users:    users,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if f.Path == chapSecretsPath {
+			file, err := chap.Parse(f.Content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return file
+		}
+	}
+	t.Fatalf("安装结果中没有 %s", chapSecretsPath)
+	return nil
+}
+
+func testConfig() *Config {
+	return &Config{
+		L2TP:  L2TPConfig{IPRange: "10.10.10", Port: "1701"},
+		PPTP:  PPTPConfig{IPRange: "192.168.30", Port: "1723"},
+		IKEv2: IKEv2Config{Enabled: true, IPRange: "10.10.20"},
+	}
+}
+
+func TestUserAddAfterDefaultInstall(t *testing.T) {
+	cfg := testConfig()
+	file := installedChapSecrets(t, cfg)
+
+	// 默认安装只有主账号占用 .10，user add 不指定 -ip 时从 .11 起分配
+	for _, want := range []string{"10.10.10.11", "10.10.10.12"} {
+		ip, err := allocateIP(file, cfg.L2TP.IPRange, "l2tpd")
+		if err != nil {
+			t.Fatalf("默认安装后 user add 分配 IP 失败: %v", err)
+		}
+		if ip != want {
+			t.Errorf("分配的 IP = %s，期望 %s", ip, want)
+		}
+		name := "alice" + strings.ReplaceAll(ip, ".", "")
+		if err := file.Add(chap.Entry{Client: name, Server: "l2tpd", Secret: "secret", IPs: []string{ip}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ip, err := allocateIP(file, cfg.PPTP.IPRange, "pptpd"); err != nil || ip != "192.168.30.11" {
+		t.Errorf("pptpd 分配的 IP = %s, %v", ip, err)
+	}
+}
+
+func TestUserAddWithBulkUsers(t *testing.T) {
+	cfg := testConfig()
+	cfg.BulkUsers = 20
+	file := installedChapSecrets(t, cfg)
+	if ip, err := allocateIP(file, cfg.L2TP.IPRange, "l2tpd"); err != nil || ip != "10.10.10.31" {
+		t.Errorf("20 个批量账号之后分配的 IP = %s, %v，期望 10.10.10.31", ip, err)
+	}
+
+	// 批量账号占满网段时提示删除账号或指定 IP
+	cfg.BulkUsers = lastUserHost - firstUserHost
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := allocateIP(installedChapSecrets(t, cfg), cfg.L2TP.IPRange, "l2tpd"); err == nil {
+		t.Error("网段已满时应返回错误")
+	}
+	cfg.BulkUsers++
+	if err := cfg.validate(); err == nil {
+		t.Error("bulk_users 超出网段时应返回错误")
+	}
+}